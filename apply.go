@@ -0,0 +1,260 @@
+package viaduct
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrResourcesFailed is returned by Apply when the run finished, but one or
+// more resources failed. The report lists them under Failures.
+var ErrResourcesFailed = errors.New("resources failed")
+
+// ErrAlreadyApplied is returned by Apply when the manifest has been applied
+// before. The first run leaves each resource with its status, and pruning and
+// tags with what they changed, so a second one would start from there rather
+// than from scratch. Build a new manifest to run again.
+var ErrAlreadyApplied = errors.New("manifest has already been applied")
+
+// RunReport is what a run did to each resource. Apply returns it, and it is
+// what --json prints.
+type RunReport struct {
+	Status   string    `json:"status"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Duration is how long the run took, to the second.
+	Duration  string           `json:"duration"`
+	Summary   RunSummary       `json:"summary"`
+	Resources []ResourceResult `json:"resources"`
+	Failures  []FailureSummary `json:"failures,omitempty"`
+	Drift     []Drift          `json:"drift,omitempty"`
+	// Profile is where the time in the run went, with --profile.
+	Profile *RunProfile `json:"profile,omitempty"`
+}
+
+// RunOutput is the top-level JSON output for a run.
+//
+// Deprecated: use RunReport, which is the same thing.
+type RunOutput = RunReport
+
+// PreflightError is returned by Apply when the preflight checks of one or
+// more resources failed, so nothing was applied.
+type PreflightError struct {
+	Failures []PreflightFailure
+}
+
+// PreflightFailure is a resource whose preflight checks failed.
+type PreflightFailure struct {
+	ResourceID   ResourceID
+	ResourceKind ResourceKind
+	Err          error
+}
+
+func (e *PreflightError) Error() string {
+	failures := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		failures = append(failures, fmt.Sprintf("%s %s: %s", f.ResourceKind, f.ResourceID, f.Err))
+	}
+
+	return "preflight checks failed: " + strings.Join(failures, "; ")
+}
+
+// checkError is a problem with the manifest itself, found before anything
+// runs. msg is what Run logs it as.
+type checkError struct {
+	msg string
+	err error
+}
+
+func (e *checkError) Error() string {
+	return e.err.Error()
+}
+
+func (e *checkError) Unwrap() error {
+	return e.err
+}
+
+// Apply runs the manifest and reports what it did, without printing the report
+// or exiting, so that viaduct can be embedded in something that outlives the
+// run. Run is the same thing for a standalone binary.
+//
+// A problem with the manifest, such as a dependency cycle or a failed
+// preflight check, is returned as an error before anything is applied, with
+// a nil report. A run where resources failed returns the report along with
+// ErrResourcesFailed.
+//
+// Cancelling ctx stops anything else from starting, and cancels the resources
+// that are running if they implement ContextRunner. A manifest can only be
+// applied once, and applying it again returns ErrAlreadyApplied.
+func (m *Manifest) Apply(ctx context.Context) (*RunReport, error) {
+	if !m.applied.CompareAndSwap(false, true) {
+		return nil, ErrAlreadyApplied
+	}
+
+	l := m.rt.NewLogger("Viaduct", "Run")
+	start := time.Now()
+	m.emit(RunEvent{Type: EventRunStarted, Resources: len(m.resources)})
+	l.Info("started")
+	l.Info("preflight-checks")
+
+	m.collector = newResultCollector()
+
+	if err := m.prepare(l); err != nil {
+		m.emit(RunEvent{Type: EventPreflight, Status: "failed", Error: err.Error()})
+		return nil, err
+	}
+
+	m.emit(RunEvent{Type: EventPreflight, Status: "ok"})
+
+	s := newScheduler(m, m.concurrencyFor())
+	s.ctx = ctx
+	s.run()
+
+	// Whether the run failed and what gets reported come from the same place,
+	// so a resource can never fail the run without being named.
+	failures := collectFailures(m.resources)
+
+	// A dry run has not changed anything, so the last real run's state still
+	// stands
+	if !m.rt.Options.DryRun && !m.stateDisabled {
+		if err := m.saveState(); err != nil {
+			l.Warn("state-not-saved", "path", m.stateFile(), "error", err.Error())
+		}
+	}
+
+	finished := time.Now()
+
+	report := &RunReport{
+		Status:    "success",
+		Started:   start,
+		Finished:  finished,
+		Duration:  finished.Sub(start).Round(time.Second).String(),
+		Summary:   summarise(m.resources),
+		Resources: m.collector.Results(),
+		Failures:  failures,
+	}
+
+	if m.rt.Options.Drift {
+		report.Drift = m.lastState.drift(m.resources)
+	}
+
+	if n := m.rt.Options.Profile; n > 0 {
+		report.Profile = m.profile(n)
+	}
+
+	if len(failures) > 0 {
+		report.Status = "failed"
+	}
+
+	if err := m.writeReports(report); err != nil {
+		l.Warn("report-not-written", "error", err.Error())
+	}
+
+	m.emit(RunEvent{
+		Type:     EventRunFinished,
+		Status:   report.Status,
+		Duration: report.Duration,
+		Summary:  &report.Summary,
+		Profile:  report.Profile,
+		Drift:    report.Drift,
+	})
+
+	if len(failures) > 0 {
+		return report, fmt.Errorf("%w: %s", ErrResourcesFailed, report.Summary)
+	}
+
+	return report, nil
+}
+
+// prepare does everything a run does before applying anything: it checks the
+// manifest, picks what runs, reads the state, adds what is being pruned, and
+// runs the preflight and conflict checks.
+func (m *Manifest) prepare(l *Logger) error {
+	if _, err := m.rt.openLogFile(); err != nil {
+		return &checkError{"log-file-unwritable", err}
+	}
+
+	for _, r := range m.rt.Options.Reports {
+		if _, _, err := parseReport(r); err != nil {
+			return &checkError{"report-invalid", err}
+		}
+	}
+
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	sel := m.selection()
+
+	deselected, err := m.deselect(sel)
+	if err != nil {
+		return &checkError{"selection-failed", err}
+	}
+	m.deselected = deselected
+
+	// Drift and pruning are both measured against the last run that was not
+	// a dry run, so the state is read before anything can replace it. Without
+	// either, it is only needed to keep what a partial run left out
+	if !m.stateDisabled {
+		var err error
+		if m.lastState, err = loadState(m.stateFile()); err != nil {
+			if m.rt.Options.Drift || m.prune {
+				return &checkError{"state-unreadable", err}
+			}
+
+			l.Warn("state-unreadable", "error", err.Error())
+		}
+	}
+
+	// A drift report already lists what is no longer in the manifest, and a
+	// partial run can't tell what was removed from what was left out
+	if m.prune && !m.rt.Options.Drift && !sel.active() {
+		m.addPrunes(m.lastState, l)
+	}
+
+	if err := m.preflight(); err != nil {
+		return err
+	}
+
+	// Preflight checks fill in defaults, such as the path a file is
+	// downloaded to, so what each resource manages is only known once they
+	// have run
+	if err := m.conflictCheck(); err != nil {
+		return &checkError{"conflicting-resources", err}
+	}
+
+	return nil
+}
+
+// preflight runs the preflight checks of every resource in the run, returning
+// a PreflightError listing any that failed.
+func (m *Manifest) preflight() error {
+	var failed []PreflightFailure
+
+	for _, id := range slices.Sorted(maps.Keys(m.resources)) {
+		// What is left out of the run is never applied, so it doesn't need to
+		// be able to run on this machine either
+		if m.deselected[id] {
+			continue
+		}
+
+		r := m.resources[id]
+		if err := r.preflight(m.rt); err != nil {
+			r.Err = err
+			r.Message = err.Error()
+			m.resources[id] = r
+
+			failed = append(failed, PreflightFailure{ResourceID: id, ResourceKind: r.ResourceKind, Err: err})
+		}
+	}
+
+	if len(failed) > 0 {
+		return &PreflightError{Failures: failed}
+	}
+
+	return nil
+}
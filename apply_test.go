@@ -0,0 +1,162 @@
+package viaduct
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unfitResourceType is a test resource whose preflight checks fail
+type unfitResourceType struct {
+	testResourceType
+}
+
+func (u *unfitResourceType) PreflightChecks(log *Logger) error {
+	return errors.New("unfit")
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	t.Run("reports what the run did", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+		m.Add(newChangingTestResource("changed"))
+		m.Add(newTestResource("unchanged"))
+
+		report, err := m.Apply(context.Background())
+		assert.NoError(t, err)
+
+		if assert.NotNil(t, report) {
+			assert.Equal(t, "success", report.Status)
+			assert.Equal(t, RunSummary{Resources: 2, Changed: 1, Unchanged: 1}, report.Summary)
+			assert.Len(t, report.Resources, 2)
+			assert.Empty(t, report.Failures)
+		}
+	})
+
+	t.Run("failed resources are in the report", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+		failing := m.Add(newFailingTestResource("failing"))
+		m.Add(newTestResource("after"), failing)
+
+		report, err := m.Apply(context.Background())
+		assert.ErrorIs(t, err, ErrResourcesFailed)
+
+		if assert.NotNil(t, report) {
+			assert.Equal(t, "failed", report.Status)
+			assert.Equal(t, 2, report.Summary.Failed)
+
+			if assert.Len(t, report.Failures, 1) {
+				assert.Equal(t, string(failing.ResourceID), report.Failures[0].ResourceID)
+				assert.Len(t, report.Failures[0].Dependents, 1)
+			}
+		}
+	})
+
+	t.Run("failed preflight checks apply nothing", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+		fine := newTestResource("fine")
+		m.Add(fine)
+		unfit := m.Add(&unfitResourceType{testResourceType{Value: "unfit"}})
+
+		report, err := m.Apply(context.Background())
+		assert.Nil(t, report)
+		assert.False(t, fine.ran.Load())
+
+		var preflight *PreflightError
+		if assert.ErrorAs(t, err, &preflight) && assert.Len(t, preflight.Failures, 1) {
+			assert.Equal(t, unfit.ResourceID, preflight.Failures[0].ResourceID)
+			assert.EqualError(t, preflight.Failures[0].Err, "unfit")
+		}
+	})
+
+	t.Run("a dependency cycle applies nothing", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+		a := m.Add(newTestResource("a"))
+		b := m.Add(newTestResource("b"), a)
+		m.SetDep(a, string(b.ResourceID))
+
+		report, err := m.Apply(context.Background())
+		assert.Nil(t, report)
+		assert.Error(t, err)
+	})
+
+	t.Run("applied only once", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+		r := newChangingTestResource("a")
+		m.Add(r)
+
+		_, err := m.Apply(context.Background())
+		assert.NoError(t, err)
+
+		report, err := m.Apply(context.Background())
+		assert.Nil(t, report)
+		assert.ErrorIs(t, err, ErrAlreadyApplied)
+		assert.Equal(t, int32(1), r.runs.Load())
+	})
+
+	t.Run("applied only once, even when the first run stopped early", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+		m.Add(&unfitResourceType{testResourceType{Value: "unfit"}})
+
+		_, err := m.Apply(context.Background())
+		var preflight *PreflightError
+		assert.ErrorAs(t, err, &preflight)
+
+		_, err = m.Apply(context.Background())
+		assert.ErrorIs(t, err, ErrAlreadyApplied)
+	})
+
+	t.Run("a cancelled context starts nothing", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+		r := newTestResource("a")
+		m.Add(r)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		report, err := m.Apply(ctx)
+		assert.ErrorIs(t, err, ErrResourcesFailed)
+		assert.False(t, r.ran.Load())
+
+		if assert.NotNil(t, report) && assert.Len(t, report.Failures, 1) {
+			assert.Contains(t, report.Failures[0].Error, context.Canceled.Error())
+		}
+	})
+}
+
+func TestAddE(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+
+	r, err := m.AddE(newTestResource("a"))
+	assert.NoError(t, err)
+	assert.NotNil(t, r)
+
+	_, err = m.AddE(newTestResource("a"))
+	assert.ErrorContains(t, err, "resource already exists")
+}
@@ -45,9 +45,14 @@ type PlatformAttributes struct {
 	UbuntuCodename   string `json:"ubuntuCodename"`
 }
 
-// SetUser allows us to assign a default username
+// SetUser allows us to assign a default username. It logs according to the
+// options of the default runtime: see Runtime.SetUser.
 func (a *SystemAttributes) SetUser(username string) {
-	NewLogger("Attribute", "Set").Info(fmt.Sprintf("User -> %s", username))
+	a.setUser(NewLogger("Attribute", "Set"), username)
+}
+
+func (a *SystemAttributes) setUser(l *Logger, username string) {
+	l.Info(fmt.Sprintf("User -> %s", username))
 
 	u, err := user.Lookup(username)
 	if err != nil {
@@ -57,13 +62,57 @@ func (a *SystemAttributes) SetUser(username string) {
 	a.User = *u
 }
 
-// initAttributes populates the attributes
+// initAttributes populates the attributes, keeping any the program has
+// already set.
 func initAttributes(a *SystemAttributes) {
-	user, err := user.Current()
+	attributes, err := newAttributes()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if a.User.Username == "" {
+		a.User = attributes.User
+	}
+
+	if a.runuser.Username == "" {
+		a.runuser = attributes.runuser
+	}
+
+	if a.Platform == (PlatformAttributes{}) {
+		a.Platform = attributes.Platform
+	}
+
+	if a.Custom == nil {
+		a.Custom = attributes.Custom
+	}
+
+	if a.OS == "" {
+		a.OS = attributes.OS
+	}
+
+	if a.Arch == "" {
+		a.Arch = attributes.Arch
+	}
+
+	if a.Hostname == "" {
+		a.Hostname = attributes.Hostname
+	}
+
+	if a.TmpDir == "" {
+		a.TmpDir = attributes.TmpDir
+	}
+}
+
+// newAttributes reads the attributes of the machine, and creates a new
+// temporary directory for the run.
+func newAttributes() (SystemAttributes, error) {
+	var a SystemAttributes
+
+	user, err := user.Current()
+	if err != nil {
+		return a, err
+	}
+
 	a.User = *user
 	a.OS = runtime.GOOS
 	a.Arch = runtime.GOARCH
@@ -72,28 +121,29 @@ func initAttributes(a *SystemAttributes) {
 
 	a.Hostname, err = os.Hostname()
 	if err != nil {
-		log.Fatal(err)
+		return a, err
 	}
 
 	if a.OS == "linux" {
 		a.Platform = newPlatformAttributes(LinuxOsReleaseFile)
 	}
 
-	tmpDirPath = filepath.Join(a.User.HomeDir, ".viaduct", "tmp")
+	tmpDirPath := filepath.Join(a.User.HomeDir, ".viaduct", "tmp")
 
 	err = os.MkdirAll(tmpDirPath, 0o755)
 	if err != nil {
-		log.Fatal(err)
+		return a, err
 	}
 
-	tmpdir, err := os.MkdirTemp(tmpDirPath, "")
+	// Create a new temporary dir for each run
+	a.TmpDir, err = os.MkdirTemp(tmpDirPath, "")
 	if err != nil {
-		log.Fatal(err)
+		return a, err
 	}
-	// Create a new temporary dir for each run
-	a.TmpDir = tmpdir
 
 	a.Custom = make(map[string]string)
+
+	return a, nil
 }
 
 // JSON returns a string representation of the loaded attributes
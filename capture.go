@@ -0,0 +1,152 @@
+package viaduct
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// outputLimit is how much of each of a command's standard output and error is
+// kept. Anything before the last outputLimit bytes is dropped, since the end
+// of the output is where a command says why it failed.
+const outputLimit = 64 << 10
+
+// CapturedOutput is the output of a command that a resource runs, captured so
+// it is in the resource's log and its failure however the run is reported.
+type CapturedOutput struct {
+	log     *Logger
+	command string
+	stdout  *outputBuffer
+	stderr  *outputBuffer
+}
+
+// Capture captures the standard output and error of a command that has not
+// started yet. When the run is logging to the console, the output is copied
+// there as well as the command runs: standard error unless silent, and
+// standard output with teeStdout, unless quiet or silent. A standard output
+// the command already has, such as a file it writes to, is left alone.
+//
+// Call Done with what the command returned once it has finished.
+func (l *Logger) Capture(cmd *exec.Cmd, teeStdout bool) *CapturedOutput {
+	o := &CapturedOutput{
+		log:     l,
+		command: strings.Join(cmd.Args, " "),
+		stderr:  &outputBuffer{},
+	}
+
+	console := !l.Silent && l.Runtime().onConsole(l.jsonMode)
+
+	if cmd.Stdout == nil {
+		o.stdout = &outputBuffer{}
+		cmd.Stdout = tee(o.stdout, os.Stdout, console && teeStdout && !l.Quiet)
+	}
+
+	cmd.Stderr = tee(o.stderr, os.Stderr, console)
+
+	return o
+}
+
+// tee writes to w, and to console as well if on is set.
+func tee(w, console io.Writer, on bool) io.Writer {
+	if on {
+		return io.MultiWriter(w, console)
+	}
+
+	return w
+}
+
+// Done logs the output of the command once it has finished, and returns err as
+// a CommandError carrying the output if the command failed.
+func (o *CapturedOutput) Done(err error) error {
+	var stdout string
+	if o.stdout != nil {
+		stdout = o.stdout.String()
+	}
+
+	stderr := o.stderr.String()
+
+	if stdout != "" || stderr != "" {
+		fields := []any{"command", o.command}
+
+		if stdout != "" {
+			fields = append(fields, "stdout", stdout)
+		}
+
+		if stderr != "" {
+			fields = append(fields, "stderr", stderr)
+		}
+
+		o.log.log(LevelOutput, "command-output", fields)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	return &CommandError{Command: o.command, Stdout: stdout, Stderr: stderr, Err: err}
+}
+
+// CommandError is a command that failed, with the output it left behind.
+type CommandError struct {
+	Command string
+	Stdout  string
+	Stderr  string
+	Err     error
+}
+
+// Error is how the command failed, followed by the last line it wrote to
+// standard error, which is usually the reason.
+func (e *CommandError) Error() string {
+	if line := lastLine(e.Stderr); line != "" {
+		return e.Err.Error() + ": " + line
+	}
+
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// lastLine returns the last line of s that isn't blank.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// outputBuffer keeps the last outputLimit bytes written to it.
+type outputBuffer struct {
+	mu      sync.Mutex
+	buf     []byte
+	dropped int
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+
+	if over := len(b.buf) - outputLimit; over > 0 {
+		b.dropped += over
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+
+	return len(p), nil
+}
+
+// String returns what was kept, saying how much was dropped before it.
+func (b *outputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.dropped > 0 {
+		return fmt.Sprintf("[%d bytes dropped]\n%s", b.dropped, b.buf)
+	}
+
+	return string(b.buf)
+}
@@ -0,0 +1,163 @@
+package viaduct
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCommandResourceType is a test resource that runs a shell command with
+// its output captured
+type testCommandResourceType struct {
+	testResourceType
+	Command string
+}
+
+func (t *testCommandResourceType) Run(log *Logger) error {
+	cmd := exec.Command("bash", "-c", t.Command)
+	return log.Capture(cmd, true).Done(cmd.Run())
+}
+
+func TestCapture(t *testing.T) {
+	t.Parallel()
+
+	t.Run("into the log", func(t *testing.T) {
+		t.Parallel()
+
+		rt, sink := newSinkRuntime(Options{JSON: true})
+		l := rt.NewLogger("Execute", "Run")
+
+		cmd := exec.Command("bash", "-c", "echo out; echo err >&2")
+		assert.NoError(t, l.Capture(cmd, true).Done(cmd.Run()))
+
+		assert.Equal(t, []LogEntry{{
+			Level:   string(LevelOutput),
+			Message: "command-output",
+			Fields: map[string]any{
+				"command": "bash -c echo out; echo err >&2",
+				"stdout":  "out\n",
+				"stderr":  "err\n",
+			},
+		}}, l.Entries())
+
+		assert.Len(t, sink.events, 1, "the other sinks see it too")
+	})
+
+	t.Run("nothing written", func(t *testing.T) {
+		t.Parallel()
+
+		rt, _ := newSinkRuntime(Options{JSON: true})
+		l := rt.NewLogger("Execute", "Run")
+
+		cmd := exec.Command("true")
+		assert.NoError(t, l.Capture(cmd, true).Done(cmd.Run()))
+		assert.Empty(t, l.Entries())
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		t.Parallel()
+
+		rt, _ := newSinkRuntime(Options{JSON: true})
+		l := rt.NewLogger("Package", "Install")
+
+		cmd := exec.Command("bash", "-c", "echo Reading package lists; echo 'E: Unable to locate package nope' >&2; echo >&2; exit 100")
+		err := l.Capture(cmd, false).Done(cmd.Run())
+
+		assert.EqualError(t, err, "exit status 100: E: Unable to locate package nope")
+
+		var cmdErr *CommandError
+		if assert.ErrorAs(t, err, &cmdErr) {
+			assert.Equal(t, "Reading package lists\n", cmdErr.Stdout)
+			assert.Equal(t, "E: Unable to locate package nope\n\n", cmdErr.Stderr)
+		}
+
+		var exitErr *exec.ExitError
+		assert.ErrorAs(t, err, &exitErr)
+	})
+
+	t.Run("a standard output of its own", func(t *testing.T) {
+		t.Parallel()
+
+		rt, _ := newSinkRuntime(Options{JSON: true})
+		l := rt.NewLogger("Apt", "Create")
+
+		var out strings.Builder
+		cmd := exec.Command("echo", "key")
+		cmd.Stdout = &out
+
+		assert.NoError(t, l.Capture(cmd, false).Done(cmd.Run()))
+		assert.Equal(t, "key\n", out.String())
+		assert.Empty(t, l.Entries())
+	})
+
+	t.Run("in the failures", func(t *testing.T) {
+		t.Parallel()
+
+		rt, _ := newSinkRuntime(Options{JSON: true})
+		m := NewWithRuntime(rt)
+		m.DisableState()
+		addNamed(m, "a", &testCommandResourceType{testResourceType: testResourceType{Value: "a"}, Command: "echo broken >&2; exit 1"})
+
+		report, err := m.Apply(context.Background())
+		assert.ErrorIs(t, err, ErrResourcesFailed)
+
+		if assert.Len(t, report.Failures, 1) {
+			assert.Equal(t, "exit status 1: broken", report.Failures[0].Error)
+			assert.Equal(t, "broken\n", report.Failures[0].Stderr)
+		}
+
+		if assert.Len(t, report.Resources, 1) && assert.Len(t, report.Resources[0].Log, 1) {
+			assert.Equal(t, "broken\n", report.Resources[0].Log[0].Fields["stderr"])
+		}
+	})
+}
+
+func TestFailuresTreeStderr(t *testing.T) {
+	t.Parallel()
+
+	var stderr strings.Builder
+	for i := 1; i <= 12; i++ {
+		fmt.Fprintf(&stderr, "line %d\n", i)
+	}
+
+	rt, sink := newSinkRuntime(Options{})
+	printFailuresTree([]FailureSummary{{
+		ResourceKind: "Execute",
+		Operation:    "Run",
+		Description:  "make",
+		Error:        "command failed: make: exit status 2: line 12",
+		Stderr:       stderr.String(),
+	}}, rt.NewLogger("Viaduct", "Run"))
+
+	if assert.Len(t, sink.events, 1) {
+		tree := sink.events[0].Message
+		assert.Contains(t, tree, "  Stderr:\n    | line 3\n")
+		assert.Contains(t, tree, "    | line 12\n")
+		assert.NotContains(t, tree, "line 2\n", "only the end is shown")
+	}
+}
+
+func TestOutputBuffer(t *testing.T) {
+	t.Parallel()
+
+	var b outputBuffer
+	_, err := b.Write([]byte(strings.Repeat("a", outputLimit)))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", outputLimit), b.String())
+
+	_, err = b.Write([]byte("bcd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[3 bytes dropped]\n"+strings.Repeat("a", outputLimit-3)+"bcd", b.String(), "the end is kept")
+}
+
+func TestCommandError(t *testing.T) {
+	t.Parallel()
+
+	err := &CommandError{Err: errors.New("exit status 1")}
+	assert.EqualError(t, err, "exit status 1", "nothing on standard error")
+}
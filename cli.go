@@ -9,39 +9,137 @@ import (
 	flag "github.com/spf13/pflag"
 )
 
-// CliFlags configures the command-line toolset
-type CliFlags struct {
+// Options are how a run has been asked to run. A standalone binary reads them
+// from the command line into Cli, and a program embedding viaduct passes them
+// to NewRuntime.
+type Options struct {
+	// Apply runs the manifest along with Graph, which on its own only
+	// prints the graph.
+	Apply      bool
 	Attributes bool
 	// ResourceTimeout overrides how long each resource is given to run.
 	// Zero means unset, and a negative duration means no timeout.
 	ResourceTimeout time.Duration
-	DryRun          bool
-	DumpManifest    bool
-	JSON            bool
-	Quiet           bool
-	Silent          bool
-	Stdout          bool
+	// Concurrency overrides how many resources run at once. Zero means
+	// unset, and a negative number means no limit.
+	Concurrency int
+	DryRun      bool
+	// Drift compares the machine against the state saved by the last run,
+	// listing the resources that would change. It implies a dry run.
+	Drift        bool
+	DumpManifest bool
+	// FailFast stops the run from starting anything else once a resource
+	// has failed.
+	FailFast bool
+	// Graph prints the dependency graph in this format, GraphDOT,
+	// GraphMermaid or GraphJSON, without running anything. With Apply or
+	// DryRun it is printed once the run has finished instead, in place of
+	// the JSON report, with each resource coloured by its status.
+	Graph string
+	JSON  bool
+	// LogFile is a file to write log events to as well, as lines of JSON.
+	LogFile string
+	// ListKinds lists the registered resource kinds with their fields, and
+	// exits.
+	ListKinds bool
+	// NoDeps stops the dependencies of the resources picked with Only and
+	// OnlyIDs from being picked with them.
+	NoDeps bool
+	// Only narrows the run down to the resources with any of these tags,
+	// along with what they depend on.
+	Only []string
+	// OnlyIDs narrows the run down to these resources, along with what they
+	// depend on.
+	OnlyIDs []string
+	// Output is how the run reports what it does: OutputText for the
+	// console, OutputJSON for a report once it has finished, which is the
+	// same as JSON, or OutputJSONL for a line of JSON for each RunEvent as
+	// it happens.
+	Output string
+	// Plan prints the waves the manifest would run in, without running
+	// anything.
+	Plan bool
+	// Profile lists this many of the slowest resources once the run has
+	// finished, along with the critical path through the dependency graph.
+	// Zero means no profile.
+	Profile int
+	Quiet   bool
+	// Reports are files to write the report of the run to once it has
+	// finished, for a CI system to read. Each is given as format:path, with
+	// ReportJUnit or ReportTAP as the format.
+	Reports []string
+	// Retries overrides how many times a resource that would be retried is
+	// retried. Zero means unset, and a negative number turns retries off.
+	Retries int
+	Silent  bool
+	// Skip leaves the resources with any of these tags out of the run.
+	Skip   []string
+	Stdout bool
 }
 
+// CliFlags is the name Options had before they could be set without the
+// command line.
+type CliFlags = Options
+
+// defaultProfile is how many of the slowest resources --profile lists, when it
+// is not given a number.
+const defaultProfile = 10
+
 // initCli loads command-line options
-func initCli(c *CliFlags) {
+func initCli(c *Options) {
 	var (
+		apply           bool
 		attributes      bool
 		resourceTimeout time.Duration
+		concurrency     int
 		dryRun          bool
+		drift           bool
 		dumpManifest    bool
+		failFast        bool
+		graph           string
 		jsonOutput      bool
+		listKinds       bool
+		logFile         string
+		noDeps          bool
+		only            []string
+		onlyIDs         []string
+		output          string
+		plan            bool
+		profile         int
 		quiet           bool
+		reports         []string
+		retries         int
 		silent          bool
+		skip            []string
 		stdout          bool
 	)
 
 	flag.DurationVar(&resourceTimeout, "resource-timeout", envDuration("VIADUCT_RESOURCE_TIMEOUT"),
 		"How long a single resource is given to run before the run gives up on it, overriding the manifest. A negative value means no timeout")
+	flag.IntVar(&concurrency, "concurrency", 0,
+		"How many resources run at once, overriding the manifest. A negative value means no limit")
 	flag.BoolVar(&dryRun, "dry-run", false, "Test changes with dry-run mode")
+	flag.BoolVar(&drift, "drift", false, "List the resources that would change since the last run, without changing anything")
 	flag.BoolVar(&attributes, "attributes", false, "Display known attributes")
+	flag.BoolVar(&apply, "apply", false, "With --graph, apply the manifest and print the graph once the run has finished")
 	flag.BoolVar(&dumpManifest, "dump-manifest", false, "Dump the full manifest after the run")
+	flag.BoolVar(&failFast, "fail-fast", false, "Stop starting new resources once one has failed")
+	flag.StringVar(&graph, "graph", "", "Print the dependency graph, as dot, mermaid or json, without running anything")
 	flag.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	flag.BoolVar(&listKinds, "list-kinds", false, "List the resource kinds with their fields")
+	flag.StringVar(&logFile, "log-file", "", "Also write log events to this file, as lines of JSON")
+	flag.StringSliceVar(&only, "only", nil, "Only apply the resources with these tags, and what they depend on")
+	flag.StringSliceVar(&onlyIDs, "only-id", nil, "Only apply the resources with these IDs, and what they depend on")
+	flag.StringSliceVar(&skip, "skip", nil, "Leave the resources with these tags out of the run")
+	flag.BoolVar(&noDeps, "no-deps", false, "Don't apply what the resources picked with --only or --only-id depend on")
+	flag.StringVar(&output, "output", "", "How to report the run: text, json for a report once it has finished, or jsonl for a line of JSON for each event as it happens")
+	flag.BoolVar(&plan, "plan", false, "Print the waves the resources would run in, without running anything")
+	flag.IntVar(&profile, "profile", 0,
+		"List this many of the slowest resources after the run, and the critical path through the dependencies (default 10 without a number)")
+	flag.Lookup("profile").NoOptDefVal = strconv.Itoa(defaultProfile)
+	flag.StringSliceVar(&reports, "report", nil, "Write the report to a file once the run has finished, as junit:path or tap:path")
+	flag.IntVar(&retries, "retries", 0,
+		"How many times a retryable resource is retried before it fails, overriding the manifest. A negative value turns retries off")
 	flag.BoolVar(&quiet, "quiet", false, "Quiet mode will only display errors during a run")
 	flag.BoolVar(&silent, "silent", false, "Silent mode will suppress all output")
 	flag.BoolVar(&stdout, "stdout", envBool("VIADUCT_STDOUT"), "Log non-error output to STDOUT instead of STDERR (errors stay on STDERR)")
@@ -51,14 +149,71 @@ func initCli(c *CliFlags) {
 		log.Fatal("Cannot use --silent and --quiet together")
 	}
 
-	c.Attributes = attributes
-	c.ResourceTimeout = resourceTimeout
-	c.DryRun = dryRun
-	c.DumpManifest = dumpManifest
-	c.JSON = jsonOutput
-	c.Quiet = quiet
-	c.Silent = silent
-	c.Stdout = stdout
+	if _, err := (Graph{}).Format(graph); graph != "" && err != nil {
+		log.Fatal(err)
+	}
+
+	switch output {
+	case "", OutputText, OutputJSONL:
+	case OutputJSON:
+		jsonOutput = true
+	default:
+		log.Fatalf("unknown output %q: use %s, %s or %s", output, OutputText, OutputJSON, OutputJSONL)
+	}
+
+	for _, r := range reports {
+		if _, _, err := parseReport(r); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Anything the program set before Init is kept, unless the command line
+	// sets it too
+	setOption(&c.Apply, apply)
+	setOption(&c.Attributes, attributes)
+	setOption(&c.ResourceTimeout, resourceTimeout)
+	setOption(&c.Concurrency, concurrency)
+	setOption(&c.DryRun, dryRun || drift)
+	setOption(&c.Drift, drift)
+	setOption(&c.DumpManifest, dumpManifest)
+	setOption(&c.FailFast, failFast)
+	setOption(&c.Graph, graph)
+	setOption(&c.JSON, jsonOutput)
+	setOption(&c.ListKinds, listKinds)
+	setOption(&c.LogFile, logFile)
+	setOption(&c.NoDeps, noDeps)
+	setOption(&c.Output, output)
+	setOption(&c.Plan, plan)
+	setOption(&c.Profile, profile)
+	setOption(&c.Quiet, quiet)
+	setOption(&c.Retries, retries)
+	setOption(&c.Silent, silent)
+	setOption(&c.Stdout, stdout)
+
+	if len(only) > 0 {
+		c.Only = only
+	}
+
+	if len(onlyIDs) > 0 {
+		c.OnlyIDs = onlyIDs
+	}
+
+	if len(skip) > 0 {
+		c.Skip = skip
+	}
+
+	if len(reports) > 0 {
+		c.Reports = reports
+	}
+}
+
+// setOption sets an option from the command line, unless the command line
+// left it unset.
+func setOption[T comparable](option *T, value T) {
+	var unset T
+	if value != unset {
+		*option = value
+	}
 }
 
 // envBool reads a boolean environment variable, returning false when it is
@@ -95,36 +250,120 @@ func envDuration(name string) time.Duration {
 }
 
 // SetResourceTimeout overrides how long each resource is given to run.
-func (c *CliFlags) SetResourceTimeout(d time.Duration) {
+func (c *Options) SetResourceTimeout(d time.Duration) {
 	c.ResourceTimeout = d
 }
 
+// SetConcurrency overrides how many resources run at once.
+func (c *Options) SetConcurrency(n int) {
+	c.Concurrency = n
+}
+
+// SetRetries overrides how many times a retryable resource is retried.
+func (c *Options) SetRetries(n int) {
+	c.Retries = n
+}
+
 // SetDryRun enables dry run mode.
-func (c *CliFlags) SetDryRun() {
+func (c *Options) SetDryRun() {
+	c.DryRun = true
+}
+
+// SetDrift enables drift mode, which implies a dry run.
+func (c *Options) SetDrift() {
+	c.Drift = true
 	c.DryRun = true
 }
 
 // SetDumpManifest enables dumping the manifest.
-func (c *CliFlags) SetDumpManifest() {
+func (c *Options) SetDumpManifest() {
 	c.DumpManifest = true
 }
 
+// SetFailFast stops the run from starting anything else once a resource has
+// failed.
+func (c *Options) SetFailFast() {
+	c.FailFast = true
+}
+
+// SetGraph prints the dependency graph in the format without running
+// anything, or once the run has finished with SetApply or SetDryRun.
+func (c *Options) SetGraph(format string) {
+	c.Graph = format
+}
+
+// SetApply runs the manifest along with SetGraph.
+func (c *Options) SetApply() {
+	c.Apply = true
+}
+
+// SetLogFile writes log events to the file as well, as lines of JSON.
+func (c *Options) SetLogFile(path string) {
+	c.LogFile = path
+}
+
+// SetOnly narrows the run down to the resources with any of the tags.
+func (c *Options) SetOnly(tags ...string) {
+	c.Only = tags
+}
+
+// SetOnlyIDs narrows the run down to the resources with the IDs.
+func (c *Options) SetOnlyIDs(ids ...string) {
+	c.OnlyIDs = ids
+}
+
+// SetSkip leaves the resources with any of the tags out of the run.
+func (c *Options) SetSkip(tags ...string) {
+	c.Skip = tags
+}
+
+// SetNoDeps stops dependencies being picked along with the resources picked
+// with SetOnly or SetOnlyIDs.
+func (c *Options) SetNoDeps() {
+	c.NoDeps = true
+}
+
+// SetOutput sets how the run reports what it does: OutputText, OutputJSON or
+// OutputJSONL.
+func (c *Options) SetOutput(format string) {
+	c.Output = format
+	c.JSON = format == OutputJSON
+}
+
+// SetPlan prints the waves the manifest would run in, without running
+// anything.
+func (c *Options) SetPlan() {
+	c.Plan = true
+}
+
+// SetProfile lists the n slowest resources once the run has finished, along
+// with the critical path through the dependency graph.
+func (c *Options) SetProfile(n int) {
+	c.Profile = n
+}
+
+// SetReports writes the report of the run to files once it has finished, each
+// given as format:path, such as "junit:viaduct.xml".
+func (c *Options) SetReports(reports ...string) {
+	c.Reports = reports
+}
+
 // SetQuiet enables quiet mode.
-func (c *CliFlags) SetQuiet() {
+func (c *Options) SetQuiet() {
 	c.Quiet = true
 }
 
 // SetJSON enables JSON output mode.
-func (c *CliFlags) SetJSON() {
+func (c *Options) SetJSON() {
 	c.JSON = true
 }
 
 // SetSilent enables silent mode.
-func (c *CliFlags) SetSilent() {
+func (c *Options) SetSilent() {
 	c.Silent = true
 }
 
 // SetStdout logs non-error output to STDOUT instead of STDERR.
-func (c *CliFlags) SetStdout() {
+func (c *Options) SetStdout() {
 	c.Stdout = true
 }
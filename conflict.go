@@ -0,0 +1,187 @@
+package viaduct
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Claim is something a resource manages, such as a path or a package, and the
+// state the resource leaves it in.
+type Claim struct {
+	// Object names what is managed, such as "path /etc/hosts" or
+	// "package curl". Resources of different kinds that manage the same
+	// thing claim the same object. The path of a path object has "~"
+	// expanded before it is compared, so "path ~/.bashrc" is the same object
+	// as the full path.
+	Object string
+	// State is what the resource leaves the object as, such as "present" or
+	// "absent". Resources that leave an object in the same state can run in
+	// any order. An empty state is for a resource that replaces the object
+	// outright, such as a file with its content, so it conflicts with any
+	// other claim.
+	State string
+}
+
+// Claimer can be implemented by resources to say what they manage. Resources
+// that claim the same object and leave it in different states conflict, unless
+// one depends on the other, since whichever happens to run last wins. Apply
+// checks for conflicts once the preflight checks have run, and fails before
+// anything is applied if it finds any.
+//
+// This complements the duplicate check in Add, which only catches resources of
+// the same kind doing the same thing to the same identity.
+type Claimer interface {
+	Claims() []Claim
+}
+
+// Conflict is a pair of resources that leave the same object in different
+// states, with nothing ordering one after the other.
+type Conflict struct {
+	Object    string
+	Resources [2]ConflictingResource
+}
+
+// ConflictingResource is one side of a Conflict.
+type ConflictingResource struct {
+	ResourceID   ResourceID
+	ResourceKind ResourceKind
+	// State is what the resource leaves the object as.
+	State string
+}
+
+func (c Conflict) String() string {
+	a, b := c.Resources[0], c.Resources[1]
+
+	return fmt.Sprintf("%s %s and %s %s both manage %s, and neither depends on the other",
+		a.ResourceKind, a.ResourceID, b.ResourceKind, b.ResourceID, c.Object)
+}
+
+// ConflictError is returned by Apply when resources conflict, so nothing was
+// applied.
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	conflicts := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		conflicts = append(conflicts, c.String())
+	}
+
+	return "conflicting resources: " + strings.Join(conflicts, "; ")
+}
+
+// pathClaimPrefix starts the object of a claim on a path.
+const pathClaimPrefix = "path "
+
+// claimObject returns the object of a claim as it is compared, with the path of
+// a path object expanded and cleaned.
+func (m *Manifest) claimObject(object string) string {
+	if path, ok := strings.CutPrefix(object, pathClaimPrefix); ok {
+		return pathClaimPrefix + filepath.Clean(m.rt.ExpandPath(path))
+	}
+
+	return object
+}
+
+// conflicts returns every pair of resources in the run that conflict, sorted by
+// object and then by ID.
+func (m *Manifest) conflicts() []Conflict {
+	claims := make(map[string][]ConflictingResource)
+
+	for _, id := range slices.Sorted(maps.Keys(m.resources)) {
+		if m.deselected[id] {
+			continue
+		}
+
+		r := m.resources[id]
+
+		c, ok := r.Attributes.(Claimer)
+		if !ok {
+			continue
+		}
+
+		for _, claim := range c.Claims() {
+			object := m.claimObject(claim.Object)
+			claims[object] = append(claims[object], ConflictingResource{
+				ResourceID:   id,
+				ResourceKind: r.ResourceKind,
+				State:        claim.State,
+			})
+		}
+	}
+
+	var conflicts []Conflict
+
+	for _, object := range slices.Sorted(maps.Keys(claims)) {
+		claimants := claims[object]
+
+		for i, a := range claimants {
+			for _, b := range claimants[i+1:] {
+				if a.ResourceID == b.ResourceID || (a.State != "" && a.State == b.State) || m.ordered(a.ResourceID, b.ResourceID) {
+					continue
+				}
+
+				conflicts = append(conflicts, Conflict{Object: object, Resources: [2]ConflictingResource{a, b}})
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// conflictCheck returns a ConflictError listing the resources that conflict.
+func (m *Manifest) conflictCheck() error {
+	if conflicts := m.conflicts(); len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+
+	return nil
+}
+
+// ordered says whether one of two resources always finishes before the other
+// starts: because they run in different phases, or one depends on the other.
+func (m *Manifest) ordered(a, b ResourceID) bool {
+	return m.phase(a) != m.phase(b) || m.reaches(a, b) || m.reaches(b, a)
+}
+
+// phase is when a resource runs: resources being pruned first, then
+// everything else, then handlers.
+func (m *Manifest) phase(id ResourceID) int {
+	switch {
+	case m.pruners[id]:
+		return 0
+	case m.resources[id].Handler():
+		return 2
+	default:
+		return 1
+	}
+}
+
+// reaches says whether from waits for to, directly or through what it waits
+// for.
+func (m *Manifest) reaches(from, to ResourceID) bool {
+	seen := map[ResourceID]bool{from: true}
+	queue := []ResourceID{from}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		for _, dep := range m.resources[id].edges() {
+			if dep == to {
+				return true
+			}
+
+			if !seen[dep] {
+				seen[dep] = true
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	return false
+}
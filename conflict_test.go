@@ -0,0 +1,126 @@
+package viaduct
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testClaimResourceType is a test resource that claims an object
+type testClaimResourceType struct {
+	testResourceType
+	Object string
+	State  string
+}
+
+func (t *testClaimResourceType) Claims() []Claim {
+	return []Claim{{Object: t.Object, State: t.State}}
+}
+
+func newClaimingTestResource(value, object, state string) *testClaimResourceType {
+	return &testClaimResourceType{testResourceType: testResourceType{Value: value}, Object: object, State: state}
+}
+
+func TestConflicts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("different states", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		addNamed(m, "create", newClaimingTestResource("create", "path /etc/foo", ""))
+		addNamed(m, "delete", newClaimingTestResource("delete", "path /etc/foo", "absent"))
+
+		assert.Equal(t, []Conflict{{
+			Object: "path /etc/foo",
+			Resources: [2]ConflictingResource{
+				{ResourceID: "create", ResourceKind: "testClaimResourceType"},
+				{ResourceID: "delete", ResourceKind: "testClaimResourceType", State: "absent"},
+			},
+		}}, m.conflicts())
+	})
+
+	t.Run("the same state", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		addNamed(m, "a", newClaimingTestResource("a", "path /etc/foo", "present"))
+		addNamed(m, "b", newClaimingTestResource("b", "path /etc/foo", "present"))
+
+		assert.Empty(t, m.conflicts())
+	})
+
+	t.Run("replacing the object outright", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		addNamed(m, "a", newClaimingTestResource("a", "path /etc/foo", ""))
+		addNamed(m, "b", newClaimingTestResource("b", "path /etc/foo", ""))
+
+		assert.Len(t, m.conflicts(), 1)
+	})
+
+	t.Run("a path from the home directory", func(t *testing.T) {
+		t.Parallel()
+
+		m := NewWithRuntime(homeRuntime("/home/test"))
+		addNamed(m, "create", newClaimingTestResource("create", "path ~/foo", ""))
+		addNamed(m, "delete", newClaimingTestResource("delete", "path /home/test/foo/", "absent"))
+
+		if conflicts := m.conflicts(); assert.Len(t, conflicts, 1) {
+			assert.Equal(t, "path /home/test/foo", conflicts[0].Object)
+		}
+	})
+
+	t.Run("ordered through a dependency", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		create := addNamed(m, "create", newClaimingTestResource("create", "path /etc/foo", ""))
+		between := addNamed(m, "between", newTestResource("between"), create)
+		addNamed(m, "delete", newClaimingTestResource("delete", "path /etc/foo", "absent"), between)
+
+		assert.Empty(t, m.conflicts())
+	})
+
+	t.Run("ordered by running as a handler", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		addNamed(m, "create", newClaimingTestResource("create", "path /etc/foo", ""))
+		other := addNamed(m, "other", newTestResource("other"))
+		m.Notify(other, addNamed(m, "delete", newClaimingTestResource("delete", "path /etc/foo", "absent")))
+
+		assert.Empty(t, m.conflicts())
+	})
+
+	t.Run("left out of the run", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		addNamed(m, "create", newClaimingTestResource("create", "path /etc/foo", ""))
+		addNamed(m, "delete", newClaimingTestResource("delete", "path /etc/foo", "absent"))
+		m.deselected = map[ResourceID]bool{"delete": true}
+
+		assert.Empty(t, m.conflicts())
+	})
+
+	t.Run("stop the run", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+		create := newClaimingTestResource("create", "path /etc/foo", "")
+		addNamed(m, "create", create)
+		addNamed(m, "delete", newClaimingTestResource("delete", "path /etc/foo", "absent"))
+
+		report, err := m.Apply(context.Background())
+		assert.Nil(t, report)
+		assert.EqualError(t, err, "conflicting resources: testClaimResourceType create and testClaimResourceType delete both manage path /etc/foo, and neither depends on the other")
+		assert.False(t, create.ran.Load())
+
+		var conflictErr *ConflictError
+		assert.ErrorAs(t, err, &conflictErr)
+	})
+}
@@ -0,0 +1,165 @@
+package viaduct
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Output formats, for --output and SetOutput.
+const (
+	OutputText  = "text"
+	OutputJSON  = "json"
+	OutputJSONL = "jsonl"
+)
+
+// RunEventType is what a RunEvent says happened.
+type RunEventType string
+
+const (
+	// EventRunStarted is the first event of a run, with how many resources
+	// are in the manifest.
+	EventRunStarted RunEventType = "run-started"
+	// EventPreflight says whether the checks before anything is applied
+	// passed, with the error if they did not.
+	EventPreflight RunEventType = "preflight"
+	// EventResourceStarted is when a resource starts running, once it holds
+	// its lock.
+	EventResourceStarted RunEventType = "resource-started"
+	// EventLog is something logged, with the resource that logged it if it
+	// was a resource.
+	EventLog RunEventType = "log"
+	// EventResourceFinished is how a resource finished, including those that
+	// never started because they were skipped or a dependency failed.
+	EventResourceFinished RunEventType = "resource-finished"
+	// EventRunFinished is the last event of a run, with its summary.
+	EventRunFinished RunEventType = "run-finished"
+)
+
+// RunEvent is a line of --output=jsonl, written as soon as what it describes
+// happens, so a run can be followed while it goes.
+type RunEvent struct {
+	Type         RunEventType `json:"type"`
+	Time         time.Time    `json:"time"`
+	ResourceID   ResourceID   `json:"resource_id,omitempty"`
+	ResourceKind ResourceKind `json:"resource_kind,omitempty"`
+	Operation    string       `json:"operation,omitempty"`
+	Description  string       `json:"description,omitempty"`
+	// Level, Message and Fields are what was logged, for a log event.
+	Level   Level          `json:"level,omitempty"`
+	Message string         `json:"msg,omitempty"`
+	Fields  map[string]any `json:"fields,omitempty"`
+	// Status, Outcome, Attempts, Duration and Error are how the preflight
+	// checks, a resource or the run went.
+	Status   string  `json:"status,omitempty"`
+	Outcome  string  `json:"outcome,omitempty"`
+	Attempts int     `json:"attempts,omitempty"`
+	Duration string  `json:"duration,omitempty"`
+	Timing   *Timing `json:"timing,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	// Resources is how many resources the run started with, and Summary
+	// what happened to them once it has finished, along with the Profile
+	// with --profile and the Drift with --drift.
+	Resources int         `json:"resources,omitempty"`
+	Summary   *RunSummary `json:"summary,omitempty"`
+	Profile   *RunProfile `json:"profile,omitempty"`
+	Drift     []Drift     `json:"drift,omitempty"`
+}
+
+// eventStream writes run events as lines of JSON. It is also the sink that
+// log events reach it through.
+type eventStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newEventStream(w io.Writer) *eventStream {
+	return &eventStream{enc: json.NewEncoder(w)}
+}
+
+// emit writes an event, timestamped now unless it already has a time. A nil
+// stream, for a run that isn't streaming, drops it.
+func (s *eventStream) emit(e RunEvent) {
+	if s == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_ = s.enc.Encode(e)
+}
+
+func (s *eventStream) Handle(e Event) error {
+	s.emit(RunEvent{
+		Type:         EventLog,
+		Time:         e.Time,
+		ResourceID:   e.ResourceID,
+		ResourceKind: ResourceKind(e.Resource),
+		Operation:    e.Action,
+		Level:        e.Level,
+		Message:      e.Message,
+		Fields:       attrMap(e.Attrs),
+	})
+
+	return nil
+}
+
+// events returns the stream of run events for --output=jsonl, which is
+// written to STDOUT, or nil when the run isn't streaming.
+func (rt *Runtime) events() *eventStream {
+	if rt.Options.Output != OutputJSONL {
+		return nil
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.stream == nil {
+		rt.stream = newEventStream(os.Stdout)
+	}
+
+	return rt.stream
+}
+
+// emit writes a run event, if the run is streaming them.
+func (m *Manifest) emit(e RunEvent) {
+	m.rt.events().emit(e)
+}
+
+// record keeps the result of a resource for the report, and streams it as the
+// resource finishing.
+func (m *Manifest) record(result ResourceResult) {
+	if m.collector != nil {
+		m.collector.Add(result)
+	}
+
+	m.emit(RunEvent{
+		Type:         EventResourceFinished,
+		ResourceID:   ResourceID(result.ResourceID),
+		ResourceKind: ResourceKind(result.ResourceKind),
+		Operation:    result.Operation,
+		Description:  result.Description,
+		Status:       result.Status,
+		Outcome:      result.Outcome,
+		Attempts:     result.Attempts,
+		Duration:     result.Duration,
+		Timing:       &result.Timing,
+		Error:        result.Error,
+	})
+}
+
+// resourceLogger returns the logger a resource runs with, whose events name
+// the resource.
+func (m *Manifest) resourceLogger(r *Resource) *Logger {
+	l := m.rt.NewLogger(string(r.ResourceKind), r.Attributes.OperationName())
+	l.resourceID = r.ResourceID
+
+	return l
+}
@@ -0,0 +1,180 @@
+package viaduct
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newStreamingManifest returns a manifest whose run events are written to the
+// returned buffer
+func newStreamingManifest() (*Manifest, *bytes.Buffer) {
+	var b bytes.Buffer
+
+	rt := &Runtime{Options: &Options{Output: OutputJSONL}, Attributes: &SystemAttributes{}}
+	rt.stream = newEventStream(&b)
+
+	m := NewWithRuntime(rt)
+	m.DisableState()
+
+	return m, &b
+}
+
+// readEvents decodes each line written to a stream
+func readEvents(t *testing.T, b *bytes.Buffer) []RunEvent {
+	t.Helper()
+
+	var events []RunEvent
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var e RunEvent
+		if assert.NoError(t, json.Unmarshal([]byte(line), &e), line) {
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a run", func(t *testing.T) {
+		t.Parallel()
+
+		m, b := newStreamingManifest()
+		addNamed(m, "a", newChangingTestResource("a"))
+
+		_, err := m.Apply(context.Background())
+		assert.NoError(t, err)
+
+		var types []RunEventType
+		events := readEvents(t, b)
+		for _, e := range events {
+			types = append(types, e.Type)
+		}
+
+		assert.Equal(t, []RunEventType{
+			EventRunStarted,
+			EventLog, // started
+			EventLog, // preflight-checks
+			EventPreflight,
+			EventResourceStarted,
+			EventLog,
+			EventResourceFinished,
+			EventRunFinished,
+		}, types)
+
+		if len(events) != len(types) || len(types) != 8 {
+			return
+		}
+
+		assert.Equal(t, 1, events[0].Resources)
+		assert.Equal(t, "ok", events[3].Status)
+
+		started := events[4]
+		assert.Equal(t, ResourceID("a"), started.ResourceID)
+		assert.Equal(t, ResourceKind("testResourceType"), started.ResourceKind)
+
+		logged := events[5]
+		assert.Equal(t, ResourceID("a"), logged.ResourceID, "what a resource logs names it")
+		assert.Equal(t, LevelOK, logged.Level)
+
+		finished := events[6]
+		assert.Equal(t, ResourceID("a"), finished.ResourceID)
+		assert.Equal(t, string(Success), finished.Status)
+		assert.Equal(t, string(OutcomeChanged), finished.Outcome)
+		assert.NotEmpty(t, finished.Duration)
+
+		last := events[7]
+		assert.Equal(t, "success", last.Status)
+		if assert.NotNil(t, last.Summary) {
+			assert.Equal(t, 1, last.Summary.Changed)
+		}
+	})
+
+	t.Run("quiet and silent leave the stream alone", func(t *testing.T) {
+		t.Parallel()
+
+		for _, opts := range []Options{{Quiet: true}, {Silent: true}} {
+			m, b := newStreamingManifest()
+			m.rt.Options.Quiet, m.rt.Options.Silent = opts.Quiet, opts.Silent
+			addNamed(m, "a", newChangingTestResource("a"))
+
+			_, err := m.Apply(context.Background())
+			assert.NoError(t, err)
+
+			var logged []string
+			for _, e := range readEvents(t, b) {
+				if e.Type == EventLog {
+					logged = append(logged, e.Message)
+				}
+			}
+
+			assert.Contains(t, logged, "started", "quiet: %t, silent: %t", opts.Quiet, opts.Silent)
+		}
+	})
+
+	t.Run("drift", func(t *testing.T) {
+		t.Parallel()
+
+		m, b := newStreamingManifest()
+		m.rt.Options.Drift, m.rt.Options.DryRun = true, true
+		addNamed(m, "a", newChangingTestResource("a"))
+
+		_, err := m.Apply(context.Background())
+		assert.NoError(t, err)
+
+		events := readEvents(t, b)
+		if assert.NotEmpty(t, events) {
+			last := events[len(events)-1]
+			assert.Equal(t, EventRunFinished, last.Type)
+			if assert.Len(t, last.Drift, 1) {
+				assert.Equal(t, "a", last.Drift[0].ResourceID)
+			}
+		}
+	})
+
+	t.Run("failed preflight checks", func(t *testing.T) {
+		t.Parallel()
+
+		m, b := newStreamingManifest()
+		m.SetDep(m.Add(newTestResource("a")), "missing")
+
+		_, err := m.Apply(context.Background())
+		assert.Error(t, err)
+
+		events := readEvents(t, b)
+		if assert.NotEmpty(t, events) {
+			last := events[len(events)-1]
+			assert.Equal(t, EventPreflight, last.Type)
+			assert.Equal(t, "failed", last.Status)
+			assert.Contains(t, last.Error, "missing")
+		}
+	})
+
+	t.Run("not streaming", func(t *testing.T) {
+		t.Parallel()
+
+		rt := &Runtime{Options: &Options{}}
+		assert.Nil(t, rt.events())
+
+		sinks := rt.sinks(false)
+		if assert.Len(t, sinks, 1) {
+			assert.IsType(t, &ConsoleSink{}, sinks[0])
+		}
+	})
+
+	t.Run("in place of the console", func(t *testing.T) {
+		t.Parallel()
+
+		m, _ := newStreamingManifest()
+		sinks := m.rt.sinks(false)
+		if assert.Len(t, sinks, 1) {
+			assert.IsType(t, &eventStream{}, sinks[0])
+		}
+	})
+}
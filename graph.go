@@ -0,0 +1,305 @@
+package viaduct
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Graph formats, for --graph and Graph.Format.
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+	GraphJSON    = "json"
+)
+
+// Graph is the dependency graph of a manifest, with a node for each resource
+// and an edge from each resource to what depends on it, so the edges point
+// the way the run goes.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a resource in the graph.
+type GraphNode struct {
+	ID          ResourceID   `json:"id"`
+	Kind        ResourceKind `json:"kind"`
+	Operation   string       `json:"operation"`
+	Description string       `json:"description"`
+	// Locked says the resource takes a lock, and LockKey which one. An empty
+	// key is the lock every lock holder shares.
+	Locked  bool    `json:"locked,omitempty"`
+	LockKey string  `json:"lock_key,omitempty"`
+	Handler bool    `json:"handler,omitempty"`
+	Status  Status  `json:"status"`
+	Outcome Outcome `json:"outcome,omitempty"`
+}
+
+// GraphEdge runs from a resource to one that waits for it. Notify is set when
+// the later resource is a handler of the earlier one, rather than depending
+// on it.
+type GraphEdge struct {
+	From   ResourceID `json:"from"`
+	To     ResourceID `json:"to"`
+	Notify bool       `json:"notify,omitempty"`
+}
+
+// Graph returns the dependency graph of the manifest. Nodes and edges are
+// sorted by ID, so the same manifest always gives the same graph. Called after
+// Apply, each node has the status the resource finished with. Dependencies on
+// resources that aren't in the manifest are left out.
+func (m *Manifest) Graph() Graph {
+	ids := make([]ResourceID, 0, len(m.resources))
+	for id := range m.resources {
+		ids = append(ids, id)
+	}
+
+	g := Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+
+	for _, id := range sortedIDs(ids) {
+		r := m.resources[id]
+		g.Nodes = append(g.Nodes, m.graphNode(id))
+
+		for _, dep := range sortedIDs(r.DependsOn) {
+			if _, ok := m.resources[dep]; ok {
+				g.Edges = append(g.Edges, GraphEdge{From: dep, To: id})
+			}
+		}
+
+		for _, source := range sortedIDs(r.Subscribes) {
+			if _, ok := m.resources[source]; ok {
+				g.Edges = append(g.Edges, GraphEdge{From: source, To: id, Notify: true})
+			}
+		}
+	}
+
+	return g
+}
+
+// graphNode returns the node for a resource in the manifest.
+func (m *Manifest) graphNode(id ResourceID) GraphNode {
+	r := m.resources[id]
+
+	node := GraphNode{
+		ID:      id,
+		Kind:    r.ResourceKind,
+		Locked:  r.GlobalLock,
+		LockKey: r.LockKey,
+		Handler: r.Handler(),
+		Status:  r.Status,
+		Outcome: r.Outcome,
+	}
+
+	if r.Attributes != nil {
+		node.Operation = r.Attributes.OperationName()
+		node.Description = r.Attributes.Description()
+	}
+
+	return node
+}
+
+// Format renders the graph as Graphviz DOT, Mermaid or JSON.
+func (g Graph) Format(format string) (string, error) {
+	switch format {
+	case GraphDOT:
+		return g.DOT(), nil
+	case GraphMermaid:
+		return g.Mermaid(), nil
+	case GraphJSON:
+		out, err := json.MarshalIndent(g, "", "    ")
+		if err != nil {
+			return "", err
+		}
+
+		return string(out) + "\n", nil
+	default:
+		return "", fmt.Errorf("unknown graph format %q: use %s, %s or %s", format, GraphDOT, GraphMermaid, GraphJSON)
+	}
+}
+
+// label is what a node is labelled with, on two lines.
+func (n GraphNode) label() string {
+	return fmt.Sprintf("%s [%s]\n%s", n.Kind, n.Operation, n.Description)
+}
+
+// statusColours are the colours nodes are filled with once their resource has
+// finished. Pending resources are left unfilled.
+var statusColours = map[Status]string{
+	Success:          "#b7e4c7",
+	Failed:           "#f4a6a6",
+	DependencyFailed: "#f9c98d",
+	Skipped:          "#dddddd",
+}
+
+// lockClusters groups the nodes of resources that take a lock by the lock they
+// take, in the order the keys first appear. Nodes without a lock are returned
+// separately.
+func (g Graph) lockClusters() (keys []string, clusters map[string][]GraphNode, unlocked []GraphNode) {
+	clusters = make(map[string][]GraphNode)
+
+	for _, n := range g.Nodes {
+		if !n.Locked {
+			unlocked = append(unlocked, n)
+			continue
+		}
+
+		if _, ok := clusters[n.LockKey]; !ok {
+			keys = append(keys, n.LockKey)
+		}
+
+		clusters[n.LockKey] = append(clusters[n.LockKey], n)
+	}
+
+	return keys, clusters, unlocked
+}
+
+// lockLabel is what a lock cluster is labelled with.
+func lockLabel(key string) string {
+	if key == "" {
+		return "global lock"
+	}
+
+	return "lock: " + key
+}
+
+// DOT renders the graph in the Graphviz DOT language, with the resources that
+// share a lock in a cluster of their own.
+func (g Graph) DOT() string {
+	var b strings.Builder
+
+	b.WriteString("digraph viaduct {\n")
+	b.WriteString("    node [shape=box];\n")
+
+	writeNode := func(indent string, n GraphNode) {
+		fmt.Fprintf(&b, "%s%s [label=%s", indent, dotQuote(string(n.ID)), dotQuote(n.label()))
+
+		if colour, ok := statusColours[n.Status]; ok {
+			fmt.Fprintf(&b, ", style=filled, fillcolor=%s", dotQuote(colour))
+		}
+
+		if n.Handler {
+			b.WriteString(", shape=octagon")
+		}
+
+		b.WriteString("];\n")
+	}
+
+	keys, clusters, unlocked := g.lockClusters()
+
+	for i, key := range keys {
+		fmt.Fprintf(&b, "    subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "        label=%s;\n", dotQuote(lockLabel(key)))
+		b.WriteString("        style=dashed;\n")
+
+		for _, n := range clusters[key] {
+			writeNode("        ", n)
+		}
+
+		b.WriteString("    }\n")
+	}
+
+	for _, n := range unlocked {
+		writeNode("    ", n)
+	}
+
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "    %s -> %s", dotQuote(string(e.From)), dotQuote(string(e.To)))
+
+		if e.Notify {
+			b.WriteString(" [style=dashed, label=\"notifies\"]")
+		}
+
+		b.WriteString(";\n")
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// dotQuote quotes a string for DOT, where a newline is written as \n.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+
+	return `"` + s + `"`
+}
+
+// Mermaid renders the graph as a Mermaid flowchart, with the resources that
+// share a lock in a subgraph of their own. Resource IDs aren't valid Mermaid
+// IDs, so nodes are numbered in the order they are sorted in.
+func (g Graph) Mermaid() string {
+	var b strings.Builder
+
+	b.WriteString("flowchart TD\n")
+
+	ids := make(map[ResourceID]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		ids[n.ID] = fmt.Sprintf("n%d", i)
+	}
+
+	writeNode := func(indent string, n GraphNode) {
+		open, closing := "[", "]"
+		if n.Handler {
+			open, closing = "{{", "}}"
+		}
+
+		fmt.Fprintf(&b, "%s%s%s%s%s\n", indent, ids[n.ID], open, mermaidQuote(n.label()), closing)
+	}
+
+	keys, clusters, unlocked := g.lockClusters()
+
+	for i, key := range keys {
+		fmt.Fprintf(&b, "    subgraph lock%d[%s]\n", i, mermaidQuote(lockLabel(key)))
+
+		for _, n := range clusters[key] {
+			writeNode("        ", n)
+		}
+
+		b.WriteString("    end\n")
+	}
+
+	for _, n := range unlocked {
+		writeNode("    ", n)
+	}
+
+	for _, e := range g.Edges {
+		arrow := "-->"
+		if e.Notify {
+			arrow = "-. notifies .->"
+		}
+
+		fmt.Fprintf(&b, "    %s %s %s\n", ids[e.From], arrow, ids[e.To])
+	}
+
+	for _, status := range []Status{Success, Failed, DependencyFailed, Skipped} {
+		var members []string
+
+		for _, n := range g.Nodes {
+			if n.Status == status {
+				members = append(members, ids[n.ID])
+			}
+		}
+
+		if len(members) == 0 {
+			continue
+		}
+
+		class := strings.ToLower(string(status))
+		fmt.Fprintf(&b, "    classDef %s fill:%s\n", class, statusColours[status])
+		fmt.Fprintf(&b, "    class %s %s\n", strings.Join(members, ","), class)
+	}
+
+	return b.String()
+}
+
+// mermaidQuote quotes a label for Mermaid, where a newline is written as a
+// line break and a double quote as an entity.
+func mermaidQuote(s string) string {
+	s = strings.ReplaceAll(s, `"`, "#quot;")
+	s = strings.ReplaceAll(s, "\n", "<br/>")
+
+	return `"` + s + `"`
+}
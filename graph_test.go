@@ -0,0 +1,155 @@
+package viaduct
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// graphManifest returns a manifest with a dependency, a handler and resources
+// sharing locks, with readable IDs
+func graphManifest(t *testing.T) *Manifest {
+	t.Helper()
+
+	m := New()
+	m.DisableState()
+
+	config := m.Add(newChangingTestResource("config"))
+	m.SetName(config, "config")
+
+	pkg := m.Add(newTestResourceWithLockKey("package", PackageLock), config)
+	m.SetName(pkg, "package")
+
+	global := m.Add(newTestResourceWithLock("global"))
+	m.SetName(global, "global")
+
+	restart := m.Add(newTestResource("restart"))
+	m.SetName(restart, "restart")
+	m.Notify(config, restart)
+
+	return m
+}
+
+func TestGraph(t *testing.T) {
+	t.Parallel()
+
+	g := graphManifest(t).Graph()
+
+	var ids []ResourceID
+	for _, n := range g.Nodes {
+		ids = append(ids, n.ID)
+	}
+
+	assert.Equal(t, []ResourceID{"config", "global", "package", "restart"}, ids)
+	assert.Equal(t, GraphNode{
+		ID:          "package",
+		Kind:        "testResourceType",
+		Operation:   "Test",
+		Description: "package",
+		Locked:      true,
+		LockKey:     PackageLock,
+		Status:      Pending,
+	}, g.Nodes[2])
+	assert.True(t, g.Nodes[3].Handler)
+
+	assert.Equal(t, []GraphEdge{
+		{From: "config", To: "package"},
+		{From: "config", To: "restart", Notify: true},
+	}, g.Edges)
+
+	t.Run("leaves out dependencies not in the manifest", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		r := m.Add(newTestResource("dangling"))
+		m.SetDep(r, "missing")
+
+		assert.Empty(t, m.Graph().Edges)
+	})
+}
+
+func TestGraphDOT(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `digraph viaduct {
+    node [shape=box];
+    subgraph cluster_0 {
+        label="global lock";
+        style=dashed;
+        "global" [label="testResourceType [Test]\nglobal"];
+    }
+    subgraph cluster_1 {
+        label="lock: package";
+        style=dashed;
+        "package" [label="testResourceType [Test]\npackage"];
+    }
+    "config" [label="testResourceType [Test]\nconfig"];
+    "restart" [label="testResourceType [Test]\nrestart", shape=octagon];
+    "config" -> "package";
+    "config" -> "restart" [style=dashed, label="notifies"];
+}
+`, graphManifest(t).Graph().DOT())
+
+	t.Run("coloured by status after a run", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+		failing := m.Add(newFailingTestResource("failing"))
+		m.SetName(failing, "failing")
+		after := m.Add(newTestResource("after"), failing)
+		m.SetName(after, "after")
+
+		_, err := m.Apply(context.Background())
+		assert.ErrorIs(t, err, ErrResourcesFailed)
+
+		dot := m.Graph().DOT()
+		assert.Contains(t, dot, `"failing" [label="testResourceType [Test]\nfailing", style=filled, fillcolor="#f4a6a6"];`)
+		assert.Contains(t, dot, `"after" [label="testResourceType [Test]\nafter", style=filled, fillcolor="#f9c98d"];`)
+	})
+}
+
+func TestGraphMermaid(t *testing.T) {
+	t.Parallel()
+
+	m := graphManifest(t)
+	_, err := m.Apply(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, `flowchart TD
+    subgraph lock0["global lock"]
+        n1["testResourceType [Test]<br/>global"]
+    end
+    subgraph lock1["lock: package"]
+        n2["testResourceType [Test]<br/>package"]
+    end
+    n0["testResourceType [Test]<br/>config"]
+    n3{{"testResourceType [Test]<br/>restart"}}
+    n0 --> n2
+    n0 -. notifies .-> n3
+    classDef success fill:#b7e4c7
+    class n0,n1,n2,n3 success
+`, m.Graph().Mermaid())
+}
+
+func TestGraphFormat(t *testing.T) {
+	t.Parallel()
+
+	g := graphManifest(t).Graph()
+
+	out, err := g.Format(GraphJSON)
+	assert.NoError(t, err)
+
+	var decoded Graph
+	assert.NoError(t, json.Unmarshal([]byte(out), &decoded))
+	assert.Equal(t, g, decoded)
+
+	out, err = g.Format(GraphDOT)
+	assert.NoError(t, err)
+	assert.Equal(t, g.DOT(), out)
+
+	_, err = g.Format("svg")
+	assert.ErrorContains(t, err, `unknown graph format "svg"`)
+}
@@ -0,0 +1,151 @@
+package viaduct
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// guard decides, just before a resource runs, whether it runs at all.
+type guard struct {
+	// name describes the guard when the resource is skipped.
+	name string
+
+	// check reports whether the condition holds, or an error if it could
+	// not tell.
+	check func(ctx context.Context) (bool, error)
+
+	// negate runs the resource only when the condition does not hold.
+	negate bool
+}
+
+// passes reports whether the guard lets the resource run. A guard that could
+// not tell returns an error, and never lets the resource run, whichever way it
+// is negated.
+func (g guard) passes(ctx context.Context) (bool, error) {
+	ok, err := g.check(ctx)
+	if err != nil {
+		return false, fmt.Errorf("guard %s: %w", g.name, err)
+	}
+
+	return ok != g.negate, nil
+}
+
+// OnlyIf runs the resource only if fn returns true.
+//
+// Unlike an if statement around Add, the guard is evaluated just before the
+// resource runs, once everything it depends on has finished, so it can look at
+// what earlier resources in the same run have done. A resource the guard
+// leaves out is reported as Skipped, and whatever depends on it still runs.
+//
+// In a dry run, earlier resources have not changed anything, so a guard sees
+// the machine as it was before the run.
+func (m *Manifest) OnlyIf(r *Resource, fn func() bool) {
+	m.addGuard(r, guard{
+		name:  "only-if",
+		check: func(context.Context) (bool, error) { return fn(), nil },
+	})
+}
+
+// NotIf runs the resource only if fn returns false. It is evaluated in the
+// same way as OnlyIf.
+func (m *Manifest) NotIf(r *Resource, fn func() bool) {
+	m.addGuard(r, guard{
+		name:   "not-if",
+		check:  func(context.Context) (bool, error) { return fn(), nil },
+		negate: true,
+	})
+}
+
+// OnlyIfCommand runs the resource only if command exits cleanly. The command
+// is run with bash just before the resource, in the same way as OnlyIf, and
+// is given the resource's timeout. It also runs in a dry run, so it should not
+// change anything.
+//
+// A command that cannot be run, runs out of time or is killed has not said
+// either way, so the resource fails rather than running or being skipped.
+func (m *Manifest) OnlyIfCommand(r *Resource, command string) {
+	m.addGuard(r, guard{
+		name:  "only-if " + command,
+		check: commandGuard(command),
+	})
+}
+
+// NotIfCommand runs the resource only if command fails. It is evaluated in the
+// same way as OnlyIfCommand.
+func (m *Manifest) NotIfCommand(r *Resource, command string) {
+	m.addGuard(r, guard{
+		name:   "not-if " + command,
+		check:  commandGuard(command),
+		negate: true,
+	})
+}
+
+// addGuard adds a guard to a resource. A resource with several guards runs
+// only if all of them let it.
+func (m *Manifest) addGuard(r *Resource, g guard) {
+	if v, ok := m.resources[r.ResourceID]; ok {
+		v.guards = append(v.guards, g)
+		m.resources[r.ResourceID] = v
+	}
+}
+
+// commandGuard returns a check that a command exits cleanly. Its output is
+// discarded, since only the exit status matters. The command runs in a process
+// group of its own, and the whole group is killed if it runs out of time.
+func commandGuard(command string) func(ctx context.Context) (bool, error) {
+	return func(ctx context.Context) (bool, error) {
+		// nolint:gosec
+		cmd := exec.CommandContext(ctx, "bash", "-c", command)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Cancel = func() error {
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+
+		err := cmd.Run()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return false, ctxErr
+		}
+
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.As(err, &exitErr) && exitErr.Exited():
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+}
+
+// guardedOut returns the first guard that stops the resource from running, or
+// false if they all let it run. The guards share the resource's timeout. A
+// guard that could not tell returns an error, and the resource must not run.
+func (r *Resource) guardedOut(ctx context.Context, timeout time.Duration) (string, bool, error) {
+	if len(r.guards) == 0 {
+		return "", false, nil
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	for _, g := range r.guards {
+		ok, err := g.passes(ctx)
+		if err != nil {
+			return g.name, true, err
+		}
+
+		if !ok {
+			return g.name, true, nil
+		}
+	}
+
+	return "", false, nil
+}
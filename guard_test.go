@@ -0,0 +1,129 @@
+package viaduct
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuards(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		guard func(m *Manifest, r *Resource)
+		runs  bool
+	}{
+		{"only if true", func(m *Manifest, r *Resource) { m.OnlyIf(r, func() bool { return true }) }, true},
+		{"only if false", func(m *Manifest, r *Resource) { m.OnlyIf(r, func() bool { return false }) }, false},
+		{"not if true", func(m *Manifest, r *Resource) { m.NotIf(r, func() bool { return true }) }, false},
+		{"not if false", func(m *Manifest, r *Resource) { m.NotIf(r, func() bool { return false }) }, true},
+		{"only if command succeeds", func(m *Manifest, r *Resource) { m.OnlyIfCommand(r, "true") }, true},
+		{"only if command fails", func(m *Manifest, r *Resource) { m.OnlyIfCommand(r, "exit 3") }, false},
+		{"not if command succeeds", func(m *Manifest, r *Resource) { m.NotIfCommand(r, "true") }, false},
+		{"not if command fails", func(m *Manifest, r *Resource) { m.NotIfCommand(r, "false") }, true},
+		{"every guard has to pass", func(m *Manifest, r *Resource) {
+			m.OnlyIf(r, func() bool { return true })
+			m.NotIf(r, func() bool { return true })
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := New()
+			guarded := newTestResource("guarded")
+			after := newTestResource("after")
+			r := m.Add(guarded)
+			a := m.Add(after, r)
+			tt.guard(m, r)
+
+			newScheduler(m, 0).run()
+
+			assert.Equal(t, tt.runs, guarded.ran.Load())
+			if tt.runs {
+				assert.Equal(t, Success, m.resources[r.ResourceID].Status)
+			} else {
+				assert.Equal(t, Skipped, m.resources[r.ResourceID].Status)
+				assert.Equal(t, OutcomeSkipped, m.resources[r.ResourceID].Outcome)
+			}
+
+			// A guarded resource has not failed, so what depends on it runs
+			assert.True(t, after.ran.Load())
+			assert.Equal(t, Success, m.resources[a.ResourceID].Status)
+		})
+	}
+}
+
+func TestGuardRunsAfterDependencies(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	first := newTestResource("first")
+	second := newTestResource("second")
+	f := m.Add(first)
+	s := m.Add(second, f)
+
+	var sawFirst atomic.Bool
+	m.OnlyIf(s, func() bool {
+		sawFirst.Store(first.ran.Load())
+		return true
+	})
+
+	newScheduler(m, 0).run()
+
+	assert.True(t, sawFirst.Load())
+	assert.True(t, second.ran.Load())
+}
+
+func TestGuardErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, negate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("a command that times out fails the resource, negated %t", negate), func(t *testing.T) {
+			t.Parallel()
+
+			m := New()
+			guarded := newTestResource("guarded")
+			after := newTestResource("after")
+			r := m.Add(guarded)
+			a := m.Add(after, r)
+			m.WithTimeout(r, 200*time.Millisecond)
+
+			if negate {
+				m.NotIfCommand(r, "sleep 5; true")
+			} else {
+				m.OnlyIfCommand(r, "sleep 5; true")
+			}
+
+			started := time.Now()
+			newScheduler(m, 0).run()
+
+			assert.Less(t, time.Since(started), 2*time.Second, "the command was killed")
+			assert.False(t, guarded.ran.Load())
+			assert.Equal(t, Failed, m.resources[r.ResourceID].Status)
+			assert.ErrorIs(t, m.resources[r.ResourceID].Error.Err, context.DeadlineExceeded)
+			assert.Equal(t, DependencyFailed, m.resources[a.ResourceID].Status)
+		})
+	}
+
+	t.Run("a command killed by a signal fails the resource", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		guarded := newTestResource("guarded")
+		r := m.Add(guarded)
+		m.NotIfCommand(r, "kill -9 $$")
+
+		newScheduler(m, 0).run()
+
+		assert.False(t, guarded.ran.Load())
+		assert.Equal(t, Failed, m.resources[r.ResourceID].Status)
+		assert.ErrorContains(t, m.resources[r.ResourceID].Error.Err, "guard not-if kill -9 $$: signal: killed")
+	})
+}
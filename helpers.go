@@ -10,17 +10,11 @@ import (
 	"syscall"
 )
 
-// ExpandPath ensures that "~" are expanded.
+// ExpandPath ensures that "~" are expanded, using the default runtime. It does
+// not read the command line, and a program with a runtime of its own should use
+// Runtime.ExpandPath.
 func ExpandPath(path string) string {
-	if strings.HasPrefix(path, "~") {
-		if p, err := filepath.Abs(strings.Replace(path, "~", Attribute.User.HomeDir, 1)); err == nil {
-			path = p
-		} else {
-			log.Fatal(err)
-		}
-	}
-
-	return path
+	return helperRuntime().ExpandPath(path)
 }
 
 // ExpandPathRoot is like ExpandPath, but ignores the user attribute
@@ -53,10 +47,10 @@ func SudoCommand(command ...string) error {
 	return RunCommand(PrependSudo(command)...)
 }
 
-// IsUbuntu returns true if the distribution is Ubuntu
+// IsUbuntu returns true if the distribution is Ubuntu, using the default
+// runtime. See Runtime.IsUbuntu.
 func IsUbuntu() bool {
-	// Linux Mint uses IDLike since it's an Ubuntu fork
-	return strings.Contains(Attribute.Platform.ID, "ubuntu") || strings.Contains(Attribute.Platform.IDLike, "ubuntu")
+	return helperRuntime().IsUbuntu()
 }
 
 // FileExists returns true if the file exists
@@ -148,14 +142,16 @@ func MatchChown(path string, user, group int) bool {
 	return false
 }
 
-// IsRoot returns true if the user is root
+// IsRoot returns true if the user is root, using the default runtime. See
+// Runtime.IsRoot.
 func IsRoot() bool {
-	return Attribute.runuser.Username == "root"
+	return helperRuntime().IsRoot()
 }
 
-// TmpFile returns the path for a Viaduct temporary file
+// TmpFile returns the path for a Viaduct temporary file, using the default
+// runtime. See Runtime.TmpFile.
 func TmpFile(path string) string {
-	return filepath.Join(Attribute.TmpDir, path)
+	return helperRuntime().TmpFile(path)
 }
 
 // FileSize returns the file size in bytes
@@ -170,24 +166,10 @@ func FileSize(path string) int64 {
 
 // CommandTrue is similar to the "Unless" parameter found in some resources, but instead
 // can be used freeform within configuration. If it exits cleanly, then it
-// returns true.
+// returns true. It logs according to the options of the default runtime: see
+// Runtime.CommandTrue.
 func CommandTrue(command string) bool {
-	c := strings.Split(command, " ")
-
-	// nolint:gosec
-	cmd := exec.Command("bash", "-c", strings.Join(c, " "))
-	if Cli.Quiet {
-		cmd.Stderr = os.Stderr
-	} else if !Cli.Silent {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}
-
-	if err := cmd.Run(); err == nil {
-		return true
-	} else {
-		return false
-	}
+	return helperRuntime().CommandTrue(command)
 }
 
 // CommandFalse is the same as True, but returns the opposite.
@@ -0,0 +1,207 @@
+// Command kinddoc documents the resource kinds a package registers, from their
+// doc comments, so that --list-kinds can show them. Run it with go generate in
+// the package: it writes kinds_doc.go, which calls viaduct.DocumentKind for
+// each kind registered with viaduct.RegisterKind.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// output is the file written, which is left out of what is read.
+const output = "kinds_doc.go"
+
+func main() {
+	files, err := parseFiles(".")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := generate(files)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile(output, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseFiles parses the package in dir, without its tests.
+func parseFiles(dir string) ([]*ast.File, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+
+	var files []*ast.File
+
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") || filepath.Base(path) == output {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	return files, nil
+}
+
+// typeDoc is a struct type and its doc comment.
+type typeDoc struct {
+	doc    string
+	fields *ast.FieldList
+}
+
+// generate returns the source of kinds_doc.go.
+func generate(files []*ast.File) ([]byte, error) {
+	var pkg string
+
+	var registered []string
+
+	types := make(map[string]typeDoc)
+
+	for _, f := range files {
+		pkg = f.Name.Name
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.GenDecl:
+				for _, spec := range n.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+
+					st, ok := ts.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+
+					doc := ts.Doc
+					if doc == nil {
+						doc = n.Doc
+					}
+
+					types[ts.Name.Name] = typeDoc{doc: clean(doc), fields: st.Fields}
+				}
+			case *ast.CallExpr:
+				if name, ok := registeredKind(n); ok {
+					registered = append(registered, name)
+				}
+			}
+
+			return true
+		})
+	}
+
+	slices.Sort(registered)
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "// Code generated by kinddoc; DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	fmt.Fprintf(&b, "import \"github.com/surminus/viaduct\"\n\n")
+	fmt.Fprintf(&b, "// init documents every resource kind in the package, for --list-kinds\n")
+	fmt.Fprintf(&b, "func init() {\n")
+
+	for _, name := range registered {
+		t, ok := types[name]
+		if !ok {
+			return nil, fmt.Errorf("kind %s is registered, but there is no struct type with that name", name)
+		}
+
+		fmt.Fprintf(&b, "viaduct.DocumentKind(%q, %q, map[string]string{\n", name, t.doc)
+
+		for _, field := range fieldDocs(t.fields, types) {
+			fmt.Fprintf(&b, "%q: %q,\n", field[0], field[1])
+		}
+
+		fmt.Fprintf(&b, "})\n")
+	}
+
+	fmt.Fprintf(&b, "}\n")
+
+	return format.Source(b.Bytes())
+}
+
+// registeredKind returns the name a call to viaduct.RegisterKind registers.
+func registeredKind(call *ast.CallExpr) (string, bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "RegisterKind" || len(call.Args) == 0 {
+		return "", false
+	}
+
+	lit, ok := call.Args[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+
+	name, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return "", false
+	}
+
+	return name, true
+}
+
+// fieldDocs returns the name and doc comment of each documented exported
+// field, including the fields of embedded structs in the same package.
+func fieldDocs(fields *ast.FieldList, types map[string]typeDoc) [][2]string {
+	var docs [][2]string
+
+	for _, field := range fields.List {
+		if len(field.Names) == 0 {
+			if ident, ok := field.Type.(*ast.Ident); ok {
+				if t, ok := types[ident.Name]; ok {
+					docs = append(docs, fieldDocs(t.fields, types)...)
+				}
+			}
+
+			continue
+		}
+
+		doc := clean(field.Doc)
+		if doc == "" {
+			doc = clean(field.Comment)
+		}
+
+		if doc == "" {
+			continue
+		}
+
+		for _, name := range field.Names {
+			if name.IsExported() {
+				docs = append(docs, [2]string{name.Name, doc})
+			}
+		}
+	}
+
+	return docs
+}
+
+// clean joins a comment onto a single line.
+func clean(c *ast.CommentGroup) string {
+	if c == nil {
+		return ""
+	}
+
+	return strings.Join(strings.Fields(c.Text()), " ")
+}
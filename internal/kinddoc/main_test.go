@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResourcesUpToDate fails when a field has been added to or documented in
+// the resources package without running go generate.
+func TestResourcesUpToDate(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join("..", "..", "resources")
+
+	files, err := parseFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := generate(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	committed, err := os.ReadFile(filepath.Join(dir, output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, string(committed), string(src), "%s is out of date: run go generate ./resources", output)
+}
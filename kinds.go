@@ -0,0 +1,176 @@
+package viaduct
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// kinds holds the registered resource kinds by name.
+var kinds = struct {
+	sync.RWMutex
+	factories map[ResourceKind]func() ResourceAttributes
+	docs      map[ResourceKind]kindDoc
+}{
+	factories: make(map[ResourceKind]func() ResourceAttributes),
+	docs:      make(map[ResourceKind]kindDoc),
+}
+
+// kindDoc is the documentation of a resource kind, given with DocumentKind.
+type kindDoc struct {
+	doc    string
+	fields map[string]string
+}
+
+// RegisterKind makes a resource kind known by name, so that it can be built
+// from saved state, a manifest file or the JSON of a manifest rather than from
+// Go code. The name is the name of the type, such as "File", and factory
+// returns a new, empty resource of that type.
+//
+// The resources package registers its own kinds. It panics if a kind is
+// registered twice or the factory is nil, like database/sql does with drivers.
+func RegisterKind(name string, factory func() ResourceAttributes) {
+	kinds.Lock()
+	defer kinds.Unlock()
+
+	if factory == nil {
+		panic("viaduct: RegisterKind factory is nil for " + name)
+	}
+
+	if _, ok := kinds.factories[ResourceKind(name)]; ok {
+		panic("viaduct: RegisterKind called twice for " + name)
+	}
+
+	kinds.factories[ResourceKind(name)] = factory
+}
+
+// DocumentKind gives the documentation of a registered kind, for Kinds and
+// --list-kinds to show. doc describes the kind, and fields describes each of
+// its fields by name. The resources package documents its kinds from their
+// doc comments with go generate.
+func DocumentKind(name, doc string, fields map[string]string) {
+	kinds.Lock()
+	defer kinds.Unlock()
+
+	kinds.docs[ResourceKind(name)] = kindDoc{doc: doc, fields: fields}
+}
+
+// newKind returns a new, empty resource of a registered kind.
+func newKind(kind ResourceKind) (ResourceAttributes, error) {
+	kinds.RLock()
+	factory, ok := kinds.factories[kind]
+	kinds.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("resource kind %s is not registered", kind)
+	}
+
+	return factory(), nil
+}
+
+// KindInfo describes a registered resource kind.
+type KindInfo struct {
+	Name   ResourceKind `json:"name"`
+	Doc    string       `json:"doc,omitempty"`
+	Fields []FieldInfo  `json:"fields"`
+}
+
+// FieldInfo describes a field of a resource kind.
+type FieldInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Doc  string `json:"doc,omitempty"`
+}
+
+// Kinds describes every registered resource kind, sorted by name. The fields
+// are those that can be set from a manifest file, including the fields of
+// embedded structs such as Permissions.
+func Kinds() []KindInfo {
+	kinds.RLock()
+	defer kinds.RUnlock()
+
+	var out []KindInfo
+
+	for _, name := range slices.Sorted(maps.Keys(kinds.factories)) {
+		doc := kinds.docs[name]
+		info := KindInfo{Name: name, Doc: doc.doc}
+
+		if t := reflect.TypeOf(kinds.factories[name]()); t.Kind() == reflect.Pointer && t.Elem().Kind() == reflect.Struct {
+			for _, f := range kindFields(t.Elem()) {
+				info.Fields = append(info.Fields, FieldInfo{Name: f.Name, Type: f.Type.String(), Doc: doc.fields[f.Name]})
+			}
+		}
+
+		out = append(out, info)
+	}
+
+	return out
+}
+
+// kindFields returns the exported fields of a resource kind, with the fields
+// of embedded structs in place of the struct itself.
+func kindFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+
+		switch {
+		case f.Anonymous && f.Type.Kind() == reflect.Struct:
+			fields = append(fields, kindFields(f.Type)...)
+		case f.IsExported():
+			fields = append(fields, f)
+		}
+	}
+
+	return fields
+}
+
+// printKinds writes the registered kinds with their fields, for --list-kinds.
+func printKinds(w io.Writer, asJSON bool) error {
+	if asJSON {
+		out, err := json.MarshalIndent(Kinds(), "", "    ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w, string(out))
+
+		return err
+	}
+
+	for i, k := range Kinds() {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+
+		fmt.Fprintln(w, k.Name)
+
+		if k.Doc != "" {
+			fmt.Fprintf(w, "    %s\n", k.Doc)
+		}
+
+		if len(k.Fields) == 0 {
+			continue
+		}
+
+		fmt.Fprintln(w)
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, f := range k.Fields {
+			fmt.Fprintf(tw, "    %s\t%s\t%s\n", f.Name, f.Type, strings.TrimSpace(f.Doc))
+		}
+
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
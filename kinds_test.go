@@ -0,0 +1,107 @@
+package viaduct
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterKind(t *testing.T) {
+	t.Parallel()
+
+	t.Run("registered kind", func(t *testing.T) {
+		t.Parallel()
+
+		a, err := newKind("testPrunableResourceType")
+		assert.NoError(t, err)
+		assert.IsType(t, &testPrunableResourceType{}, a)
+	})
+
+	t.Run("unknown kind", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := newKind("Unregistered")
+		assert.ErrorContains(t, err, "Unregistered is not registered")
+	})
+
+	t.Run("registering twice panics", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Panics(t, func() {
+			RegisterKind("testPrunableResourceType", func() ResourceAttributes { return &testPrunableResourceType{} })
+		})
+	})
+
+	t.Run("nil factory panics", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Panics(t, func() { RegisterKind("testNilKind", nil) })
+	})
+}
+
+func TestKinds(t *testing.T) {
+	t.Parallel()
+
+	DocumentKind("testLoadResourceType", "testLoadResourceType is loaded from manifest files", map[string]string{
+		"Path": "Path is where it is",
+	})
+
+	var info KindInfo
+	for _, k := range Kinds() {
+		if k.Name == "testLoadResourceType" {
+			info = k
+		}
+	}
+
+	assert.Equal(t, "testLoadResourceType is loaded from manifest files", info.Doc)
+	assert.Contains(t, info.Fields, FieldInfo{Name: "Value", Type: "string"}, "fields of embedded structs are listed")
+	assert.Contains(t, info.Fields, FieldInfo{Name: "Path", Type: "string", Doc: "Path is where it is"})
+	assert.Contains(t, info.Fields, FieldInfo{Name: "Mode", Type: "fs.FileMode"})
+
+	t.Run("printed", func(t *testing.T) {
+		var b strings.Builder
+		assert.NoError(t, printKinds(&b, false))
+		assert.Contains(t, b.String(), "testLoadResourceType\n    testLoadResourceType is loaded from manifest files\n")
+		assert.Regexp(t, `\n    Path +string +Path is where it is\n`, b.String())
+	})
+
+	t.Run("printed as JSON", func(t *testing.T) {
+		var b strings.Builder
+		assert.NoError(t, printKinds(&b, true))
+
+		var kinds []KindInfo
+		assert.NoError(t, json.Unmarshal([]byte(b.String()), &kinds))
+		assert.NotEmpty(t, kinds)
+	})
+}
+
+func TestManifestJSON(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	tools := m.Add(&testLoadResourceType{Path: "/tmp/tools", Names: []string{"curl"}, Mode: 0o755})
+	m.SetName(tools, "tools")
+	config := m.Add(&testLoadResourceType{testResourceType: testResourceType{Value: "config"}, Wait: time.Second}, tools)
+	m.Tag(config, "dotfiles")
+	m.IgnoreFailure(config)
+
+	out, err := json.Marshal(m)
+	assert.NoError(t, err)
+
+	var decoded Manifest
+	assert.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, m.resources, decoded.resources)
+	assert.NotNil(t, decoded.Runtime())
+
+	t.Run("unregistered kind", func(t *testing.T) {
+		m := New()
+		m.Add(newTestResource("unregistered"))
+
+		out, err := json.Marshal(m)
+		assert.NoError(t, err)
+		assert.ErrorContains(t, json.Unmarshal(out, &Manifest{}), "testResourceType is not registered")
+	})
+}
@@ -0,0 +1,458 @@
+package viaduct
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadError is a problem with a manifest file, at the position in the file
+// where it was found.
+type LoadError struct {
+	Path   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *LoadError) Error() string {
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %s", e.Path, e.Err)
+	case e.Column == 0:
+		return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Err)
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Column, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// LoadManifest creates a manifest from a YAML or JSON file, which runs with the
+// default runtime. See Load for what the file looks like.
+func LoadManifest(path string) (*Manifest, error) {
+	m := New()
+	if err := m.Load(path); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Load adds the resources described in a YAML or JSON file to the manifest,
+// so a resource can be added without writing Go. A file can hold several YAML
+// documents, each with a list of resources:
+//
+//	resources:
+//	  - kind: Package
+//	    name: tools
+//	    attributes:
+//	      names: [curl, git]
+//	  - kind: File
+//	    depends_on: [tools]
+//	    tags: [dotfiles]
+//	    attributes:
+//	      path: ~/.bashrc
+//	      content: |
+//	        export EDITOR=vim
+//
+// The kind is the name a resource kind was registered with, so the resources
+// package has to be imported for its kinds to be known. The attributes are the
+// fields of that kind, matched without regard to case or underscores, so
+// "createDirIfMissing" and "create_dir_if_missing" both set CreateDirIfMissing.
+//
+// A name sets the ID of the resource as SetName does. depends_on and
+// subscribes refer to other resources by name, or by the ID of a resource
+// already in the manifest, wherever they are in the file. ignore_failure
+// works like IgnoreFailure.
+//
+// Nothing is added unless the whole file loads. The error is a *LoadError
+// giving the line and column of the problem.
+func (m *Manifest) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return m.load(path, f)
+}
+
+// declaration is a resource as it is described in a manifest file.
+type declaration struct {
+	// node is the resource's mapping, for errors about it as a whole.
+	node *yaml.Node
+
+	attributes    ResourceAttributes
+	name          *yaml.Node
+	dependsOn     []*yaml.Node
+	subscribes    []*yaml.Node
+	tags          []string
+	ignoreFailure bool
+}
+
+// loader reads the resources out of a manifest file.
+type loader struct {
+	path string
+}
+
+func (m *Manifest) load(path string, r io.Reader) error {
+	l := loader{path: path}
+
+	var decls []declaration
+
+	dec := yaml.NewDecoder(r)
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return l.syntaxError(err)
+		}
+
+		ds, err := l.document(&doc)
+		if err != nil {
+			return err
+		}
+
+		decls = append(decls, ds...)
+	}
+
+	// Work on a copy, so nothing is added if the file turns out to be wrong
+	// part way through
+	loaded := &Manifest{resources: maps.Clone(m.resources), names: maps.Clone(m.names), rt: m.rt}
+	if loaded.resources == nil {
+		loaded.resources = make(map[ResourceID]Resource)
+	}
+
+	if err := l.add(loaded, decls); err != nil {
+		return err
+	}
+
+	m.resources = loaded.resources
+	m.names = loaded.names
+
+	return nil
+}
+
+// add adds the declared resources to the manifest, and then their
+// dependencies, since a resource can depend on one further down the file.
+func (l loader) add(m *Manifest, decls []declaration) error {
+	resources := make([]*Resource, len(decls))
+	named := make(map[string]*yaml.Node)
+
+	for i, d := range decls {
+		r, err := m.AddE(d.attributes)
+		if err != nil {
+			return l.errorf(d.node, "%s", err)
+		}
+
+		if d.name != nil {
+			if first, ok := named[d.name.Value]; ok {
+				return l.errorf(d.name, "the name %s is already used on line %d", d.name.Value, first.Line)
+			}
+
+			if _, ok := m.resources[ResourceID(d.name.Value)]; ok {
+				return l.errorf(d.name, "the name %s is already the ID of a resource", d.name.Value)
+			}
+
+			named[d.name.Value] = d.name
+			m.SetName(r, d.name.Value)
+		}
+
+		m.Tag(r, d.tags...)
+
+		if d.ignoreFailure {
+			m.IgnoreFailure(r)
+		}
+
+		resources[i] = r
+	}
+
+	for i, d := range decls {
+		for _, dep := range d.dependsOn {
+			if _, ok := m.resources[ResourceID(dep.Value)]; !ok {
+				return l.errorf(dep, "depends on %s, which is not in the manifest", dep.Value)
+			}
+
+			m.SetDep(resources[i], dep.Value)
+		}
+
+		for _, name := range d.subscribes {
+			source, ok := m.resources[ResourceID(name.Value)]
+			if !ok {
+				return l.errorf(name, "subscribes to %s, which is not in the manifest", name.Value)
+			}
+
+			m.Subscribe(resources[i], &source)
+		}
+	}
+
+	return nil
+}
+
+// document reads the resources out of a YAML document.
+func (l loader) document(doc *yaml.Node) ([]declaration, error) {
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, l.errorf(root, "expected a mapping with a list of resources")
+	}
+
+	var decls []declaration
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], resolve(root.Content[i+1])
+
+		if key.Value != "resources" {
+			return nil, l.errorf(key, "unknown key %s", key.Value)
+		}
+
+		if value.Kind != yaml.SequenceNode {
+			return nil, l.errorf(value, "resources should be a list")
+		}
+
+		for _, item := range value.Content {
+			d, err := l.declaration(resolve(item))
+			if err != nil {
+				return nil, err
+			}
+
+			decls = append(decls, d)
+		}
+	}
+
+	return decls, nil
+}
+
+// declaration reads a single resource.
+func (l loader) declaration(n *yaml.Node) (declaration, error) {
+	d := declaration{node: n}
+
+	if n.Kind != yaml.MappingNode {
+		return d, l.errorf(n, "a resource should be a mapping")
+	}
+
+	var kind, attributes *yaml.Node
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], resolve(n.Content[i+1])
+
+		var err error
+
+		switch key.Value {
+		case "kind":
+			kind, err = l.scalar(value)
+		case "name":
+			d.name, err = l.scalar(value)
+		case "depends_on":
+			d.dependsOn, err = l.scalars(value)
+		case "subscribes":
+			d.subscribes, err = l.scalars(value)
+		case "tags":
+			var tags []*yaml.Node
+			tags, err = l.scalars(value)
+			for _, tag := range tags {
+				d.tags = append(d.tags, tag.Value)
+			}
+		case "ignore_failure":
+			err = l.decode(value, &d.ignoreFailure)
+		case "attributes":
+			if value.Kind != yaml.MappingNode {
+				err = l.errorf(value, "attributes should be a mapping")
+			}
+			attributes = value
+		default:
+			err = l.errorf(key, "unknown key %s", key.Value)
+		}
+
+		if err != nil {
+			return d, err
+		}
+	}
+
+	if kind == nil {
+		return d, l.errorf(n, "the resource has no kind")
+	}
+
+	a, err := newKind(ResourceKind(kind.Value))
+	if err != nil {
+		return d, l.errorf(kind, "%s", err)
+	}
+
+	v := reflect.ValueOf(a)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return d, l.errorf(kind, "resource kind %s is not a struct", kind.Value)
+	}
+
+	if attributes != nil {
+		if err := l.fields(attributes, v.Elem(), kind.Value); err != nil {
+			return d, err
+		}
+	}
+
+	d.attributes = a
+
+	return d, nil
+}
+
+// fields sets the fields of a struct from a mapping.
+func (l loader) fields(n *yaml.Node, v reflect.Value, kind string) error {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], resolve(n.Content[i+1])
+
+		field, ok := fieldFor(v, key.Value)
+		if !ok {
+			return l.errorf(key, "%s has no field %s", kind, key.Value)
+		}
+
+		if err := l.field(value, field, kind); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fileModeType is decoded from an octal string as well as a number, since
+// that is how a mode is usually written.
+var fileModeType = reflect.TypeFor[os.FileMode]()
+
+// field sets a single field from a node.
+func (l loader) field(n *yaml.Node, field reflect.Value, kind string) error {
+	switch {
+	case field.Kind() == reflect.Struct && n.Kind == yaml.MappingNode:
+		return l.fields(n, field, kind)
+	case field.Type() == fileModeType && n.Kind == yaml.ScalarNode && n.ShortTag() == "!!str":
+		mode, err := strconv.ParseUint(n.Value, 8, 32)
+		if err != nil {
+			return l.errorf(n, "%s is not an octal file mode", n.Value)
+		}
+
+		field.SetUint(mode)
+
+		return nil
+	default:
+		return l.decode(n, field.Addr().Interface())
+	}
+}
+
+// typeErrorLine is the position yaml puts at the start of its errors, which
+// is dropped since the error is given the position of the node instead.
+var typeErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// decode decodes a node into v.
+func (l loader) decode(n *yaml.Node, v any) error {
+	err := n.Decode(v)
+
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		return l.errorf(n, "%s", typeErrorLine.ReplaceAllString(typeErr.Errors[0], ""))
+	}
+
+	if err != nil {
+		return l.errorf(n, "%s", err)
+	}
+
+	return nil
+}
+
+// scalar checks that a node is a single value.
+func (l loader) scalar(n *yaml.Node) (*yaml.Node, error) {
+	if n.Kind != yaml.ScalarNode {
+		return nil, l.errorf(n, "expected a single value")
+	}
+
+	return n, nil
+}
+
+// scalars reads a list of values, or a single value on its own.
+func (l loader) scalars(n *yaml.Node) ([]*yaml.Node, error) {
+	if n.Kind == yaml.ScalarNode {
+		return []*yaml.Node{n}, nil
+	}
+
+	if n.Kind != yaml.SequenceNode {
+		return nil, l.errorf(n, "expected a list")
+	}
+
+	values := make([]*yaml.Node, 0, len(n.Content))
+	for _, item := range n.Content {
+		value, err := l.scalar(resolve(item))
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// errorf returns an error at the position of a node.
+func (l loader) errorf(n *yaml.Node, format string, args ...any) error {
+	return &LoadError{Path: l.path, Line: n.Line, Column: n.Column, Err: fmt.Errorf(format, args...)}
+}
+
+// syntaxError returns an error for a file that is not valid YAML or JSON,
+// taking the line from the error where there is one.
+func (l loader) syntaxError(err error) error {
+	msg := err.Error()
+
+	if match := typeErrorLine.FindStringSubmatch(msg); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		return &LoadError{Path: l.path, Line: line, Err: errors.New(strings.TrimPrefix(msg, match[0]))}
+	}
+
+	return &LoadError{Path: l.path, Err: err}
+}
+
+// resolve follows an alias to the node it stands for.
+func resolve(n *yaml.Node) *yaml.Node {
+	if n.Kind == yaml.AliasNode {
+		return n.Alias
+	}
+
+	return n
+}
+
+// fieldFor finds the exported field of a struct that a key names, ignoring
+// case and underscores, including the fields of embedded structs.
+func fieldFor(v reflect.Value, key string) (reflect.Value, bool) {
+	name := fieldName(key)
+	t := v.Type()
+
+	for i := range t.NumField() {
+		if f := t.Field(i); f.IsExported() && fieldName(f.Name) == name {
+			return v.Field(i), true
+		}
+	}
+
+	for i := range t.NumField() {
+		if f := t.Field(i); f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if field, ok := fieldFor(v.Field(i), key); ok {
+				return field, true
+			}
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+// fieldName is the form a field name and a key are compared in.
+func fieldName(s string) string {
+	return strings.ToLower(strings.ReplaceAll(s, "_", ""))
+}
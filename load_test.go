@@ -0,0 +1,222 @@
+package viaduct
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testLoadResourceType is a test resource with fields of the types a manifest
+// file sets
+type testLoadResourceType struct {
+	testResourceType
+	Path               string
+	Names              []string
+	Mode               os.FileMode
+	CreateDirIfMissing bool
+	Wait               time.Duration
+}
+
+func init() {
+	RegisterKind("testLoadResourceType", func() ResourceAttributes { return &testLoadResourceType{} })
+}
+
+// loadString loads a manifest file from a string
+func loadString(t *testing.T, content string) (*Manifest, error) {
+	t.Helper()
+
+	m := New()
+	return m, m.load("manifest.yaml", strings.NewReader(content))
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	t.Run("resources and their dependencies", func(t *testing.T) {
+		t.Parallel()
+
+		m, err := loadString(t, `
+resources:
+  - kind: testLoadResourceType
+    name: config
+    depends_on: [tools]
+    tags: [dotfiles]
+    attributes:
+      value: config
+      path: ~/.config
+      mode: "0600"
+      create_dir_if_missing: true
+      wait: 5s
+  - kind: testLoadResourceType
+    name: tools
+    ignore_failure: true
+    attributes:
+      Value: tools
+      Names: [curl, git]
+      Mode: 0755
+  - kind: testLoadResourceType
+    name: restart
+    subscribes: config
+    attributes:
+      value: restart
+`)
+		assert.NoError(t, err)
+
+		if assert.Len(t, m.resources, 3) {
+			config := m.resources["config"]
+			assert.Equal(t, &testLoadResourceType{
+				testResourceType:   testResourceType{Value: "config"},
+				Path:               "~/.config",
+				Mode:               0o600,
+				CreateDirIfMissing: true,
+				Wait:               5 * time.Second,
+			}, config.Attributes)
+			assert.Equal(t, ResourceKind("testLoadResourceType"), config.ResourceKind)
+			assert.Equal(t, []ResourceID{"tools"}, config.DependsOn)
+			assert.Equal(t, []string{"dotfiles"}, config.Tags)
+
+			tools := m.resources["tools"]
+			assert.Equal(t, []string{"curl", "git"}, tools.Attributes.(*testLoadResourceType).Names)
+			assert.Equal(t, os.FileMode(0o755), tools.Attributes.(*testLoadResourceType).Mode)
+			assert.True(t, tools.IgnoreFailure)
+
+			assert.Equal(t, []ResourceID{"config"}, m.resources["restart"].Subscribes)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		t.Parallel()
+
+		m, err := loadString(t, "{\n\t\"resources\": [\n\t\t{\"kind\": \"testLoadResourceType\", \"name\": \"json\", \"attributes\": {\"Value\": \"json\"}}\n\t]\n}\n")
+		assert.NoError(t, err)
+		assert.Contains(t, m.resources, ResourceID("json"))
+	})
+
+	t.Run("several documents", func(t *testing.T) {
+		t.Parallel()
+
+		m, err := loadString(t, `
+resources:
+  - kind: testLoadResourceType
+    name: first
+---
+resources:
+  - kind: testLoadResourceType
+    name: second
+    depends_on: first
+    attributes:
+      value: second
+`)
+		assert.NoError(t, err)
+		assert.Len(t, m.resources, 2)
+		assert.Equal(t, []ResourceID{"first"}, m.resources["second"].DependsOn)
+	})
+
+	t.Run("depending on a resource added in Go", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		r := m.Add(newTestResource("go"))
+		m.SetName(r, "go")
+
+		err := m.load("manifest.yaml", strings.NewReader(`
+resources:
+  - kind: testLoadResourceType
+    depends_on: go
+`))
+		assert.NoError(t, err)
+		assert.Len(t, m.resources, 2)
+	})
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "not YAML",
+			content:  "resources:\n  - kind: [\n",
+			expected: "manifest.yaml:2: did not find expected node content",
+		},
+		{
+			name:     "unknown top-level key",
+			content:  "resource: []\n",
+			expected: "manifest.yaml:1:1: unknown key resource",
+		},
+		{
+			name:     "no kind",
+			content:  "resources:\n  - name: nameless\n",
+			expected: "manifest.yaml:2:5: the resource has no kind",
+		},
+		{
+			name:     "unknown kind",
+			content:  "resources:\n  - kind: Fiel\n",
+			expected: "manifest.yaml:2:11: resource kind Fiel is not registered",
+		},
+		{
+			name:     "unknown key",
+			content:  "resources:\n  - kind: testLoadResourceType\n    depends: [other]\n",
+			expected: "manifest.yaml:3:5: unknown key depends",
+		},
+		{
+			name:     "unknown field",
+			content:  "resources:\n  - kind: testLoadResourceType\n    attributes:\n      paht: /tmp\n",
+			expected: "manifest.yaml:4:7: testLoadResourceType has no field paht",
+		},
+		{
+			name:     "wrong type",
+			content:  "resources:\n  - kind: testLoadResourceType\n    attributes:\n      names: curl\n",
+			expected: "manifest.yaml:4:14: cannot unmarshal !!str `curl` into []string",
+		},
+		{
+			name:     "bad mode",
+			content:  "resources:\n  - kind: testLoadResourceType\n    attributes:\n      mode: \"rw\"\n",
+			expected: "manifest.yaml:4:13: rw is not an octal file mode",
+		},
+		{
+			name:     "dependency not in the manifest",
+			content:  "resources:\n  - kind: testLoadResourceType\n    depends_on: [missing]\n",
+			expected: "manifest.yaml:3:18: depends on missing, which is not in the manifest",
+		},
+		{
+			name:     "name used twice",
+			content:  "resources:\n  - kind: testLoadResourceType\n    name: twice\n  - kind: testLoadResourceType\n    name: twice\n    attributes: {value: other}\n",
+			expected: "manifest.yaml:5:11: the name twice is already used on line 3",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			m, err := loadString(t, tc.content)
+
+			var loadErr *LoadError
+			if assert.ErrorAs(t, err, &loadErr) {
+				assert.Equal(t, tc.expected, err.Error())
+			}
+
+			assert.Empty(t, m.resources, "nothing is added when the file does not load")
+		})
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "manifest.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("resources:\n  - kind: testLoadResourceType\n    name: file\n"), 0o644))
+
+	m, err := LoadManifest(path)
+	assert.NoError(t, err)
+	assert.Contains(t, m.resources, ResourceID("file"))
+
+	_, err = LoadManifest(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
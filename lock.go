@@ -66,33 +66,45 @@ func newLockSet() *lockSet {
 	return &lockSet{keyed: make(map[string]*sync.Mutex)}
 }
 
-// acquire takes the locks for a key, returning the function that releases them.
-// An empty key takes the keyless lock, which excludes all other lock holders. A
-// key that covers other domains takes those too, see lockDomains.
-func (l *lockSet) acquire(key string) func() {
+// tryAcquire takes the locks for a key if they are all free, returning the
+// function that releases them, or false without holding anything if they are
+// not. An empty key takes the keyless lock, which excludes all other lock
+// holders. A key that covers other domains takes those too, see lockDomains.
+func (l *lockSet) tryAcquire(key string) (func(), bool) {
 	if key == "" {
-		l.global.Lock()
-		return l.global.Unlock
+		if !l.global.TryLock() {
+			return nil, false
+		}
+
+		return l.global.Unlock, true
 	}
 
-	l.global.RLock()
+	if !l.global.TryRLock() {
+		return nil, false
+	}
 
 	keys := keysFor(key)
 	held := make([]*sync.Mutex, 0, len(keys))
 
-	for _, k := range keys {
-		m := l.keyMutex(k)
-		m.Lock()
-		held = append(held, m)
-	}
-
-	return func() {
+	release := func() {
 		for i := len(held) - 1; i >= 0; i-- {
 			held[i].Unlock()
 		}
 
 		l.global.RUnlock()
 	}
+
+	for _, k := range keys {
+		m := l.keyMutex(k)
+		if !m.TryLock() {
+			release()
+			return nil, false
+		}
+
+		held = append(held, m)
+	}
+
+	return release, true
 }
 
 func (l *lockSet) keyMutex(key string) *sync.Mutex {
@@ -108,3 +120,20 @@ func (l *lockSet) keyMutex(key string) *sync.Mutex {
 
 	return m
 }
+
+// locksConflict reports whether resources holding locks with these keys can't
+// run at the same time. A keyless lock conflicts with every other lock, and
+// keyed locks conflict when the keys they cover overlap.
+func locksConflict(a, b string) bool {
+	if a == "" || b == "" {
+		return true
+	}
+
+	for _, k := range keysFor(a) {
+		if slices.Contains(keysFor(b), k) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -11,54 +11,40 @@ import (
 func TestLockSet(t *testing.T) {
 	t.Parallel()
 
-	t.Run("the same key is serialised", func(t *testing.T) {
-		t.Parallel()
-
+	// excludes reports whether holding the first key keeps the second from
+	// being taken, and checks the second can be taken once the first is
+	// released
+	excludes := func(t *testing.T, held, wanted string) bool {
 		locks := newLockSet()
 
-		release := locks.acquire(PackageLock)
+		release, ok := locks.tryAcquire(held)
+		assert.True(t, ok)
 
-		var acquired bool
-		done := make(chan struct{})
+		r, ok := locks.tryAcquire(wanted)
+		if ok {
+			r()
+		}
 
-		go func() {
-			defer close(done)
+		release()
 
-			r := locks.acquire(PackageLock)
-			acquired = true
+		r, again := locks.tryAcquire(wanted)
+		if assert.True(t, again, "%q could not be taken once %q was released", wanted, held) {
 			r()
-		}()
+		}
 
-		// The second holder cannot get in until the first lets go
-		time.Sleep(20 * time.Millisecond)
-		assert.False(t, acquired)
+		return !ok
+	}
 
-		release()
-		<-done
-		assert.True(t, acquired)
+	t.Run("the same key is serialised", func(t *testing.T) {
+		t.Parallel()
+
+		assert.True(t, excludes(t, PackageLock, PackageLock))
 	})
 
 	t.Run("unrelated keys run in parallel", func(t *testing.T) {
 		t.Parallel()
 
-		locks := newLockSet()
-
-		release := locks.acquire("alpha")
-		defer release()
-
-		done := make(chan struct{})
-		go func() {
-			defer close(done)
-
-			r := locks.acquire("beta")
-			r()
-		}()
-
-		select {
-		case <-done:
-		case <-time.After(time.Second):
-			t.Fatal("an unrelated lock key had to wait")
-		}
+		assert.False(t, excludes(t, "alpha", "beta"))
 	})
 
 	t.Run("the package lock covers the passwd lock", func(t *testing.T) {
@@ -66,84 +52,46 @@ func TestLockSet(t *testing.T) {
 
 		// Package maintainer scripts call adduser, so the two domains are not
 		// independent and must not run at the same time
-		for _, keys := range [][2]string{
-			{PackageLock, PasswdLock},
-			{PasswdLock, PackageLock},
-		} {
-			locks := newLockSet()
-
-			release := locks.acquire(keys[0])
-
-			var acquired bool
-			done := make(chan struct{})
-
-			go func() {
-				defer close(done)
-
-				r := locks.acquire(keys[1])
-				acquired = true
-				r()
-			}()
-
-			time.Sleep(20 * time.Millisecond)
-			assert.False(t, acquired, "%s did not exclude %s", keys[0], keys[1])
-
-			release()
-			<-done
-			assert.True(t, acquired)
-		}
+		assert.True(t, excludes(t, PackageLock, PasswdLock))
+		assert.True(t, excludes(t, PasswdLock, PackageLock))
 	})
 
 	t.Run("a keyless lock excludes every key", func(t *testing.T) {
 		t.Parallel()
 
-		locks := newLockSet()
-
-		release := locks.acquire("")
-
-		var acquired bool
-		done := make(chan struct{})
-
-		go func() {
-			defer close(done)
-
-			r := locks.acquire(PackageLock)
-			acquired = true
-			r()
-		}()
-
-		time.Sleep(20 * time.Millisecond)
-		assert.False(t, acquired)
-
-		release()
-		<-done
-		assert.True(t, acquired)
+		assert.True(t, excludes(t, "", PackageLock))
+		assert.True(t, excludes(t, "", ""))
 	})
 
 	t.Run("a key excludes a keyless lock", func(t *testing.T) {
 		t.Parallel()
 
-		locks := newLockSet()
+		assert.True(t, excludes(t, PasswdLock, ""))
+	})
 
-		release := locks.acquire(PasswdLock)
+	t.Run("a failed attempt holds nothing", func(t *testing.T) {
+		t.Parallel()
 
-		var acquired bool
-		done := make(chan struct{})
+		locks := newLockSet()
 
-		go func() {
-			defer close(done)
+		// The package lock takes the package key before the passwd one, so
+		// failing on passwd must let go of package again
+		release, ok := locks.tryAcquire(PasswdLock)
+		assert.True(t, ok)
 
-			r := locks.acquire("")
-			acquired = true
-			r()
-		}()
+		_, ok = locks.tryAcquire(PackageLock)
+		assert.False(t, ok)
 
-		time.Sleep(20 * time.Millisecond)
-		assert.False(t, acquired)
+		key := locks.keyMutex(PackageLock)
+		if assert.True(t, key.TryLock(), "the package key was left held") {
+			key.Unlock()
+		}
 
 		release()
-		<-done
-		assert.True(t, acquired)
+
+		r, ok := locks.tryAcquire(PackageLock)
+		assert.True(t, ok)
+		r()
 	})
 
 	t.Run("holders of the same key take turns", func(t *testing.T) {
@@ -160,7 +108,11 @@ func TestLockSet(t *testing.T) {
 
 		for range 20 {
 			wg.Go(func() {
-				release := locks.acquire(PackageLock)
+				release, ok := locks.tryAcquire(PackageLock)
+				for !ok {
+					time.Sleep(time.Millisecond)
+					release, ok = locks.tryAcquire(PackageLock)
+				}
 				defer release()
 
 				mu.Lock()
@@ -182,3 +134,14 @@ func TestLockSet(t *testing.T) {
 		assert.Equal(t, 1, most)
 	})
 }
+
+func TestLocksConflict(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, locksConflict("", ""))
+	assert.True(t, locksConflict("", PackageLock))
+	assert.True(t, locksConflict(PackageLock, PackageLock))
+	assert.True(t, locksConflict(PackageLock, PasswdLock))
+	assert.True(t, locksConflict(PasswdLock, PackageLock))
+	assert.False(t, locksConflict(PasswdLock, "other"))
+}
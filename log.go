@@ -3,28 +3,30 @@ package viaduct
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/fatih/color"
+	"github.com/pmezard/go-difflib/difflib"
 )
 
 // infoWriter returns the destination for non-error output. By default this is
 // STDERR, but the --stdout flag (or VIADUCT_STDOUT) routes it to STDOUT.
 // Errors always go to STDERR.
-func infoWriter() io.Writer {
-	if Cli.Stdout {
-		return os.Stdout
-	}
-	return os.Stderr
+func (l *Logger) infoWriter() io.Writer {
+	return l.Runtime().infoWriter()
 }
 
-// LogEntry captures a single structured log call.
+// LogEntry captures a single structured log call. Level is one of the Level
+// constants, kept as a plain string.
 type LogEntry struct {
-	Level   string            `json:"level"`
-	Message string            `json:"msg"`
-	Fields  map[string]string `json:"fields,omitempty"`
+	Level   string         `json:"level"`
+	Message string         `json:"msg"`
+	Fields  map[string]any `json:"fields,omitempty"`
 }
 
 // Logger provides structured Viaduct log output.
@@ -41,52 +43,142 @@ type Logger struct {
 	// Silent suppresses all output except FATAL.
 	Silent bool
 
-	// jsonMode buffers entries instead of printing.
+	// jsonMode buffers entries for the report, in place of the console.
 	jsonMode bool
 
+	// buffered buffers entries for the report alongside the console, for a
+	// test report written to a file.
+	buffered bool
+
 	// mu guards entries. A resource that outlives its timeout keeps logging
 	// after the run has moved on, so writes can overlap a read.
 	mu sync.Mutex
 
 	// entries collects log entries in JSON mode.
 	entries []LogEntry
+
+	// changed records whether anything was logged with Info, which is how a
+	// resource says it made a change.
+	changed bool
+
+	// outcome is set when the resource states its outcome outright, and then
+	// takes precedence over changed.
+	outcome Outcome
+
+	// rt is the runtime the logger was created for. Nil means the default
+	// runtime, as the package-level helpers use it, without reading the
+	// command line.
+	rt *Runtime
+
+	// resourceID is the resource the logger was created for, if any.
+	resourceID ResourceID
+}
+
+// Runtime returns the runtime of the run the resource is part of, with the
+// options it was asked to run with and the attributes of the machine.
+func (l *Logger) Runtime() *Runtime {
+	if l.rt == nil {
+		return helperRuntime()
+	}
+
+	return l.rt
+}
+
+// DryRun reports whether the run is a dry run, in which case a resource
+// should check what it would change without changing it.
+func (l *Logger) DryRun() bool {
+	return l.Runtime().Options.DryRun
 }
 
-// addEntry buffers an entry in JSON mode.
-func (l *Logger) addEntry(level, msg string, fields []string) {
+// markChanged records that the resource made a change.
+func (l *Logger) markChanged() {
 	l.mu.Lock()
-	l.entries = append(l.entries, newEntry(level, msg, fields))
+	l.changed = true
 	l.mu.Unlock()
 }
 
-// Log emits a user-level message.
-func Log(msg string, fields ...string) {
-	l := NewLogger("Viaduct", "User")
-	if l.Silent || l.Quiet || l.jsonMode {
-		return
+// SetOutcome records what the resource did, overriding what would otherwise be
+// worked out from its logging. A custom resource only needs it when the two
+// differ, such as one that reports a change without logging it, or one that
+// uses Info for something that changed nothing.
+func (l *Logger) SetOutcome(o Outcome) {
+	l.mu.Lock()
+	l.outcome = o
+	l.mu.Unlock()
+}
+
+// Outcome returns what the resource did: whatever was set with SetOutcome, or
+// otherwise changed if anything was logged with Info and unchanged if not.
+func (l *Logger) Outcome() Outcome {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.outcome != "":
+		return l.outcome
+	case l.changed:
+		return OutcomeChanged
+	default:
+		return OutcomeUnchanged
 	}
-	l.Info(msg, fields...)
 }
 
-func NewLogger(resource, action string) *Logger {
-	if Cli.JSON {
-		return &Logger{Resource: resource, Action: action, jsonMode: true}
+// addEntry buffers an entry for the report.
+func (l *Logger) addEntry(e Event) {
+	l.mu.Lock()
+	l.entries = append(l.entries, LogEntry{Level: string(e.Level), Message: e.Message, Fields: attrMap(e.Attrs)})
+	l.mu.Unlock()
+}
+
+// log hands an event to the runtime's sinks, unless quiet or silent mode
+// leaves it out, buffering it as well for the report.
+func (l *Logger) log(level Level, msg string, fields []any) {
+	e := Event{
+		Time:       time.Now(),
+		Level:      level,
+		ResourceID: l.resourceID,
+		Resource:   l.Resource,
+		Action:     l.Action,
+		Message:    msg,
+		Attrs:      attrsOf(fields),
 	}
 
-	if Cli.Silent {
-		return NewSilentLogger()
+	if l.jsonMode || l.buffered {
+		l.addEntry(e)
 	}
 
-	if Cli.Quiet {
-		return NewQuietLogger(resource, action)
+	if l.Silent || (l.Quiet && level.quiet()) {
+		// --quiet and --silent are for the console, and the stream of
+		// events is a record of the run, so it still has everything
+		if stream := l.Runtime().events(); stream != nil {
+			_ = stream.Handle(e)
+		}
+
+		return
 	}
 
-	return &Logger{
-		Resource: resource,
-		Action:   action,
+	for _, s := range l.Runtime().sinks(l.jsonMode) {
+		_ = s.Handle(e)
 	}
 }
 
+// Log emits a user-level message, according to the options of the default
+// runtime, as NewLogger does.
+func Log(msg string, fields ...any) {
+	l := NewLogger("Viaduct", "User")
+	if l.Silent || l.Quiet || l.jsonMode {
+		return
+	}
+	l.Info(msg, fields...)
+}
+
+// NewLogger returns a logger that logs according to the options of the
+// default runtime. It does not read the command line, so the options are
+// whatever Cli holds: use Runtime.NewLogger with a runtime of its own.
+func NewLogger(resource, action string) *Logger {
+	return helperRuntime().NewLogger(resource, action)
+}
+
 func NewStandardLogger(resource, action string) *Logger {
 	return &Logger{
 		Resource: resource,
@@ -106,7 +198,7 @@ func NewSilentLogger() *Logger {
 	return &Logger{Silent: true}
 }
 
-// Entries returns the buffered log entries (for JSON mode).
+// Entries returns the buffered log entries (for JSON mode and test reports).
 func (l *Logger) Entries() []LogEntry {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -117,92 +209,136 @@ func (l *Logger) Entries() []LogEntry {
 	return out
 }
 
-// Info logs that an action was taken. Suppressed in Quiet and Silent modes.
-func (l *Logger) Info(msg string, fields ...string) {
-	if l.jsonMode {
-		l.addEntry("OK", msg, fields)
-		return
-	}
-
-	if l.Silent || l.Quiet {
-		return
-	}
-
-	fmt.Fprintln(infoWriter(), formatLine(okTag, l.Resource, l.Action, msg, fields))
+// Info logs that an action was taken, which marks the resource as having made
+// a change. Suppressed in Quiet and Silent modes.
+//
+// Fields are passed the way log/slog takes them, as alternating keys and
+// values or as slog.Attr values, and keep their types in JSON and in slog.
+func (l *Logger) Info(msg string, fields ...any) {
+	l.markChanged()
+	l.log(LevelOK, msg, fields)
 }
 
 // Noop logs that a resource is already in the desired state.
 // Suppressed in Quiet and Silent modes.
-func (l *Logger) Noop(msg string, fields ...string) {
-	if l.jsonMode {
-		l.addEntry("NOOP", msg, fields)
-		return
-	}
+func (l *Logger) Noop(msg string, fields ...any) {
+	l.log(LevelNoop, msg, fields)
+}
 
-	if l.Silent || l.Quiet {
+// Diff logs a unified diff of what a dry run would change in the content at
+// path. It goes with an Info line for the change itself, so it does not mark
+// the resource as changed. Nothing is logged outside a dry run, where the
+// change has already been made and its content could be anything, including
+// secrets, or when before and after are the same. Suppressed in Quiet and
+// Silent modes.
+func (l *Logger) Diff(path, before, after string) {
+	if !l.DryRun() || before == after {
 		return
 	}
 
-	fmt.Fprintln(infoWriter(), formatLine(noopTag, l.Resource, l.Action, msg, fields))
+	l.log(LevelDiff, "diff", []any{"path", path, "diff", unifiedDiff(path, before, after)})
 }
 
-// Warn logs a warning message. Suppressed only in Silent mode.
-func (l *Logger) Warn(msg string, fields ...string) {
-	if l.jsonMode {
-		l.addEntry("WARN", msg, fields)
-		return
+// diffLimit is the size of content beyond which it is not diffed, since
+// working out a diff takes time that grows with the square of the number of
+// lines.
+const diffLimit = 256 << 10
+
+// unifiedDiff renders the difference between two versions of the content at
+// path, with three lines of context like "diff -u". Binary content, and
+// content over diffLimit, is only said to differ, as "diff" does for binary
+// files.
+func unifiedDiff(path, before, after string) string {
+	if before == after {
+		return ""
 	}
 
-	if l.Silent {
-		return
+	if isBinary(before) || isBinary(after) {
+		return fmt.Sprintf("Binary content of %s differs\n", path)
 	}
 
-	fmt.Fprintln(os.Stderr, formatLine(warnTag, l.Resource, l.Action, msg, fields))
-}
-
-// Error logs an error message. Suppressed only in Silent mode.
-func (l *Logger) Error(msg string, fields ...string) {
-	if l.jsonMode {
-		l.addEntry("ERR", msg, fields)
-		return
+	if len(before) > diffLimit || len(after) > diffLimit {
+		return fmt.Sprintf("Content of %s differs, and is too large to diff\n", path)
 	}
 
-	if l.Silent {
-		return
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(before),
+		B:        diffLines(after),
+		FromFile: path,
+		ToFile:   path,
+		Context:  3,
+	})
+	if err != nil {
+		// The diff is written to a buffer, so this never happens
+		return ""
 	}
 
-	fmt.Fprintln(os.Stderr, formatLine(failTag, l.Resource, l.Action, msg, fields))
+	return diff
 }
 
-// Fatal logs an error message and exits.
-func (l *Logger) Fatal(msg string, fields ...string) {
-	if l.jsonMode {
-		l.addEntry("FATAL", msg, fields)
-		os.Exit(1)
+// isBinary reports whether content is not text, going by whether it has a NUL
+// byte or is not valid UTF-8.
+func isBinary(content string) bool {
+	return strings.ContainsRune(content, 0) || !utf8.ValidString(content)
+}
+
+// diffLines splits content into lines for a diff, each ending with a newline.
+// difflib's own SplitLines adds an empty line to content that ends with one.
+func diffLines(content string) []string {
+	if content == "" {
+		return nil
 	}
 
-	if l.Silent {
-		os.Exit(1)
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
 	}
 
-	fmt.Fprintln(os.Stderr, formatLine(failTag, l.Resource, l.Action, msg, fields))
-	os.Exit(1)
+	lines[len(lines)-1] += "\n"
+
+	return lines
 }
 
-func newEntry(level, msg string, fields []string) LogEntry {
-	entry := LogEntry{
-		Level:   level,
-		Message: msg,
-	}
+// formatDiff indents a diff under the line it belongs to, colouring additions
+// and removals.
+func formatDiff(diff string) string {
+	var b strings.Builder
 
-	if len(fields) >= 2 {
-		entry.Fields = make(map[string]string)
-		for i := 0; i+1 < len(fields); i += 2 {
-			entry.Fields[fields[i]] = fields[i+1]
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			line = color.New(color.Bold).Sprint(line)
+		case strings.HasPrefix(line, "+"):
+			line = color.New(color.FgGreen).Sprint(line)
+		case strings.HasPrefix(line, "-"):
+			line = color.New(color.FgRed).Sprint(line)
+		case strings.HasPrefix(line, "@@"):
+			line = color.New(color.FgCyan).Sprint(line)
 		}
+
+		fmt.Fprintf(&b, "%*s%s\n", diffIndent, "", line)
 	}
 
-	return entry
+	return b.String()
+}
+
+// diffIndent lines a diff up with the resource name of the line above it.
+const diffIndent = 6
+
+// Warn logs a warning message. Suppressed only in Silent mode.
+func (l *Logger) Warn(msg string, fields ...any) {
+	l.log(LevelWarn, msg, fields)
+}
+
+// Error logs an error message. Suppressed only in Silent mode.
+func (l *Logger) Error(msg string, fields ...any) {
+	l.log(LevelError, msg, fields)
+}
+
+// Fatal logs an error message and exits.
+func (l *Logger) Fatal(msg string, fields ...any) {
+	l.log(LevelFatal, msg, fields)
+	os.Exit(1)
 }
 
 // formatLine builds a human-readable output line:
@@ -212,19 +348,31 @@ func newEntry(level, msg string, fields []string) LogEntry {
 //	FAIL  Execute [Run] command failed command="echo hello"
 const resourceActionWidth = 22
 
-func formatLine(tag, resource, action, msg string, fields []string) string {
+func formatLine(tag, resource, action, msg string, attrs []slog.Attr) string {
 	var b strings.Builder
 
 	ra := fmt.Sprintf("%s [%s]", resource, action)
 	fmt.Fprintf(&b, "%s  %-*s %s", tag, resourceActionWidth, ra, msg)
-
-	for i := 0; i+1 < len(fields); i += 2 {
-		fmt.Fprintf(&b, " %s=%s", fields[i], quoteIfNeeded(fields[i+1]))
-	}
+	writeAttrs(&b, "", attrs)
 
 	return b.String()
 }
 
+// writeAttrs writes attributes as key=value pairs, with the attributes of a
+// group prefixed by its key.
+func writeAttrs(b *strings.Builder, prefix string, attrs []slog.Attr) {
+	for _, a := range attrs {
+		v := a.Value.Resolve()
+
+		if v.Kind() == slog.KindGroup {
+			writeAttrs(b, prefix+a.Key+".", v.Group())
+			continue
+		}
+
+		fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, quoteIfNeeded(v.String()))
+	}
+}
+
 // quoteIfNeeded wraps a value in double quotes if it contains spaces or
 // special characters.
 func quoteIfNeeded(v string) string {
@@ -2,6 +2,7 @@ package viaduct
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,11 +13,18 @@ func TestInfoWriter(t *testing.T) {
 	orig := Cli.Stdout
 	defer func() { Cli.Stdout = orig }()
 
+	l := NewLogger("Test", "Test")
+
 	Cli.Stdout = false
-	assert.Equal(t, os.Stderr, infoWriter())
+	assert.Equal(t, os.Stderr, l.infoWriter())
 
 	Cli.Stdout = true
-	assert.Equal(t, os.Stdout, infoWriter())
+	assert.Equal(t, os.Stdout, l.infoWriter())
+
+	t.Run("from the logger's runtime", func(t *testing.T) {
+		l := (&Runtime{Options: &Options{Stdout: false}}).NewLogger("Test", "Test")
+		assert.Equal(t, os.Stderr, l.infoWriter())
+	})
 }
 
 func TestEnvDuration(t *testing.T) {
@@ -63,3 +71,76 @@ func TestEnvBool(t *testing.T) {
 		assert.False(t, envBool(name))
 	})
 }
+
+func TestLoggerChanged(t *testing.T) {
+	l := NewSilentLogger()
+
+	l.Noop("up-to-date")
+	l.Warn("careful")
+	assert.Equal(t, OutcomeUnchanged, l.Outcome())
+
+	// Info is how a resource says it did something, whatever the output mode
+	l.Info("created")
+	assert.Equal(t, OutcomeChanged, l.Outcome())
+
+	// An outcome set outright wins over what was logged
+	l.SetOutcome(OutcomeUnchanged)
+	assert.Equal(t, OutcomeUnchanged, l.Outcome())
+}
+
+func TestLoggerDiff(t *testing.T) {
+	t.Run("unified diff", func(t *testing.T) {
+		diff := unifiedDiff("/etc/motd", "a\nb\n", "a\nc\n")
+
+		assert.Contains(t, diff, "--- /etc/motd\n")
+		assert.Contains(t, diff, "+++ /etc/motd\n")
+		assert.Contains(t, diff, "-b\n")
+		assert.Contains(t, diff, "+c\n")
+	})
+
+	t.Run("no diff when nothing changed", func(t *testing.T) {
+		assert.Empty(t, unifiedDiff("/etc/motd", "a\n", "a\n"))
+	})
+
+	t.Run("indented under the line above", func(t *testing.T) {
+		for _, line := range strings.Split(strings.TrimSuffix(formatDiff("-a\n+b\n"), "\n"), "\n") {
+			assert.True(t, strings.HasPrefix(line, "      "), line)
+		}
+	})
+
+	t.Run("binary content is not diffed", func(t *testing.T) {
+		assert.Equal(t, "Binary content of /bin/true differs\n", unifiedDiff("/bin/true", "a\x00b", "a\x00c"))
+	})
+
+	t.Run("large content is not diffed", func(t *testing.T) {
+		large := strings.Repeat("a\n", diffLimit)
+		assert.Equal(t, "Content of /etc/motd differs, and is too large to diff\n", unifiedDiff("/etc/motd", "a\n", large))
+	})
+
+	t.Run("only logged in a dry run", func(t *testing.T) {
+		l := &Logger{jsonMode: true, rt: &Runtime{Options: &Options{}}}
+		l.Diff("/etc/motd", "a\n", "b\n")
+
+		assert.Empty(t, l.Entries())
+	})
+
+	t.Run("recorded in JSON mode without marking a change", func(t *testing.T) {
+		l := &Logger{jsonMode: true, rt: &Runtime{Options: &Options{DryRun: true}}}
+		l.Diff("/etc/motd", "a\n", "b\n")
+
+		entries := l.Entries()
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "DIFF", entries[0].Level)
+			assert.Equal(t, "/etc/motd", entries[0].Fields["path"])
+			assert.Contains(t, entries[0].Fields["diff"], "+b\n")
+		}
+
+		assert.Equal(t, OutcomeUnchanged, l.Outcome())
+	})
+}
+
+func TestDiffLines(t *testing.T) {
+	assert.Equal(t, []string{"a\n", "b\n"}, diffLines("a\nb\n"))
+	assert.Equal(t, []string{"a\n", "b\n"}, diffLines("a\nb"))
+	assert.Empty(t, diffLines(""))
+}
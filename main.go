@@ -4,25 +4,56 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 )
 
 var (
+	// Attribute holds the attributes of the machine for the default
+	// runtime, once Init has run.
 	Attribute SystemAttributes
-	Cli       CliFlags
+	// Cli holds the options for the default runtime, read from the command
+	// line once Init has run.
+	Cli CliFlags
 
-	tmpDirPath string
+	initOnce       sync.Once
+	attributesOnce sync.Once
 )
 
-func init() {
-	initAttributes(&Attribute)
-	initCli(&Cli)
+// Init reads the command-line flags into Cli and the attributes of the machine
+// into Attribute, which is what a standalone binary built with viaduct wants.
+// It only does anything the first time it is called.
+//
+// New calls it, so a binary that starts with viaduct.New() never needs to. A
+// program with command-line flags of its own should use NewRuntime and
+// NewWithRuntime instead, which leave the flags alone.
+func Init() {
+	initOnce.Do(func() {
+		initDefaultAttributes()
+		initCli(&Cli)
 
-	if Cli.Attributes {
-		fmt.Println(Attribute.JSON())
-		os.Exit(0)
-	}
+		if Cli.Attributes {
+			fmt.Println(Attribute.JSON())
+			os.Exit(0)
+		}
 
-	if Cli.DryRun {
-		log.Println("WARNING: dry run mode enabled")
-	}
+		if Cli.ListKinds {
+			if err := printKinds(os.Stdout, Cli.JSON); err != nil {
+				log.Fatal(err)
+			}
+
+			os.Exit(0)
+		}
+
+		if Cli.DryRun {
+			log.Println("WARNING: dry run mode enabled")
+		}
+	})
+}
+
+// initDefaultAttributes reads the attributes of the machine into Attribute,
+// the first time it is called. Unlike Init, it leaves the command line alone.
+func initDefaultAttributes() {
+	attributesOnce.Do(func() {
+		initAttributes(&Attribute)
+	})
 }
@@ -1,17 +1,21 @@
 package viaduct
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -21,8 +25,10 @@ const (
 	// manifest can take as long as it likes overall.
 	defaultResourceTimeout = 5 * time.Minute
 
-	// dependencyPollInterval is how often to check dependency status.
-	dependencyPollInterval = 10 * time.Millisecond
+	// defaultConcurrency is how many resources run at once. Most resources
+	// spend their time waiting on the network or a child process, so this is
+	// well above the number of CPUs.
+	defaultConcurrency = 32
 )
 
 type Status string
@@ -32,28 +38,109 @@ const (
 	DependencyFailed Status = "DependencyFailed"
 	Failed           Status = "Failed"
 	Pending          Status = "Pending"
+	Skipped          Status = "Skipped"
 	Success          Status = "Success"
 )
 
+// Outcome is what a resource did to the machine, as opposed to whether it
+// succeeded. A second run of a converged manifest leaves every resource
+// unchanged.
+type Outcome string
+
+const (
+	// OutcomeUnchanged means the resource found everything as it should be.
+	OutcomeUnchanged Outcome = "Unchanged"
+	// OutcomeChanged means the resource changed something.
+	OutcomeChanged Outcome = "Changed"
+	// OutcomeSkipped means the resource did not run, because a dependency
+	// failed, because it was left out of the run with --only or --skip,
+	// because a guard such as OnlyIf stopped it or, for a handler, because
+	// nothing notified it.
+	OutcomeSkipped Outcome = "Skipped"
+)
+
 // Manifest is a map of resources to allow concurrent runs
 type Manifest struct {
 	resources map[ResourceID]Resource
 	collector *ResultCollector
 
+	// rt is the runtime the manifest runs with.
+	rt *Runtime
+
 	// resourceTimeout is the manifest-wide timeout for a single resource.
 	// Zero uses defaultResourceTimeout.
 	resourceTimeout time.Duration
 
+	// concurrency is how many resources run at once. Zero uses
+	// defaultConcurrency.
+	concurrency int
+
 	// abandoned holds the first resource the run gave up on, if any.
 	abandoned atomic.Pointer[ResourceID]
+
+	// failedFast holds the first resource that failed with --fail-fast, if
+	// any.
+	failedFast atomic.Pointer[ResourceID]
+
+	// applied is set once Apply has been called, since it can only be
+	// called once.
+	applied atomic.Bool
+
+	// statePath overrides where the state of the run is saved.
+	statePath string
+
+	// stateDisabled stops the state of the run from being saved.
+	stateDisabled bool
+
+	// prune removes what the last run applied that is no longer in the
+	// manifest.
+	prune bool
+
+	// pruned holds what the last run applied that is no longer in the
+	// manifest, by the ID it had then.
+	pruned map[ResourceID]pruneEntry
+
+	// pruners are the resources added to remove it, which run before
+	// anything else.
+	pruners map[ResourceID]bool
+
+	// deselected are the resources left out of the run with --only or
+	// --skip.
+	deselected map[ResourceID]bool
+
+	// lastState is the state saved by the last run, which is kept for the
+	// resources left out of this one.
+	lastState State
+
+	// names are the IDs given with SetName, which are what a mistyped
+	// dependency was most likely meant to be.
+	names map[ResourceID]bool
+
+	// allowDangling lets the manifest run with dependencies on resources
+	// that aren't in it.
+	allowDangling bool
 }
 
+// New creates a manifest that runs with the default runtime, which reads the
+// command line the first time it is used.
 func New() *Manifest {
+	return NewWithRuntime(DefaultRuntime())
+}
+
+// NewWithRuntime creates a manifest that runs with the given runtime, for a
+// program that embeds viaduct and has a command line of its own.
+func NewWithRuntime(rt *Runtime) *Manifest {
 	return &Manifest{
 		resources: make(map[ResourceID]Resource),
+		rt:        rt,
 	}
 }
 
+// Runtime returns the runtime the manifest runs with.
+func (m *Manifest) Runtime() *Runtime {
+	return m.rt
+}
+
 // SetResourceTimeout sets how long each resource in this manifest is given to
 // run before the run gives up on it. The default is five minutes.
 //
@@ -62,10 +149,12 @@ func New() *Manifest {
 // it for a resource that is legitimately slow, such as a large download or a
 // clone of a big repository, with WithTimeout.
 //
-// Be aware that a resource cannot be cancelled, so a timeout stops the run
-// waiting for it and reports it as failed while the operation itself carries on:
-// whatever it was part way through, such as a download or a package install, is
-// left as it is.
+// A resource that implements ContextRunner, as the standard Execute, Package,
+// Download, Git, Service and Apt resources do, is cancelled when it overruns:
+// its child processes are killed or its transfer aborted, and the run carries on
+// without it. Any other resource cannot be cancelled, so a timeout stops the run
+// waiting for it and reports it as failed while the operation itself carries on,
+// and nothing else in the run is started.
 //
 // The --resource-timeout flag overrides this, and WithTimeout overrides it for
 // a single resource. A negative duration means no timeout.
@@ -73,6 +162,19 @@ func (m *Manifest) SetResourceTimeout(d time.Duration) {
 	m.resourceTimeout = d
 }
 
+// SetConcurrency sets how many resources run at once. The default is 32, and
+// a negative number means no limit.
+//
+// Resources are started as soon as everything they depend on has finished, so
+// this only limits how many independent resources run side by side. A resource
+// waiting for a lock key does not count towards the limit until it holds the
+// lock.
+//
+// The --concurrency flag overrides this.
+func (m *Manifest) SetConcurrency(n int) {
+	m.concurrency = n
+}
+
 // WithTimeout sets how long a single resource is given to run, overriding the
 // manifest setting.
 func (m *Manifest) WithTimeout(r *Resource, d time.Duration) {
@@ -82,20 +184,88 @@ func (m *Manifest) WithTimeout(r *Resource, d time.Duration) {
 	}
 }
 
+// IgnoreFailure makes a failure of the resource a warning rather than an
+// error. It is for things the machine works fine without, such as a font
+// download: everything that depends on the resource still runs, and the run
+// does not fail because of it.
+func (m *Manifest) IgnoreFailure(r *Resource) {
+	if v, ok := m.resources[r.ResourceID]; ok {
+		v.IgnoreFailure = true
+		m.resources[r.ResourceID] = v
+	}
+}
+
 // SetName allows us to overwrite the generated ID with our name. This name
-// still needs to be unique.
+// still needs to be unique. Dependencies and subscriptions already made on the
+// resource follow it to its new name.
+//
+// A name that is already taken, or a resource that isn't in the manifest, is
+// fatal: use SetNameE to get the error back instead.
 func (m *Manifest) SetName(r *Resource, newName string) {
-	if res, ok := m.resources[r.ResourceID]; ok {
-		old := r.ResourceID
-		newID := ResourceID(newName)
+	if err := m.SetNameE(r, newName); err != nil {
+		m.rt.NewLogger("Viaduct", "Compile").Fatal(err.Error())
+	}
+}
+
+// SetNameE is like SetName, but returns an error rather than exiting when the
+// resource cannot be renamed.
+func (m *Manifest) SetNameE(r *Resource, newName string) error {
+	old := r.ResourceID
+	newID := ResourceID(newName)
+
+	res, ok := m.resources[old]
+	if !ok {
+		return fmt.Errorf("unknown resource: %s", attrJSON(r.Attributes))
+	}
+
+	if newID == old {
+		return nil
+	}
+
+	if taken, ok := m.resources[newID]; ok {
+		return fmt.Errorf("cannot name %s %s %s: the name is already taken by a %s resource", res.ResourceKind, old, newName, taken.ResourceKind)
+	}
+
+	res.ResourceID = newID
+	m.resources[newID] = res
+
+	delete(m.resources, old)
+
+	// Anything added earlier refers to the resource by the ID it had then
+	for id, other := range m.resources {
+		if slices.Contains(other.DependsOn, old) || slices.Contains(other.Subscribes, old) {
+			other.DependsOn = renameID(other.DependsOn, old, newID)
+			other.Subscribes = renameID(other.Subscribes, old, newID)
+			m.resources[id] = other
+		}
+	}
+
+	r.ResourceID = newID
+
+	if m.names == nil {
+		m.names = make(map[ResourceID]bool)
+	}
+	delete(m.names, old)
+	m.names[newID] = true
+
+	return nil
+}
 
-		res.ResourceID = newID
-		m.resources[newID] = res
+// renameID returns ids with old replaced by newID, leaving ids itself alone
+// since a resource handed out by Add may share it.
+func renameID(ids []ResourceID, old, newID ResourceID) []ResourceID {
+	if !slices.Contains(ids, old) {
+		return ids
+	}
 
-		delete(m.resources, old)
-	} else {
-		log.Fatalf("Unknown resource: %s", attrJSON(r.Attributes))
+	renamed := slices.Clone(ids)
+	for i, id := range renamed {
+		if id == old {
+			renamed[i] = newID
+		}
 	}
+
+	return renamed
 }
 
 // WithDep sets an explicit dependency using a name
@@ -106,6 +276,32 @@ func (m *Manifest) SetDep(r *Resource, name string) {
 	}
 }
 
+// Notify makes target a handler of source: target runs once at the end of the
+// run, and only if source made a change. A typical use is restarting a service
+// when its configuration file was updated.
+//
+// A handler notified by several resources still runs once, however many of
+// them changed, so five updated configuration files restart the service a
+// single time. A handler that was not notified is reported as Skipped.
+//
+// Nothing may depend on a handler other than another handler, since handlers
+// run after everything else.
+func (m *Manifest) Notify(source, target *Resource) {
+	m.Subscribe(target, source)
+}
+
+// Subscribe makes target a handler of each of the sources, which is the same
+// as calling Notify for each of them.
+func (m *Manifest) Subscribe(target *Resource, sources ...*Resource) {
+	if v, ok := m.resources[target.ResourceID]; ok {
+		for _, source := range sources {
+			v.Subscribes = append(v.Subscribes, source.ResourceID)
+		}
+
+		m.resources[target.ResourceID] = v
+	}
+}
+
 // WithLock serialises the resource against every other lock holder in the
 // run. Use WithLockKey when you know which domain the resource contends on.
 func (m *Manifest) WithLock(r *Resource) {
@@ -143,8 +339,8 @@ func (m *Manifest) addResource(r *Resource, a ResourceAttributes) (err error) {
 		return err
 	}
 
-	if _, ok := m.resources[r.ResourceID]; ok {
-		return fmt.Errorf("resource already exists:\n%s", attrJSON(r))
+	if existing, ok := m.managedBy(r); ok {
+		return duplicateError(existing, r)
 	}
 
 	m.resources[r.ResourceID] = *r
@@ -152,23 +348,83 @@ func (m *Manifest) addResource(r *Resource, a ResourceAttributes) (err error) {
 	return err
 }
 
+// managedBy returns the resource already in the manifest that r would
+// duplicate: one with the same ID or, for a resource with an identity, one
+// that manages the same thing the same way under a name given with SetName, or
+// under a path given from the home directory rather than in full.
+func (m *Manifest) managedBy(r *Resource) (Resource, bool) {
+	if existing, ok := m.resources[r.ResourceID]; ok {
+		return existing, true
+	}
+
+	key := m.identityKey(r.ResourceKind, r.Attributes)
+	if key == "" {
+		return Resource{}, false
+	}
+
+	for _, existing := range m.resources {
+		if existing.ResourceKind == r.ResourceKind && m.identityKey(existing.ResourceKind, existing.Attributes) == key {
+			return existing, true
+		}
+	}
+
+	return Resource{}, false
+}
+
+// identityKey is the ID a resource with an identity would have, with "~" in
+// its identity expanded so that equivalent paths compare the same. It is empty
+// for a resource with no identity.
+func (m *Manifest) identityKey(kind ResourceKind, a ResourceAttributes) ResourceID {
+	i, ok := a.(Identifier)
+	if !ok || i.Identity() == "" {
+		return ""
+	}
+
+	return ResourceID(fmt.Sprintf("%s.%s:%s", kind, a.OperationName(), m.rt.ExpandPath(i.Identity())))
+}
+
+// duplicateError says why r cannot be added alongside existing. Resources with
+// an identity can share it while disagreeing about everything else, such as
+// two files with the same path and different content, so the error names
+// both.
+func duplicateError(existing Resource, r *Resource) error {
+	if i, ok := r.Attributes.(Identifier); ok && attrJSON(existing.Attributes) != attrJSON(r.Attributes) {
+		return fmt.Errorf("resource already exists: %s %s is already managed by %s, with different attributes:\n%s\n%s",
+			r.ResourceKind, i.Identity(), existing.ResourceID, attrJSON(existing.Attributes), attrJSON(r.Attributes))
+	}
+
+	return fmt.Errorf("resource already exists:\n%s", attrJSON(r))
+}
+
+// Add adds a resource to the manifest, depending on deps. A resource that
+// cannot be added, such as one that is already in the manifest, is fatal: use
+// AddE to get the error back instead.
 func (m *Manifest) Add(attributes ResourceAttributes, deps ...*Resource) *Resource {
-	l := NewLogger("Viaduct", "Compile")
+	r, err := m.AddE(attributes, deps...)
+	if err != nil {
+		m.rt.NewLogger("Viaduct", "Compile").Fatal(err.Error())
+	}
+
+	return r
+}
 
+// AddE is like Add, but returns an error rather than exiting when the
+// resource cannot be added.
+func (m *Manifest) AddE(attributes ResourceAttributes, deps ...*Resource) (*Resource, error) {
 	r, err := newResource(deps)
 	if err != nil {
-		l.Fatal(err.Error())
+		return nil, err
 	}
 
 	if err := r.init(attributes); err != nil {
-		l.Fatal(err.Error())
+		return nil, err
 	}
 
 	if err := m.addResource(r, attributes); err != nil {
-		l.Fatal(err.Error())
+		return nil, err
 	}
 
-	return r
+	return r, nil
 }
 
 // ResourceChain is the ordered sequence of resources returned by Chain,
@@ -245,6 +501,31 @@ func (m *Manifest) ChainTo(to *Resource, attributes ...ResourceAttributes) Resou
 	return chain
 }
 
+// MarshalJSON encodes the resources in the manifest by ID, which is what
+// --dump-manifest writes.
+func (m *Manifest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.resources)
+}
+
+// UnmarshalJSON decodes resources encoded with MarshalJSON into the manifest,
+// in place of any it had. The kind of each resource has to be registered for
+// its attributes to be rebuilt. Guards are functions, so they can't be encoded
+// and a decoded resource has none.
+func (m *Manifest) UnmarshalJSON(data []byte) error {
+	resources := make(map[ResourceID]Resource)
+	if err := json.Unmarshal(data, &resources); err != nil {
+		return err
+	}
+
+	m.resources = resources
+
+	if m.rt == nil {
+		m.rt = DefaultRuntime()
+	}
+
+	return nil
+}
+
 func attrJSON(a any) string {
 	str, err := json.MarshalIndent(a, "", "  ")
 	if err != nil {
@@ -255,85 +536,62 @@ func attrJSON(a any) string {
 }
 
 // Run will run the specified resources concurrently, taking into account
-// any dependencies that have been declared
+// any dependencies that have been declared. It prints what happened and exits
+// if anything failed: use Apply to get the report back instead.
 func (m *Manifest) Run() {
-	l := NewLogger("Viaduct", "Run")
-	start := time.Now()
-	l.Info("started")
-	l.Info("preflight-checks")
+	l := m.rt.NewLogger("Viaduct", "Run")
 
-	if Cli.JSON {
-		m.collector = newResultCollector()
+	if m.rt.Options.Plan {
+		m.printPlan(l)
 	}
 
-	// A cycle can never make progress, so fail before any resource does work
-	// rather than waiting for the dependency timeout to notice.
-	if err := m.dependencyCycle(); err != nil {
-		l.Error("dependency-cycle", "error", err.Error())
-		os.Exit(1)
+	if m.rt.Options.Graph != "" && !m.graphAfterRun() {
+		m.printGraph(l)
 	}
 
-	var preflightFailed bool
-	for id, resource := range m.resources {
-		if err := resource.preflight(); err != nil {
-			if r, ok := m.resources[id]; ok {
-				r.Err = err
-				r.Message = err.Error()
-				m.resources[id] = r
-			}
+	// An interrupt cancels the run rather than killing viaduct outright, so
+	// the commands resources are running, which have process groups of their
+	// own, are killed rather than left behind
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-			preflightFailed = true
-		}
-	}
+	report, err := m.Apply(ctx)
 
-	if preflightFailed {
-		for _, resource := range m.resources {
-			if resource.Err != nil {
-				l.Error("preflight-failed",
-					"resource_id", string(resource.ResourceID),
-					"resource_kind", string(resource.ResourceKind),
-					"error", resource.Message,
-				)
-			}
+	var check *checkError
+	var preflight *PreflightError
+
+	switch {
+	case errors.As(err, &check):
+		l.Error(check.msg, "error", check.err.Error())
+		os.Exit(1)
+	case errors.As(err, &preflight):
+		for _, f := range preflight.Failures {
+			l.Error("preflight-failed",
+				"resource_id", string(f.ResourceID),
+				"resource_kind", string(f.ResourceKind),
+				"error", f.Err.Error(),
+			)
 		}
 
 		os.Exit(1)
+	case err != nil && !errors.Is(err, ErrResourcesFailed):
+		l.Fatal(err.Error())
 	}
 
-	var lock sync.RWMutex
-	var wg sync.WaitGroup
-
-	locks := newLockSet()
-
-	wg.Add(len(m.resources))
+	withErrors := len(report.Failures) > 0
 
-	for _, resource := range m.resources {
-		go m.apply(resource, &wg, &lock, locks)
+	if id := m.failedFast.Load(); id != nil && !m.rt.Options.JSON {
+		l.Warn("stopped", "msg", "--fail-fast stopped the run once a resource failed", "resource_id", string(*id))
 	}
 
-	wg.Wait()
-
-	timeTaken := time.Since(start).Round(time.Second).String()
-
-	// Whether the run failed and what gets reported come from the same place,
-	// so a resource can never fail the run without being named.
-	failures := collectFailures(m.resources)
-	withErrors := len(failures) > 0
-
-	if Cli.JSON {
-		status := "success"
-		if withErrors {
-			status = "failed"
-		}
-
-		output := RunOutput{
-			Status:    status,
-			Duration:  timeTaken,
-			Resources: m.collector.Results(),
-			Failures:  failures,
-		}
-
-		out, err := json.MarshalIndent(output, "", "  ")
+	switch {
+	case m.rt.Options.JSON && m.rt.Options.Graph != "":
+		// The graph takes the place of the report on STDOUT
+	case m.rt.Options.Output == OutputJSONL:
+		// Everything is in the stream of events, which ends with the
+		// summary
+	case m.rt.Options.JSON:
+		out, err := json.MarshalIndent(report, "", "  ")
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -342,22 +600,40 @@ func (m *Manifest) Run() {
 		if withErrors {
 			os.Exit(1)
 		}
-	} else {
+	default:
+		if m.rt.Options.Drift {
+			printDrift(report.Drift, l)
+		}
+
 		if withErrors {
-			l.Warn("completed-with-errors", "duration", timeTaken)
+			l.Warn("completed-with-errors", "summary", report.Summary.String(), "duration", report.Duration)
 		} else {
-			l.Info("completed", "duration", timeTaken)
+			l.Info("completed", "summary", report.Summary.String(), "duration", report.Duration)
 		}
 
 		if withErrors {
-			printFailuresTree(failures, l)
+			printFailuresTree(report.Failures, l)
+		}
+
+		if report.Profile != nil {
+			printProfile(os.Stdout, report.Profile)
+		}
+	}
+
+	if m.rt.Options.Graph != "" {
+		// STDOUT is the stream of events with --output=jsonl, which has
+		// to stay JSON to the end
+		if m.rt.Options.Output == OutputJSONL {
+			m.writeGraph(l, os.Stderr)
+		} else {
+			m.writeGraph(l, os.Stdout)
 		}
 	}
 
-	if Cli.DumpManifest {
+	if m.rt.Options.DumpManifest {
 		tmpName := fmt.Sprintf("/tmp/viaduct-%d.json", time.Now().Unix())
 
-		out, err := json.MarshalIndent(m.resources, "", "    ")
+		out, err := json.MarshalIndent(m, "", "    ")
 		if err != nil {
 			l.Fatal(err.Error())
 		}
@@ -371,81 +647,65 @@ func (m *Manifest) Run() {
 	}
 
 	if withErrors {
-		if !Cli.DumpManifest && !Cli.JSON {
+		if !m.rt.Options.DumpManifest && !m.rt.Options.JSON && m.rt.Options.Output != OutputJSONL {
 			l.Info("hint", "msg", "to see all resources, run with --dump-manifest")
 		}
 		os.Exit(1)
 	}
 
 	// Tidy up temporary directory if there were no errors
-	err := os.RemoveAll(filepath.Join(Attribute.TmpDir))
+	err = os.RemoveAll(filepath.Join(m.rt.Attributes.TmpDir))
 	if err != nil {
 		l.Fatal(err.Error())
 	}
-}
-
-func (m *Manifest) apply(r Resource, wg *sync.WaitGroup, lock *sync.RWMutex, locks *lockSet) {
-	defer wg.Done()
 
-	if err := m.abandonedErr(); err != nil {
-		m.fail(&r, lock, DependencyFailed, err)
-		return
+	// Drift is not an error, but a check in CI still needs to tell it apart
+	// from a clean run
+	if len(report.Drift) > 0 {
+		os.Exit(2)
 	}
+}
 
-	if err := m.dependencyCheck(&r, lock); err != nil {
-		m.fail(&r, lock, DependencyFailed, err)
-		return
+// printPlan prints the plan for --plan and exits, with an error if the
+// manifest could not run.
+func (m *Manifest) printPlan(l *Logger) {
+	plan, err := m.Plan()
+	if err != nil {
+		l.Fatal(err.Error())
 	}
 
-	if r.GlobalLock {
-		release := locks.acquire(r.LockKey)
-		defer release()
+	if err := printPlan(os.Stdout, plan, m.rt.Options.JSON); err != nil {
+		l.Fatal(err.Error())
 	}
 
-	// The lock is released when a resource is abandoned, because holding it
-	// would block everything sharing the key for the rest of the run. That
-	// means whatever was waiting for it has to check again: an abandoned
-	// operation still holds the real lock, dpkg's or passwd's, so starting the
-	// next one now would just fail against it.
-	if err := m.abandonedErr(); err != nil {
-		m.fail(&r, lock, DependencyFailed, err)
-		return
+	if len(plan.Dangling) > 0 && !m.allowDangling {
+		os.Exit(1)
 	}
 
-	// Run the resource operation, bounded by its own timeout
-	logger, runErr := r.run(m.timeoutFor(&r))
-	if runErr != nil {
-		if errors.Is(runErr, errAbandoned) {
-			// The operation is still going and the machine is in a state we no
-			// longer know, so nothing else should start
-			id := r.ResourceID
-			m.abandoned.CompareAndSwap(nil, &id)
-		}
+	os.Exit(0)
+}
 
-		m.setStatus(&r, lock, Failed)
-		m.setError(&r, lock, runErr)
-	} else {
-		m.setStatus(&r, lock, Success)
-	}
+// graphAfterRun reports whether --graph is printed once the run has finished,
+// rather than in place of it.
+func (m *Manifest) graphAfterRun() bool {
+	return m.rt.Options.Apply || m.rt.Options.DryRun
+}
 
-	if m.collector != nil {
-		status := string(Success)
-		errMsg := ""
-		if runErr != nil {
-			status = string(Failed)
-			errMsg = runErr.Error()
-		}
+// printGraph prints the dependency graph for --graph and exits, without
+// applying anything.
+func (m *Manifest) printGraph(l *Logger) {
+	m.writeGraph(l, os.Stdout)
+	os.Exit(0)
+}
 
-		m.collector.Add(ResourceResult{
-			ResourceID:   string(r.ResourceID),
-			ResourceKind: string(r.ResourceKind),
-			Description:  r.Attributes.Description(),
-			Operation:    r.Attributes.OperationName(),
-			Status:       status,
-			Error:        errMsg,
-			Log:          logger.Entries(),
-		})
+// writeGraph writes the dependency graph in the format given with --graph.
+func (m *Manifest) writeGraph(l *Logger, w io.Writer) {
+	out, err := m.Graph().Format(m.rt.Options.Graph)
+	if err != nil {
+		l.Fatal(err.Error())
 	}
+
+	fmt.Fprint(w, out)
 }
 
 // abandonedErr reports why nothing further should start, once the run has given
@@ -458,68 +718,128 @@ func (m *Manifest) abandonedErr() error {
 	return nil
 }
 
+// failFast stops the run from starting anything else once a resource has
+// failed, when running with --fail-fast.
+func (m *Manifest) failFast(r *Resource) {
+	if m.rt.Options.FailFast && !r.IgnoreFailure {
+		id := r.ResourceID
+		m.failedFast.CompareAndSwap(nil, &id)
+	}
+}
+
+// stopped reports whether nothing further should start because of
+// --fail-fast.
+func (m *Manifest) stopped() bool {
+	return m.failedFast.Load() != nil
+}
+
 // fail records a resource failure, along with its result when collecting for
 // JSON output.
 func (m *Manifest) fail(r *Resource, lock *sync.RWMutex, status Status, err error) {
 	m.setStatus(r, lock, status)
+	m.setOutcome(r, lock, OutcomeSkipped)
 	m.setError(r, lock, err)
 
-	if m.collector != nil {
-		m.collector.Add(ResourceResult{
-			ResourceID:   string(r.ResourceID),
-			ResourceKind: string(r.ResourceKind),
-			Description:  r.Attributes.Description(),
-			Operation:    r.Attributes.OperationName(),
-			Status:       string(status),
-			Error:        err.Error(),
-		})
-	}
+	m.record(ResourceResult{
+		ResourceID:   string(r.ResourceID),
+		ResourceKind: string(r.ResourceKind),
+		Description:  r.Attributes.Description(),
+		Operation:    r.Attributes.OperationName(),
+		Status:       string(status),
+		Outcome:      string(OutcomeSkipped),
+		Timing:       r.timing,
+		Error:        err.Error(),
+	})
 }
 
-// dependencyCheck blocks until every dependency of the resource has succeeded,
-// returning an error if one of them failed.
-//
-// There is no timeout on the wait itself, because waiting is not what goes
-// wrong: every resource is bounded by its own timeout, and dependency cycles
-// are rejected before the run starts, so a dependency always reaches a
-// terminal status eventually. Timing out here would only fail whichever
-// resource happened to be waiting on the slow one, which is the least useful
-// place to report it.
-func (m *Manifest) dependencyCheck(r *Resource, lock *sync.RWMutex) error {
-	if len(r.DependsOn) == 0 {
-		return nil
+// skip records that a resource did not run, along with its result when
+// collecting for JSON output.
+func (m *Manifest) skip(r *Resource, lock *sync.RWMutex) {
+	m.skipLogged(r, lock, nil)
+}
+
+// skipLogged records a resource that did not run, along with whatever was
+// logged about why.
+func (m *Manifest) skipLogged(r *Resource, lock *sync.RWMutex, log *Logger) {
+	m.setStatus(r, lock, Skipped)
+	m.setOutcome(r, lock, OutcomeSkipped)
+
+	result := ResourceResult{
+		ResourceID:   string(r.ResourceID),
+		ResourceKind: string(r.ResourceKind),
+		Description:  r.Attributes.Description(),
+		Operation:    r.Attributes.OperationName(),
+		Status:       string(Skipped),
+		Outcome:      string(OutcomeSkipped),
+		Timing:       r.timing,
 	}
 
-	ticker := time.NewTicker(dependencyPollInterval)
-	defer ticker.Stop()
+	if log != nil {
+		result.Log = log.Entries()
+	}
 
-	for {
-		depsSuccess := true
+	m.record(result)
+}
 
-		for _, dep := range r.DependsOn {
-			lock.RLock()
-			d, ok := m.resources[dep]
-			lock.RUnlock()
+// notified reports whether a handler has been notified by any of the resources
+// it subscribes to, which is when one of them succeeded and made a change.
+func (m *Manifest) notified(r *Resource, lock *sync.RWMutex) bool {
+	lock.RLock()
+	defer lock.RUnlock()
 
-			if !ok {
-				continue
-			}
+	for _, id := range r.Subscribes {
+		if source, ok := m.resources[id]; ok && source.Status == Success && source.Outcome == OutcomeChanged {
+			return true
+		}
+	}
 
-			if d.Failed() {
-				return fmt.Errorf("upstream dependency %s returned an error", d.ResourceID)
-			}
+	return false
+}
+
+// handlerCheck returns an error if an ordinary resource depends on a handler.
+// Handlers only run once everything else has finished, so the dependency could
+// never be met.
+func (m *Manifest) handlerCheck() error {
+	ids := make([]ResourceID, 0, len(m.resources))
+	for id := range m.resources {
+		ids = append(ids, id)
+	}
+
+	for _, id := range sortedIDs(ids) {
+		r := m.resources[id]
+		if r.Handler() {
+			continue
+		}
 
-			if d.Status != Success {
-				depsSuccess = false
+		for _, dep := range sortedIDs(r.DependsOn) {
+			if d, ok := m.resources[dep]; ok && d.Handler() {
+				return fmt.Errorf("%s depends on handler %s, which only runs at the end of the run", id, dep)
 			}
 		}
+	}
+
+	return nil
+}
+
+// dependencyCheck returns an error if any dependency of the resource failed.
+// The scheduler only applies a resource once all of its dependencies have
+// reached a terminal status, so there is nothing left to wait for here.
+func (m *Manifest) dependencyCheck(r *Resource, lock *sync.RWMutex) error {
+	for _, dep := range r.DependsOn {
+		lock.RLock()
+		d, ok := m.resources[dep]
+		lock.RUnlock()
 
-		if depsSuccess {
-			return nil
+		if !ok {
+			continue
 		}
 
-		<-ticker.C
+		if d.Failed() {
+			return fmt.Errorf("upstream dependency %s returned an error", d.ResourceID)
+		}
 	}
+
+	return nil
 }
 
 // timeoutFor returns how long a resource is given to run. The
@@ -528,8 +848,8 @@ func (m *Manifest) dependencyCheck(r *Resource, lock *sync.RWMutex) error {
 // negative duration means no timeout.
 func (m *Manifest) timeoutFor(r *Resource) time.Duration {
 	switch {
-	case Cli.ResourceTimeout != 0:
-		return Cli.ResourceTimeout
+	case m.rt.Options.ResourceTimeout != 0:
+		return m.rt.Options.ResourceTimeout
 	case r.Timeout != 0:
 		return r.Timeout
 	case m.resourceTimeout != 0:
@@ -539,9 +859,29 @@ func (m *Manifest) timeoutFor(r *Resource) time.Duration {
 	}
 }
 
+// concurrencyFor returns how many resources run at once, or zero when there is
+// no limit. The --concurrency flag wins, then the manifest, then the default.
+func (m *Manifest) concurrencyFor() int {
+	n := defaultConcurrency
+
+	switch {
+	case m.rt.Options.Concurrency != 0:
+		n = m.rt.Options.Concurrency
+	case m.concurrency != 0:
+		n = m.concurrency
+	}
+
+	if n < 0 {
+		return 0
+	}
+
+	return n
+}
+
 // dependencyCycle returns an error describing a cycle in the dependency graph,
-// if there is one. Dependencies on resources that aren't in the manifest are
-// ignored, matching how they are skipped during a run.
+// if there is one. A handler waits for the resources it subscribes to, so
+// those count as dependencies too. Dependencies on resources that aren't in
+// the manifest are ignored, matching how they are skipped during a run.
 func (m *Manifest) dependencyCycle() error {
 	const (
 		unvisited = iota
@@ -557,7 +897,7 @@ func (m *Manifest) dependencyCycle() error {
 		state[id] = visiting
 		path = append(path, id)
 
-		for _, dep := range sortedIDs(m.resources[id].DependsOn) {
+		for _, dep := range sortedIDs(m.resources[id].edges()) {
 			if _, ok := m.resources[dep]; !ok {
 				continue
 			}
@@ -624,6 +964,20 @@ func (m *Manifest) setStatus(r *Resource, lock *sync.RWMutex, s Status) {
 	lock.Unlock()
 }
 
+func (m *Manifest) setOutcome(r *Resource, lock *sync.RWMutex, o Outcome) {
+	// The outcome is the last thing recorded about a resource, so this is
+	// when it finished
+	r.timing.Finished = time.Now()
+
+	lock.Lock()
+	if re, ok := m.resources[r.ResourceID]; ok {
+		re.Outcome = o
+		re.timing = r.timing
+		m.resources[r.ResourceID] = re
+	}
+	lock.Unlock()
+}
+
 func (m *Manifest) setError(r *Resource, lock *sync.RWMutex, err error) {
 	lock.Lock()
 	if re, ok := m.resources[r.ResourceID]; ok {
@@ -663,24 +1017,80 @@ func (c *ResultCollector) Results() []ResourceResult {
 
 // ResourceResult is a single resource's outcome in a run.
 type ResourceResult struct {
-	ResourceID   string     `json:"resource_id"`
-	ResourceKind string     `json:"resource_kind"`
-	Description  string     `json:"description"`
-	Operation    string     `json:"operation"`
-	Status       string     `json:"status"`
-	Error        string     `json:"error,omitempty"`
-	Log          []LogEntry `json:"log,omitempty"`
+	ResourceID   string `json:"resource_id"`
+	ResourceKind string `json:"resource_kind"`
+	Description  string `json:"description"`
+	Operation    string `json:"operation"`
+	Status       string `json:"status"`
+	Outcome      string `json:"outcome"`
+	// Attempts is how many times the resource was tried, and Duration how
+	// long that took, when it ran.
+	Attempts int    `json:"attempts,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	// AttemptLog is each attempt in turn, with the error that made it retry.
+	AttemptLog []AttemptResult `json:"attempt_log,omitempty"`
+	// Timing is when the resource ran, and how long it waited first.
+	Timing Timing `json:"timing"`
+	Error  string `json:"error,omitempty"`
+	// Ignored is set when the resource failed with IgnoreFailure.
+	Ignored bool       `json:"ignored,omitempty"`
+	Log     []LogEntry `json:"log,omitempty"`
+}
+
+// RunSummary counts the resources in a run by what happened to them.
+type RunSummary struct {
+	Resources int `json:"resources"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+	// Ignored counts the resources that failed with IgnoreFailure, which
+	// are not counted as failed.
+	Ignored int `json:"ignored"`
+}
+
+// summarise counts the resources in the manifest once the run is over.
+func summarise(resources map[ResourceID]Resource) RunSummary {
+	s := RunSummary{Resources: len(resources)}
+
+	for _, r := range resources {
+		switch r.Outcome {
+		case OutcomeChanged:
+			s.Changed++
+		case OutcomeUnchanged:
+			s.Unchanged++
+		case OutcomeSkipped:
+			s.Skipped++
+		}
+
+		switch {
+		case r.Failed():
+			s.Failed++
+		case r.Status == Failed:
+			s.Ignored++
+		}
+	}
+
+	return s
 }
 
-// RunOutput is the top-level JSON output for a run.
-type RunOutput struct {
-	Status    string           `json:"status"`
-	Duration  string           `json:"duration"`
-	Resources []ResourceResult `json:"resources"`
-	Failures  []failureSummary `json:"failures,omitempty"`
+// String renders the summary for the final line of a run.
+func (s RunSummary) String() string {
+	out := fmt.Sprintf("%d resources, %d changed, %d failed", s.Resources, s.Changed, s.Failed)
+	if s.Skipped > 0 {
+		out += fmt.Sprintf(", %d skipped", s.Skipped)
+	}
+
+	if s.Ignored > 0 {
+		out += fmt.Sprintf(", %d failures ignored", s.Ignored)
+	}
+
+	return out
 }
 
-type failureDependent struct {
+// FailureDependent is a resource that was not applied because something it
+// depends on failed.
+type FailureDependent struct {
 	ResourceID   string `json:"resource_id"`
 	ResourceKind string `json:"resource_kind"`
 	Description  string `json:"description"`
@@ -689,18 +1099,24 @@ type failureDependent struct {
 	Error        string `json:"error"`
 }
 
-type failureSummary struct {
-	ResourceID   string             `json:"resource_id"`
-	ResourceKind string             `json:"resource_kind"`
-	Description  string             `json:"description"`
-	Operation    string             `json:"operation"`
-	Status       string             `json:"status"`
-	Error        string             `json:"error"`
-	Dependents   []failureDependent `json:"dependents,omitempty"`
+// FailureSummary is a resource that failed, along with everything that was
+// not applied because of it.
+type FailureSummary struct {
+	ResourceID   string `json:"resource_id"`
+	ResourceKind string `json:"resource_kind"`
+	Description  string `json:"description"`
+	Operation    string `json:"operation"`
+	Status       string `json:"status"`
+	Error        string `json:"error"`
+	// Stdout and Stderr are what the command that failed wrote, when the
+	// resource failed because a command did.
+	Stdout     string             `json:"stdout,omitempty"`
+	Stderr     string             `json:"stderr,omitempty"`
+	Dependents []FailureDependent `json:"dependents,omitempty"`
 }
 
-func resourceToDependent(r Resource) failureDependent {
-	return failureDependent{
+func resourceToDependent(r Resource) FailureDependent {
+	return FailureDependent{
 		ResourceID:   string(r.ResourceID),
 		ResourceKind: string(r.ResourceKind),
 		Description:  r.Attributes.Description(),
@@ -712,13 +1128,15 @@ func resourceToDependent(r Resource) failureDependent {
 
 // collectFailures groups failed resources into root failures and their
 // cascading dependency failures.
-func collectFailures(resources map[ResourceID]Resource) []failureSummary {
+func collectFailures(resources map[ResourceID]Resource) []FailureSummary {
 	// Split into root failures and dependency failures.
 	var roots []Resource
 	depFailed := make(map[ResourceID]Resource)
 
 	for _, r := range resources {
 		switch {
+		case r.IgnoreFailure && r.Status == Failed:
+			// Already reported as a warning, and it doesn't fail the run
 		case r.Status == DependencyFailed:
 			depFailed[r.ResourceID] = r
 		case r.Status == Failed, r.Err != nil:
@@ -750,9 +1168,9 @@ func collectFailures(resources map[ResourceID]Resource) []failureSummary {
 	}
 
 	// Build summaries.
-	var summaries []failureSummary
+	var summaries []FailureSummary
 	for _, r := range roots {
-		s := failureSummary{
+		s := FailureSummary{
 			ResourceID:   string(r.ResourceID),
 			ResourceKind: string(r.ResourceKind),
 			Description:  r.Attributes.Description(),
@@ -761,8 +1179,13 @@ func collectFailures(resources map[ResourceID]Resource) []failureSummary {
 			Error:        r.Message,
 		}
 
+		var cmdErr *CommandError
+		if errors.As(r.Err, &cmdErr) {
+			s.Stdout, s.Stderr = cmdErr.Stdout, cmdErr.Stderr
+		}
+
 		// Collect dependents claimed by this root.
-		var deps []failureDependent
+		var deps []FailureDependent
 		for depID, rootID := range claimed {
 			if rootID == r.ResourceID {
 				deps = append(deps, resourceToDependent(depFailed[depID]))
@@ -787,7 +1210,7 @@ func collectFailures(resources map[ResourceID]Resource) []failureSummary {
 		return orphans[i].ResourceID < orphans[j].ResourceID
 	})
 	for _, r := range orphans {
-		summaries = append(summaries, failureSummary{
+		summaries = append(summaries, FailureSummary{
 			ResourceID:   string(r.ResourceID),
 			ResourceKind: string(r.ResourceKind),
 			Description:  r.Attributes.Description(),
@@ -831,7 +1254,11 @@ func traceToRoot(r Resource, resources map[ResourceID]Resource, rootIDs map[Reso
 	return ""
 }
 
-func printFailuresTree(failures []failureSummary, l *Logger) {
+// failureStderrLines is how many lines of a failed command's standard error
+// the failures tree shows.
+const failureStderrLines = 10
+
+func printFailuresTree(failures []FailureSummary, l *Logger) {
 	var b strings.Builder
 
 	b.WriteString("Failed resources:\n")
@@ -840,6 +1267,16 @@ func printFailuresTree(failures []failureSummary, l *Logger) {
 		fmt.Fprintf(&b, "\n  %s [%s] %s\n", f.ResourceKind, f.Operation, f.Description)
 		fmt.Fprintf(&b, "  Error: %s\n", f.Error)
 
+		// The whole output is in the log, so the end of it is enough here
+		if f.Stderr != "" {
+			lines := strings.Split(strings.TrimRight(f.Stderr, "\n"), "\n")
+
+			b.WriteString("  Stderr:\n")
+			for _, line := range lines[max(0, len(lines)-failureStderrLines):] {
+				fmt.Fprintf(&b, "    | %s\n", line)
+			}
+		}
+
 		for i, d := range f.Dependents {
 			isLast := i == len(f.Dependents)-1
 			prefix := "├──"
@@ -1,7 +1,11 @@
 package viaduct
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"os/exec"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -15,6 +19,7 @@ func TestSetName(t *testing.T) {
 	m := New()
 	r := m.Add(testResource)
 	m.SetName(r, "test-name")
+	assert.Equal(t, ResourceID("test-name"), r.ResourceID)
 
 	expected := map[ResourceID]Resource{
 		ResourceID("test-name"): {
@@ -26,6 +31,46 @@ func TestSetName(t *testing.T) {
 	}
 
 	assert.Equal(t, m.resources, expected)
+
+	t.Run("dependencies follow the resource", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := m.Add(newTestResource("a"))
+		b := m.Add(newTestResource("b"), a)
+		c := m.Add(newTestResource("c"))
+		m.Subscribe(c, a)
+		m.SetName(a, "a")
+
+		assert.Equal(t, []ResourceID{"a"}, m.resources[b.ResourceID].DependsOn)
+		assert.Equal(t, []ResourceID{"a"}, m.resources[c.ResourceID].Subscribes)
+		assert.NoError(t, m.Validate())
+	})
+
+	t.Run("a name that is taken", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := m.Add(newTestResource("a"))
+		b := m.Add(newTestResource("b"))
+		assert.NoError(t, m.SetNameE(a, "name"))
+
+		old := b.ResourceID
+		assert.EqualError(t, m.SetNameE(b, "name"), fmt.Sprintf("cannot name testResourceType %s name: the name is already taken by a testResourceType resource", old))
+		assert.Equal(t, old, b.ResourceID)
+		assert.Equal(t, "a", m.resources["name"].Attributes.Description(), "the resource with the name is kept")
+		assert.Contains(t, m.resources, old)
+
+		assert.NoError(t, m.SetNameE(a, "name"), "naming a resource what it is already called")
+	})
+
+	t.Run("a resource not in the manifest", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		r := New().Add(newTestResource("elsewhere"))
+		assert.ErrorContains(t, m.SetNameE(r, "name"), "unknown resource")
+	})
 }
 
 func TestSetDep(t *testing.T) {
@@ -48,6 +93,56 @@ func TestSetDep(t *testing.T) {
 	assert.Equal(t, expected, m.resources)
 }
 
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	a := m.Add(newTestResource("a"))
+	b := m.Add(newTestResource("b"))
+	h := m.Add(newTestResource("handler"))
+
+	m.Notify(a, h)
+	m.Subscribe(h, b)
+
+	stored := m.resources[h.ResourceID]
+	assert.Equal(t, []ResourceID{a.ResourceID, b.ResourceID}, stored.Subscribes)
+	assert.True(t, stored.Handler())
+
+	// Notifying does not make the source a handler
+	assert.False(t, m.resources[a.ResourceID].Handler())
+}
+
+func TestHandlerCheck(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a resource cannot depend on a handler", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := m.Add(newTestResource("a"))
+		h := m.Add(newTestResource("handler"))
+		m.Notify(a, h)
+		m.Add(newTestResource("b"), h)
+
+		err := m.handlerCheck()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "depends on handler")
+	})
+
+	t.Run("a handler can depend on another handler", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := m.Add(newTestResource("a"))
+		first := m.Add(newTestResource("first"))
+		second := m.Add(newTestResource("second"), first)
+		m.Notify(a, first)
+		m.Notify(a, second)
+
+		assert.NoError(t, m.handlerCheck())
+	})
+}
+
 func TestWithLock(t *testing.T) {
 	t.Parallel()
 
@@ -149,6 +244,36 @@ func TestAddResource(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("error if the identity is managed twice", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		first, err := m.AddE(&testIdentityResourceType{testResourceType: testResourceType{Value: "a"}, Path: "/etc/motd"})
+		assert.NoError(t, err)
+
+		_, err = m.AddE(&testIdentityResourceType{testResourceType: testResourceType{Value: "b"}, Path: "/etc/motd"})
+		assert.ErrorContains(t, err, "resource already exists: testIdentityResourceType /etc/motd is already managed by testIdentityResourceType.Test:/etc/motd, with different attributes")
+
+		m.SetName(first, "motd")
+
+		_, err = m.AddE(&testIdentityResourceType{testResourceType: testResourceType{Value: "b"}, Path: "/etc/motd"})
+		assert.ErrorContains(t, err, "is already managed by motd", "a name given with SetName does not hide the duplicate")
+
+		_, err = m.AddE(&testIdentityResourceType{testResourceType: testResourceType{Value: "b"}, Path: "/etc/issue"})
+		assert.NoError(t, err)
+	})
+
+	t.Run("error if the identity is managed twice from the home directory", func(t *testing.T) {
+		t.Parallel()
+
+		m := NewWithRuntime(homeRuntime("/home/test"))
+		_, err := m.AddE(&testIdentityResourceType{testResourceType: testResourceType{Value: "a"}, Path: "~/.bashrc"})
+		assert.NoError(t, err)
+
+		_, err = m.AddE(&testIdentityResourceType{testResourceType: testResourceType{Value: "b"}, Path: "/home/test/.bashrc"})
+		assert.ErrorContains(t, err, "is already managed by testIdentityResourceType.Test:~/.bashrc")
+	})
+
 	t.Run("keyed lock from params", func(t *testing.T) {
 		t.Parallel()
 
@@ -394,10 +519,22 @@ func TestDependencyCycle(t *testing.T) {
 
 		assert.NoError(t, m.dependencyCycle())
 	})
+
+	t.Run("detects a cycle through handlers", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := m.Add(newTestResource("a"))
+		b := m.Add(newTestResource("b"))
+		m.Notify(a, b)
+		m.Notify(b, a)
+
+		assert.Error(t, m.dependencyCycle())
+	})
 }
 
 func TestDependencyCheck(t *testing.T) {
-	t.Run("returns immediately without dependencies", func(t *testing.T) {
+	t.Run("succeeds without dependencies", func(t *testing.T) {
 		m := New()
 		r := m.Add(newTestResource("a"))
 
@@ -405,17 +542,13 @@ func TestDependencyCheck(t *testing.T) {
 		assert.NoError(t, m.dependencyCheck(r, &lock))
 	})
 
-	t.Run("waits for a dependency to succeed", func(t *testing.T) {
+	t.Run("succeeds when a dependency succeeded", func(t *testing.T) {
 		m := New()
 		a := m.Add(newTestResource("a"))
 		b := m.Add(newTestResource("b"), a)
 
 		var lock sync.RWMutex
-
-		go func() {
-			time.Sleep(20 * time.Millisecond)
-			m.setStatus(a, &lock, Success)
-		}()
+		m.setStatus(a, &lock, Success)
 
 		r := m.resources[b.ResourceID]
 		assert.NoError(t, m.dependencyCheck(&r, &lock))
@@ -434,26 +567,6 @@ func TestDependencyCheck(t *testing.T) {
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "upstream dependency")
 	})
-
-	t.Run("waits as long as a slow dependency takes", func(t *testing.T) {
-		m := New()
-		a := m.Add(newTestResource("a"))
-		b := m.Add(newTestResource("b"), a)
-
-		// Each resource is bounded by its own timeout, so waiting behind a
-		// slow one is never a failure however long the run takes
-		m.SetResourceTimeout(10 * time.Millisecond)
-
-		var lock sync.RWMutex
-
-		go func() {
-			time.Sleep(100 * time.Millisecond)
-			m.setStatus(a, &lock, Success)
-		}()
-
-		r := m.resources[b.ResourceID]
-		assert.NoError(t, m.dependencyCheck(&r, &lock))
-	})
 }
 
 func TestResourceTimeout(t *testing.T) {
@@ -465,12 +578,7 @@ func TestResourceTimeout(t *testing.T) {
 		r := m.Add(blocked)
 		m.SetResourceTimeout(20 * time.Millisecond)
 
-		var lock sync.RWMutex
-		var wg sync.WaitGroup
-
-		wg.Add(1)
-		m.apply(*r, &wg, &lock, newLockSet())
-		wg.Wait()
+		newScheduler(m, 0).apply(*r)
 
 		// The failure is reported against the resource that overran, rather
 		// than against whatever happened to be waiting for it
@@ -489,13 +597,7 @@ func TestResourceTimeout(t *testing.T) {
 		b := m.Add(newTestResource("b"), a)
 		m.SetResourceTimeout(20 * time.Millisecond)
 
-		var lock sync.RWMutex
-		var wg sync.WaitGroup
-
-		wg.Add(2)
-		go m.apply(m.resources[a.ResourceID], &wg, &lock, newLockSet())
-		go m.apply(m.resources[b.ResourceID], &wg, &lock, newLockSet())
-		wg.Wait()
+		newScheduler(m, 0).run()
 
 		assert.Equal(t, Failed, m.resources[a.ResourceID].Status)
 		assert.Equal(t, DependencyFailed, m.resources[b.ResourceID].Status)
@@ -518,15 +620,9 @@ func TestResourceTimeout(t *testing.T) {
 		b := m.Add(later)
 		m.SetResourceTimeout(20 * time.Millisecond)
 
-		var lock sync.RWMutex
-		var wg sync.WaitGroup
-
-		locks := newLockSet()
-
-		wg.Add(2)
-		m.apply(m.resources[a.ResourceID], &wg, &lock, locks)
-		m.apply(m.resources[b.ResourceID], &wg, &lock, locks)
-		wg.Wait()
+		s := newScheduler(m, 0)
+		s.apply(m.resources[a.ResourceID])
+		s.apply(m.resources[b.ResourceID])
 
 		// The abandoned operation is still running and still holds whatever it
 		// locked, so the rest of the run does not go near it
@@ -536,17 +632,35 @@ func TestResourceTimeout(t *testing.T) {
 		assert.Contains(t, m.resources[b.ResourceID].Message, string(a.ResourceID))
 	})
 
+	t.Run("a cancellable resource that overruns does not stop the run", func(t *testing.T) {
+		cancelled := newCancellableTestResource("a")
+		later := newTestResource("b")
+
+		m := New()
+		a := m.Add(cancelled)
+		b := m.Add(later)
+		m.SetResourceTimeout(20 * time.Millisecond)
+
+		s := newScheduler(m, 0)
+		s.apply(m.resources[a.ResourceID])
+		s.apply(m.resources[b.ResourceID])
+
+		// The operation was stopped rather than left running, so it fails on
+		// its own and everything else carries on
+		assert.Equal(t, Failed, m.resources[a.ResourceID].Status)
+		assert.Contains(t, m.resources[a.ResourceID].Message, "was cancelled")
+		assert.Nil(t, m.abandoned.Load())
+
+		assert.True(t, later.ran.Load())
+		assert.Equal(t, Success, m.resources[b.ResourceID].Status)
+	})
+
 	t.Run("a resource within its timeout succeeds", func(t *testing.T) {
 		m := New()
 		r := m.Add(newTestResource("a"))
 		m.SetResourceTimeout(time.Minute)
 
-		var lock sync.RWMutex
-		var wg sync.WaitGroup
-
-		wg.Add(1)
-		m.apply(*r, &wg, &lock, newLockSet())
-		wg.Wait()
+		newScheduler(m, 0).apply(*r)
 
 		assert.Equal(t, Success, m.resources[r.ResourceID].Status)
 		assert.NoError(t, m.resources[r.ResourceID].Err)
@@ -559,17 +673,12 @@ func TestResourceTimeout(t *testing.T) {
 		r := m.Add(slow)
 		m.SetResourceTimeout(-1)
 
-		var lock sync.RWMutex
-		var wg sync.WaitGroup
-
 		go func() {
 			time.Sleep(50 * time.Millisecond)
 			slow.release()
 		}()
 
-		wg.Add(1)
-		m.apply(*r, &wg, &lock, newLockSet())
-		wg.Wait()
+		newScheduler(m, 0).apply(*r)
 
 		assert.Equal(t, Success, m.resources[r.ResourceID].Status)
 	})
@@ -791,3 +900,157 @@ func TestCollectFailures(t *testing.T) {
 		assert.Empty(t, failures)
 	})
 }
+
+func TestOutcome(t *testing.T) {
+	failing := newFailingTestResource("failing")
+	failing.changes = true
+
+	m := New()
+	changed := m.Add(newChangingTestResource("changed"))
+	unchanged := m.Add(newTestResource("unchanged"))
+	failed := m.Add(failing)
+	skipped := m.Add(newTestResource("skipped"), failed)
+
+	newScheduler(m, 0).run()
+
+	assert.Equal(t, OutcomeChanged, m.resources[changed.ResourceID].Outcome)
+	assert.Equal(t, OutcomeUnchanged, m.resources[unchanged.ResourceID].Outcome)
+	assert.Equal(t, OutcomeChanged, m.resources[failed.ResourceID].Outcome)
+	assert.Equal(t, OutcomeSkipped, m.resources[skipped.ResourceID].Outcome)
+
+	summary := summarise(m.resources)
+	assert.Equal(t, RunSummary{Resources: 4, Changed: 2, Unchanged: 1, Skipped: 1, Failed: 2}, summary)
+	assert.Equal(t, "4 resources, 2 changed, 2 failed, 1 skipped", summary.String())
+}
+
+func TestRunSummary(t *testing.T) {
+	t.Parallel()
+
+	s := RunSummary{Resources: 42, Changed: 3, Unchanged: 39}
+	assert.Equal(t, "42 resources, 3 changed, 0 failed", s.String())
+
+	s = RunSummary{Resources: 42, Changed: 3, Unchanged: 37, Skipped: 1, Ignored: 1}
+	assert.Equal(t, "42 resources, 3 changed, 0 failed, 1 skipped, 1 failures ignored", s.String())
+}
+
+func TestIgnoreFailure(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	font := m.Add(newFailingTestResource("font"))
+	m.IgnoreFailure(font)
+
+	after := newTestResource("after")
+	a := m.Add(after, font)
+
+	newScheduler(m, 0).run()
+
+	r := m.resources[font.ResourceID]
+	assert.Equal(t, Failed, r.Status)
+	assert.False(t, r.Failed())
+
+	// What depends on it still runs, and the run does not fail
+	assert.True(t, after.ran.Load())
+	assert.Equal(t, Success, m.resources[a.ResourceID].Status)
+	assert.Empty(t, collectFailures(m.resources))
+
+	summary := summarise(m.resources)
+	assert.Equal(t, 0, summary.Failed)
+	assert.Equal(t, 1, summary.Ignored)
+}
+
+func TestFailFast(t *testing.T) {
+	Cli.SetFailFast()
+	defer func() { Cli.FailFast = false }()
+
+	t.Run("a failure stops the run", func(t *testing.T) {
+		m := New()
+		failing := m.Add(newFailingTestResource("failing"))
+
+		newScheduler(m, 0).run()
+
+		if assert.True(t, m.stopped()) {
+			assert.Equal(t, failing.ResourceID, *m.failedFast.Load())
+		}
+	})
+
+	t.Run("nothing starts once the run has stopped", func(t *testing.T) {
+		m := New()
+		later := newTestResource("later")
+		l := m.Add(later)
+
+		id := ResourceID("failing")
+		m.failedFast.Store(&id)
+
+		newScheduler(m, 0).run()
+
+		assert.False(t, later.ran.Load())
+		assert.Equal(t, Skipped, m.resources[l.ResourceID].Status)
+		assert.Empty(t, collectFailures(m.resources))
+	})
+
+	t.Run("an ignored failure does not stop the run", func(t *testing.T) {
+		m := New()
+		failing := m.Add(newFailingTestResource("failing"))
+		m.IgnoreFailure(failing)
+
+		later := newTestResource("later")
+		m.Add(later, failing)
+
+		newScheduler(m, 0).run()
+
+		assert.False(t, m.stopped())
+		assert.True(t, later.ran.Load())
+	})
+}
+
+func TestRunGraph(t *testing.T) {
+	t.Parallel()
+
+	if mode := os.Getenv("VIADUCT_TEST_GRAPH"); mode != "" {
+		opts := &Options{Graph: GraphDOT, Silent: true, Apply: mode != "graph"}
+		if mode == "jsonl" {
+			opts.Output = OutputJSONL
+		}
+
+		m := NewWithRuntime(&Runtime{Options: opts, Attributes: &Attribute})
+		m.DisableState()
+		failing := m.Add(newFailingTestResource("failing"))
+		m.SetName(failing, "failing")
+
+		m.Run()
+		os.Exit(0)
+	}
+
+	var stderr strings.Builder
+
+	run := func(mode string) (string, error) {
+		stderr.Reset()
+
+		// nolint:gosec
+		cmd := exec.Command(os.Args[0], "-test.run=^TestRunGraph$")
+		cmd.Env = append(os.Environ(), "VIADUCT_TEST_GRAPH="+mode)
+		cmd.Stderr = &stderr
+
+		out, err := cmd.Output()
+
+		return string(out), err
+	}
+
+	out, err := run("graph")
+	assert.NoError(t, err, "nothing ran, so nothing failed")
+	assert.Contains(t, out, `"failing" [label="testResourceType [Test]\nfailing"];`)
+
+	out, err = run("apply")
+	var exit *exec.ExitError
+	if assert.True(t, errors.As(err, &exit)) {
+		assert.Equal(t, 1, exit.ExitCode())
+	}
+	assert.Contains(t, out, `"failing" [label="testResourceType [Test]\nfailing", style=filled, fillcolor="#f4a6a6"];`)
+
+	out, err = run("jsonl")
+	assert.Error(t, err)
+	assert.NotContains(t, out, "digraph", "STDOUT is the stream of events")
+	assert.Contains(t, out, `"type":"run-finished"`)
+	assert.Contains(t, stderr.String(), "digraph")
+}
@@ -0,0 +1,400 @@
+package viaduct
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Plan is the order a manifest would run in, worked out without running
+// anything.
+type Plan struct {
+	// Waves are the resources that can run at the same time, in the order
+	// they would run. A resource is in the first wave after everything it
+	// depends on, unless it shares a lock with a resource earlier in the same
+	// wave, in which case it waits for the next one.
+	Waves []PlanWave `json:"waves"`
+	// Dangling lists the dependencies on resources that aren't in the
+	// manifest.
+	Dangling []DanglingDependency `json:"dangling,omitempty"`
+}
+
+// PlanWave is a set of resources that can run at the same time.
+type PlanWave struct {
+	// Phase is which part of the run the wave is in: "prune" for what pruning
+	// removes, "run" for ordinary resources and "handlers" for handlers,
+	// which only run if they are notified.
+	Phase     string      `json:"phase"`
+	Resources []GraphNode `json:"resources"`
+}
+
+// DanglingDependency is a dependency on a resource that isn't in the
+// manifest, usually from a mistyped name given to SetDep.
+type DanglingDependency struct {
+	// ResourceID and ResourceKind are the resource that declared the
+	// dependency.
+	ResourceID   ResourceID   `json:"resource_id"`
+	ResourceKind ResourceKind `json:"resource_kind"`
+	Dependency   ResourceID   `json:"dependency"`
+	// Notify is set when the resource is a handler subscribing to the
+	// dependency, rather than depending on it.
+	Notify bool `json:"notify,omitempty"`
+	// Suggestion is the name given with SetName that the dependency is
+	// closest to, if any is close enough to be a likely typo.
+	Suggestion ResourceID `json:"suggestion,omitempty"`
+}
+
+func (d DanglingDependency) String() string {
+	verb := "depends on"
+	if d.Notify {
+		verb = "subscribes to"
+	}
+
+	msg := fmt.Sprintf("%s %s %s %s, which is not in the manifest", d.ResourceKind, d.ResourceID, verb, d.Dependency)
+	if d.Suggestion != "" {
+		msg += fmt.Sprintf(": did you mean %s?", d.Suggestion)
+	}
+
+	return msg
+}
+
+// DanglingError is returned when resources depend on resources that aren't in
+// the manifest.
+type DanglingError struct {
+	Dangling []DanglingDependency
+}
+
+func (e *DanglingError) Error() string {
+	msgs := make([]string, 0, len(e.Dangling))
+	for _, d := range e.Dangling {
+		msgs = append(msgs, d.String())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// AllowDanglingDependencies lets the manifest run with dependencies on
+// resources that aren't in it, which are then ignored. Otherwise a dangling
+// dependency stops the run before anything is applied.
+func (m *Manifest) AllowDanglingDependencies() {
+	m.allowDangling = true
+}
+
+// danglingDependencies lists the dependencies on resources that aren't in the
+// manifest, sorted by the resource that declared them.
+func (m *Manifest) danglingDependencies() []DanglingDependency {
+	ids := make([]ResourceID, 0, len(m.resources))
+	for id := range m.resources {
+		ids = append(ids, id)
+	}
+
+	var dangling []DanglingDependency
+
+	for _, id := range sortedIDs(ids) {
+		r := m.resources[id]
+
+		for _, dep := range r.DependsOn {
+			if _, ok := m.resources[dep]; !ok {
+				dangling = append(dangling, DanglingDependency{
+					ResourceID:   id,
+					ResourceKind: r.ResourceKind,
+					Dependency:   dep,
+					Suggestion:   m.suggestName(dep),
+				})
+			}
+		}
+
+		for _, source := range r.Subscribes {
+			if _, ok := m.resources[source]; !ok {
+				dangling = append(dangling, DanglingDependency{
+					ResourceID:   id,
+					ResourceKind: r.ResourceKind,
+					Dependency:   source,
+					Notify:       true,
+					Suggestion:   m.suggestName(source),
+				})
+			}
+		}
+	}
+
+	return dangling
+}
+
+// danglingCheck returns a DanglingError listing the dangling dependencies,
+// unless they have been allowed.
+func (m *Manifest) danglingCheck() error {
+	if m.allowDangling {
+		return nil
+	}
+
+	if dangling := m.danglingDependencies(); len(dangling) > 0 {
+		return &DanglingError{Dangling: dangling}
+	}
+
+	return nil
+}
+
+// suggestName returns the name given with SetName that a missing dependency
+// is closest to, if it is close enough to be a typo.
+func (m *Manifest) suggestName(dep ResourceID) ResourceID {
+	var best ResourceID
+
+	bestDistance := max(1, len([]rune(dep))/3) + 1
+
+	for _, name := range sortedIDs(slices.Collect(maps.Keys(m.names))) {
+		if _, ok := m.resources[name]; !ok {
+			continue
+		}
+
+		if d := editDistance(strings.ToLower(string(dep)), strings.ToLower(string(name))); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings: how many
+// characters have to be inserted, deleted or replaced to turn one into the
+// other.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+
+	prev := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		curr := make([]int, len(t)+1)
+		curr[0] = i
+
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev = curr
+	}
+
+	return prev[len(t)]
+}
+
+// Validate checks that the manifest could run, without running anything: that
+// there is no dependency cycle, that nothing but a handler depends on a
+// handler, and that every dependency is in the manifest, unless dangling
+// dependencies have been allowed. Apply runs the same checks before anything
+// else. A dangling dependency is returned as a *DanglingError, suggesting the
+// name it was probably meant to be.
+func (m *Manifest) Validate() error {
+	// A cycle can never make progress, so fail before any resource does work
+	// rather than waiting for the dependency timeout to notice.
+	if err := m.dependencyCycle(); err != nil {
+		return &checkError{"dependency-cycle", err}
+	}
+
+	if err := m.handlerCheck(); err != nil {
+		return &checkError{"handler-dependency", err}
+	}
+
+	if err := m.danglingCheck(); err != nil {
+		return &checkError{"dangling-dependency", err}
+	}
+
+	return nil
+}
+
+// Plan works out the order the manifest would run in, without running
+// anything. Resources left out with --only or --skip are left out of the plan
+// too, and so is what pruning would remove, since that depends on the state of
+// the last run. It returns an error for a manifest that could never run, such
+// as one with a dependency cycle, but lists dangling dependencies rather than
+// failing on them.
+func (m *Manifest) Plan() (*Plan, error) {
+	if err := m.dependencyCycle(); err != nil {
+		return nil, err
+	}
+
+	if err := m.handlerCheck(); err != nil {
+		return nil, err
+	}
+
+	deselected, err := m.deselect(m.selection())
+	if err != nil {
+		return nil, err
+	}
+
+	var pruners, resources, handlers []ResourceID
+
+	for id, r := range m.resources {
+		switch {
+		case deselected[id]:
+		case m.pruners[id]:
+			pruners = append(pruners, id)
+		case r.Handler():
+			handlers = append(handlers, id)
+		default:
+			resources = append(resources, id)
+		}
+	}
+
+	plan := &Plan{Waves: []PlanWave{}, Dangling: m.danglingDependencies()}
+
+	for _, phase := range []struct {
+		name string
+		ids  []ResourceID
+	}{
+		{"prune", pruners},
+		{"run", resources},
+		{"handlers", handlers},
+	} {
+		for _, wave := range m.waves(phase.ids) {
+			w := PlanWave{Phase: phase.name}
+			for _, id := range wave {
+				w.Resources = append(w.Resources, m.graphNode(id))
+			}
+
+			plan.Waves = append(plan.Waves, w)
+		}
+	}
+
+	return plan, nil
+}
+
+// waves splits a phase of the run into the sets of resources that can run at
+// the same time. It follows the scheduler: a resource is ready once everything
+// it depends on in the phase has finished, and is started in ID order, but a
+// resource that can't take its lock while another resource in the wave holds
+// one waits for the next wave.
+func (m *Manifest) waves(ids []ResourceID) [][]ResourceID {
+	phase := make(map[ResourceID]bool, len(ids))
+	for _, id := range ids {
+		phase[id] = true
+	}
+
+	waiting := make(map[ResourceID]int, len(ids))
+	dependents := make(map[ResourceID][]ResourceID, len(ids))
+
+	for _, id := range ids {
+		for _, dep := range m.resources[id].edges() {
+			if !phase[dep] {
+				continue
+			}
+
+			waiting[id]++
+			dependents[dep] = append(dependents[dep], id)
+		}
+	}
+
+	var ready []ResourceID
+	for _, id := range ids {
+		if waiting[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+
+	var waves [][]ResourceID
+
+	for len(ready) > 0 {
+		var wave, next, held []ResourceID
+
+		for _, id := range sortedIDs(ready) {
+			r := m.resources[id]
+
+			if r.GlobalLock && m.holdsConflictingLock(held, r.LockKey) {
+				next = append(next, id)
+				continue
+			}
+
+			if r.GlobalLock {
+				held = append(held, id)
+			}
+
+			wave = append(wave, id)
+		}
+
+		for _, id := range wave {
+			for _, dep := range dependents[id] {
+				waiting[dep]--
+
+				if waiting[dep] == 0 {
+					next = append(next, dep)
+				}
+			}
+		}
+
+		waves = append(waves, wave)
+		ready = next
+	}
+
+	return waves
+}
+
+// holdsConflictingLock reports whether any of the resources holding a lock
+// would stop a resource taking a lock with the key.
+func (m *Manifest) holdsConflictingLock(held []ResourceID, key string) bool {
+	for _, id := range held {
+		if locksConflict(m.resources[id].LockKey, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// printPlan writes the plan for --plan, as JSON or one wave after another.
+func printPlan(w io.Writer, plan *Plan, asJSON bool) error {
+	if asJSON {
+		out, err := json.MarshalIndent(plan, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w, string(out))
+
+		return err
+	}
+
+	for i, wave := range plan.Waves {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+
+		header := fmt.Sprintf("Wave %d", i+1)
+		if wave.Phase != "run" {
+			header += " (" + wave.Phase + ")"
+		}
+
+		fmt.Fprintln(w, header)
+
+		for _, n := range wave.Resources {
+			details := string(n.ID)
+			if n.Locked {
+				details += ", " + lockLabel(n.LockKey)
+			}
+
+			fmt.Fprintf(w, "    %s [%s] %s (%s)\n", n.Kind, n.Operation, n.Description, details)
+		}
+	}
+
+	if len(plan.Dangling) > 0 {
+		if len(plan.Waves) > 0 {
+			fmt.Fprintln(w)
+		}
+
+		fmt.Fprintln(w, "Dangling dependencies")
+
+		for _, d := range plan.Dangling {
+			fmt.Fprintf(w, "    %s\n", d)
+		}
+	}
+
+	return nil
+}
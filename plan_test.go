@@ -0,0 +1,239 @@
+package viaduct
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// planIDs returns the IDs in each wave of a plan
+func planIDs(plan *Plan) [][]ResourceID {
+	var waves [][]ResourceID
+
+	for _, w := range plan.Waves {
+		var ids []ResourceID
+		for _, n := range w.Resources {
+			ids = append(ids, n.ID)
+		}
+
+		waves = append(waves, ids)
+	}
+
+	return waves
+}
+
+// addNamed adds a resource to a manifest with a readable ID
+func addNamed(m *Manifest, name string, a ResourceAttributes, deps ...*Resource) *Resource {
+	r := m.Add(a, deps...)
+	m.SetName(r, name)
+
+	return r
+}
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+
+	t.Run("dependencies", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := addNamed(m, "a", newTestResource("a"))
+		b := addNamed(m, "b", newTestResource("b"), a)
+		addNamed(m, "c", newTestResource("c"), a, b)
+		addNamed(m, "d", newTestResource("d"))
+
+		plan, err := m.Plan()
+		assert.NoError(t, err)
+		assert.Equal(t, [][]ResourceID{{"a", "d"}, {"b"}, {"c"}}, planIDs(plan))
+		assert.Empty(t, plan.Dangling)
+	})
+
+	t.Run("locks", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		addNamed(m, "a-package", newTestResourceWithLockKey("a", PackageLock))
+		addNamed(m, "b-package", newTestResourceWithLockKey("b", PackageLock))
+		addNamed(m, "c-passwd", newTestResourceWithLockKey("c", PasswdLock))
+		addNamed(m, "d-other", newTestResourceWithLockKey("d", "other"))
+		addNamed(m, "e-global", newTestResourceWithLock("e"))
+		addNamed(m, "f-unlocked", newTestResource("f"))
+
+		plan, err := m.Plan()
+		assert.NoError(t, err)
+
+		// The package lock covers passwd too, and the keyless lock excludes
+		// every other lock holder
+		assert.Equal(t, [][]ResourceID{
+			{"a-package", "d-other", "f-unlocked"},
+			{"b-package"},
+			{"c-passwd"},
+			{"e-global"},
+		}, planIDs(plan))
+		assert.Equal(t, "run", plan.Waves[0].Phase)
+	})
+
+	t.Run("handlers run last", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		config := addNamed(m, "config", newTestResource("config"))
+		restart := addNamed(m, "restart", newTestResource("restart"))
+		m.Notify(config, restart)
+
+		plan, err := m.Plan()
+		assert.NoError(t, err)
+		assert.Equal(t, [][]ResourceID{{"config"}, {"restart"}}, planIDs(plan))
+		assert.Equal(t, "handlers", plan.Waves[1].Phase)
+	})
+
+	t.Run("leaves out what is not selected", func(t *testing.T) {
+		t.Parallel()
+
+		m := NewWithRuntime(&Runtime{Options: &Options{Skip: []string{"slow"}}, Attributes: &Attribute})
+		slow := addNamed(m, "slow", newTestResource("slow"))
+		m.Tag(slow, "slow")
+		addNamed(m, "after", newTestResource("after"), slow)
+
+		plan, err := m.Plan()
+		assert.NoError(t, err)
+		assert.Equal(t, [][]ResourceID{{"after"}}, planIDs(plan))
+	})
+
+	t.Run("dependency cycle", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := addNamed(m, "a", newTestResource("a"))
+		b := addNamed(m, "b", newTestResource("b"), a)
+		m.SetDep(a, string(b.ResourceID))
+
+		_, err := m.Plan()
+		assert.ErrorContains(t, err, "dependency cycle detected")
+	})
+
+	t.Run("dangling dependencies", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := addNamed(m, "a", newTestResource("a"))
+		m.SetDep(a, "missing")
+		restart := addNamed(m, "restart", newTestResource("restart"))
+		m.Subscribe(restart, &Resource{ResourceID: "gone"})
+
+		plan, err := m.Plan()
+		assert.NoError(t, err)
+		assert.Equal(t, []DanglingDependency{
+			{ResourceID: "a", ResourceKind: "testResourceType", Dependency: "missing"},
+			{ResourceID: "restart", ResourceKind: "testResourceType", Dependency: "gone", Notify: true},
+		}, plan.Dangling)
+	})
+}
+
+func TestDanglingDependencies(t *testing.T) {
+	t.Parallel()
+
+	t.Run("stop the run", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+		test := newTestResource("a")
+		a := addNamed(m, "a", test)
+		m.SetDep(a, "missing")
+
+		report, err := m.Apply(context.Background())
+		assert.Nil(t, report)
+		assert.EqualError(t, err, "testResourceType a depends on missing, which is not in the manifest")
+		assert.False(t, test.ran.Load())
+
+		var dangling *DanglingError
+		if assert.ErrorAs(t, err, &dangling) {
+			assert.Len(t, dangling.Dangling, 1)
+		}
+	})
+
+	t.Run("suggest a name", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		addNamed(m, "install-tools", newTestResource("tools"))
+		addNamed(m, "config", newTestResource("config"))
+		a := addNamed(m, "a", newTestResource("a"))
+		m.SetDep(a, "instal-tools")
+		b := addNamed(m, "b", newTestResource("b"))
+		m.SetDep(b, "something-else")
+
+		err := m.Validate()
+		assert.EqualError(t, err, "testResourceType a depends on instal-tools, which is not in the manifest: did you mean install-tools?; "+
+			"testResourceType b depends on something-else, which is not in the manifest")
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+		m.AllowDanglingDependencies()
+		test := newTestResource("a")
+		a := addNamed(m, "a", test)
+		m.SetDep(a, "missing")
+
+		_, err := m.Apply(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, test.ran.Load())
+	})
+}
+
+func TestPrintPlan(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	config := addNamed(m, "config", newTestResourceWithLockKey("config", PackageLock))
+	restart := addNamed(m, "restart", newTestResource("restart"))
+	m.Notify(config, restart)
+	m.SetDep(config, "missing")
+
+	plan, err := m.Plan()
+	assert.NoError(t, err)
+
+	var b strings.Builder
+	assert.NoError(t, printPlan(&b, plan, false))
+	assert.Equal(t, `Wave 1
+    testResourceType [Test] config (config, lock: package)
+
+Wave 2 (handlers)
+    testResourceType [Test] restart (restart)
+
+Dangling dependencies
+    testResourceType config depends on missing, which is not in the manifest
+`, b.String())
+
+	b.Reset()
+	assert.NoError(t, printPlan(&b, plan, true))
+	assert.Contains(t, b.String(), `"phase": "handlers"`)
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	a := addNamed(m, "a", newTestResource("a"))
+	b := addNamed(m, "b", newTestResource("b"), a)
+	assert.NoError(t, m.Validate())
+
+	m.SetDep(a, string(b.ResourceID))
+	assert.ErrorContains(t, m.Validate(), "cycle")
+}
+
+func TestEditDistance(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 0, editDistance("tools", "tools"))
+	assert.Equal(t, 1, editDistance("tools", "tool"))
+	assert.Equal(t, 1, editDistance("tools", "toels"))
+	assert.Equal(t, 2, editDistance("tools", "otols"))
+	assert.Equal(t, 5, editDistance("", "tools"))
+}
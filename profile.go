@@ -0,0 +1,170 @@
+package viaduct
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Timing is when a resource ran during a run, and how long it waited before it
+// could.
+type Timing struct {
+	// Started is when the resource started running, once it held its lock.
+	// It is zero for a resource that never ran.
+	Started time.Time
+	// Finished is when the resource finished, or was skipped or failed
+	// without running.
+	Finished time.Time
+	// DependencyWait is how long the resource waited, from the start of the
+	// run, for everything it depends on to finish. Handlers wait for every
+	// resource that isn't a handler.
+	DependencyWait time.Duration
+	// LockWait is how long the resource queued for its lock, behind other
+	// resources holding it.
+	LockWait time.Duration
+}
+
+// Duration is how long the resource ran for, or zero if it never ran.
+func (t Timing) Duration() time.Duration {
+	if t.Started.IsZero() {
+		return 0
+	}
+
+	return t.Finished.Sub(t.Started)
+}
+
+// MarshalJSON encodes the times as RFC 3339, leaving out when the resource
+// started if it never did, and the waits as text like the other durations in
+// the report.
+func (t Timing) MarshalJSON() ([]byte, error) {
+	var started *time.Time
+	if !t.Started.IsZero() {
+		started = &t.Started
+	}
+
+	return json.Marshal(struct {
+		Started        *time.Time `json:"started,omitempty"`
+		Finished       time.Time  `json:"finished"`
+		DependencyWait string     `json:"dependency_wait"`
+		LockWait       string     `json:"lock_wait"`
+	}{started, t.Finished, roundDuration(t.DependencyWait).String(), roundDuration(t.LockWait).String()})
+}
+
+// roundDuration rounds a duration to the millisecond, which is as precise as
+// anything in a report needs to be.
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
+
+// RunProfile is where the time in a run went, for --profile.
+type RunProfile struct {
+	// Slowest are the resources that took longest to run, slowest first.
+	Slowest []ProfileEntry `json:"slowest"`
+	// CriticalPath is the chain of resources that decided how long the run
+	// took, from first to last. It ends with the resource that finished
+	// last, and each resource before it is what the next one waited for
+	// longest: whichever of its dependencies finished last.
+	CriticalPath []ProfileEntry `json:"critical_path"`
+}
+
+// ProfileEntry is a resource in a RunProfile.
+type ProfileEntry struct {
+	ResourceID   ResourceID   `json:"resource_id"`
+	ResourceKind ResourceKind `json:"resource_kind"`
+	Operation    string       `json:"operation"`
+	Description  string       `json:"description"`
+	Timing       Timing       `json:"timing"`
+}
+
+// profile works out where the time in the run went, once it has finished,
+// listing the n slowest resources.
+func (m *Manifest) profile(n int) *RunProfile {
+	p := &RunProfile{Slowest: []ProfileEntry{}, CriticalPath: []ProfileEntry{}}
+
+	var ran []Resource
+	for _, id := range slices.Sorted(maps.Keys(m.resources)) {
+		if r := m.resources[id]; !r.timing.Started.IsZero() {
+			ran = append(ran, r)
+		}
+	}
+
+	if len(ran) == 0 {
+		return p
+	}
+
+	slowest := slices.Clone(ran)
+	slices.SortStableFunc(slowest, func(a, b Resource) int {
+		return cmp.Compare(b.timing.Duration(), a.timing.Duration())
+	})
+
+	for _, r := range slowest[:min(n, len(slowest))] {
+		p.Slowest = append(p.Slowest, profileEntry(r))
+	}
+
+	last := slices.MaxFunc(ran, func(a, b Resource) int {
+		return a.timing.Finished.Compare(b.timing.Finished)
+	})
+
+	for r, ok := last, true; ok; r, ok = m.waitedFor(r) {
+		p.CriticalPath = append(p.CriticalPath, profileEntry(r))
+	}
+
+	slices.Reverse(p.CriticalPath)
+
+	return p
+}
+
+// waitedFor returns whichever dependency of a resource finished last, which
+// is the one that held it up.
+func (m *Manifest) waitedFor(r Resource) (Resource, bool) {
+	var latest Resource
+	var found bool
+
+	for _, id := range sortedIDs(r.edges()) {
+		dep, ok := m.resources[id]
+		if !ok || dep.timing.Finished.IsZero() {
+			continue
+		}
+
+		if !found || dep.timing.Finished.After(latest.timing.Finished) {
+			latest, found = dep, true
+		}
+	}
+
+	return latest, found
+}
+
+func profileEntry(r Resource) ProfileEntry {
+	return ProfileEntry{
+		ResourceID:   r.ResourceID,
+		ResourceKind: r.ResourceKind,
+		Operation:    r.Attributes.OperationName(),
+		Description:  r.Attributes.Description(),
+		Timing:       r.timing,
+	}
+}
+
+// printProfile writes the profile for a person to read.
+func printProfile(w io.Writer, p *RunProfile) {
+	fmt.Fprintln(w, "Slowest resources")
+
+	for _, e := range p.Slowest {
+		fmt.Fprintf(w, "    %8s  %s [%s] %s (%s)\n", roundDuration(e.Timing.Duration()), e.ResourceKind, e.Operation, e.Description, e.ResourceID)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Critical path")
+
+	for _, e := range p.CriticalPath {
+		details := string(e.ResourceID)
+		if e.Timing.LockWait > 0 {
+			details += fmt.Sprintf(", %s waiting for its lock", roundDuration(e.Timing.LockWait))
+		}
+
+		fmt.Fprintf(w, "    %8s  %s [%s] %s (%s)\n", roundDuration(e.Timing.Duration()), e.ResourceKind, e.Operation, e.Description, details)
+	}
+}
@@ -0,0 +1,188 @@
+package viaduct
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setTiming gives a resource the timing of a run, as so many milliseconds
+// after the run started
+func setTiming(m *Manifest, r *Resource, started, finished int) {
+	at := func(ms int) time.Time {
+		return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	re := m.resources[r.ResourceID]
+	re.timing = Timing{Started: at(started), Finished: at(finished)}
+	m.resources[r.ResourceID] = re
+}
+
+func profileIDs(entries []ProfileEntry) []ResourceID {
+	ids := []ResourceID{}
+	for _, e := range entries {
+		ids = append(ids, e.ResourceID)
+	}
+
+	return ids
+}
+
+func TestProfile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("slowest and the critical path", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := addNamed(m, "a", newTestResource("a"))
+		b := addNamed(m, "b", newTestResource("b"))
+		c := addNamed(m, "c", newTestResource("c"), a, b)
+		d := addNamed(m, "d", newTestResource("d"))
+		skipped := addNamed(m, "skipped", newTestResource("skipped"))
+
+		setTiming(m, a, 0, 100)
+		setTiming(m, b, 0, 300)
+		setTiming(m, c, 300, 350)
+		setTiming(m, d, 0, 200)
+
+		re := m.resources[skipped.ResourceID]
+		re.timing.Finished = m.resources[d.ResourceID].timing.Finished
+		m.resources[skipped.ResourceID] = re
+
+		p := m.profile(2)
+		assert.Equal(t, []ResourceID{"b", "d"}, profileIDs(p.Slowest))
+		assert.Equal(t, []ResourceID{"b", "c"}, profileIDs(p.CriticalPath), "c waited longest for b")
+
+		assert.Len(t, m.profile(10).Slowest, 4, "what never ran is left out")
+	})
+
+	t.Run("nothing ran", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		addNamed(m, "a", newTestResource("a"))
+
+		p := m.profile(10)
+		assert.Empty(t, p.Slowest)
+		assert.Empty(t, p.CriticalPath)
+	})
+
+	t.Run("printed", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := addNamed(m, "a", newTestResource("a"))
+		b := addNamed(m, "b", newTestResource("b"), a)
+		setTiming(m, a, 0, 1500)
+		setTiming(m, b, 1500, 1750)
+
+		var out bytes.Buffer
+		printProfile(&out, m.profile(10))
+
+		assert.Equal(t, `Slowest resources
+        1.5s  testResourceType [Test] a (a)
+       250ms  testResourceType [Test] b (b)
+
+Critical path
+        1.5s  testResourceType [Test] a (a)
+       250ms  testResourceType [Test] b (b)
+`, out.String())
+	})
+
+	t.Run("in the report", func(t *testing.T) {
+		t.Parallel()
+
+		m := NewWithRuntime(&Runtime{Options: &Options{Profile: 5, Silent: true}, Attributes: &Attribute})
+		m.DisableState()
+		a := addNamed(m, "a", newTestResource("a"))
+		addNamed(m, "b", newTestResource("b"), a)
+
+		report, err := m.Apply(context.Background())
+		assert.NoError(t, err)
+
+		if assert.NotNil(t, report.Profile) {
+			assert.Equal(t, []ResourceID{"a", "b"}, profileIDs(report.Profile.CriticalPath))
+		}
+	})
+}
+
+func TestTiming(t *testing.T) {
+	t.Parallel()
+
+	t.Run("waits", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.DisableState()
+
+		first := newBlockingTestResource("first")
+		first.LockKey = "test"
+		second := newBlockingTestResource("second")
+		second.LockKey = "test"
+		dep := addNamed(m, "first", first)
+		addNamed(m, "second", second)
+		addNamed(m, "after", newTestResource("after"), dep)
+
+		time.AfterFunc(50*time.Millisecond, func() {
+			first.release()
+			second.release()
+		})
+
+		report, err := m.Apply(context.Background())
+		assert.NoError(t, err)
+
+		timings := map[string]Timing{}
+		for _, r := range report.Resources {
+			timings[r.ResourceID] = r.Timing
+			assert.False(t, r.Timing.Started.IsZero(), r.ResourceID)
+			assert.False(t, r.Timing.Finished.Before(r.Timing.Started), r.ResourceID)
+		}
+
+		assert.GreaterOrEqual(t, timings["after"].DependencyWait, 40*time.Millisecond)
+		assert.GreaterOrEqual(t, max(timings["first"].LockWait, timings["second"].LockWait), 40*time.Millisecond,
+			"whichever took the lock second waited for the other")
+		assert.False(t, report.Finished.Before(report.Started))
+	})
+
+	t.Run("waiting for room is not waiting for dependencies", func(t *testing.T) {
+		t.Parallel()
+
+		m := NewWithRuntime(&Runtime{Options: &Options{Silent: true}, Attributes: &Attribute})
+		m.DisableState()
+		m.SetConcurrency(1)
+
+		slow := newBlockingTestResource("a")
+		addNamed(m, "a", slow)
+		addNamed(m, "b", newTestResource("b"))
+
+		time.AfterFunc(50*time.Millisecond, slow.release)
+
+		report, err := m.Apply(context.Background())
+		assert.NoError(t, err)
+
+		for _, r := range report.Resources {
+			if r.ResourceID == "b" {
+				assert.GreaterOrEqual(t, r.Timing.Started.Sub(report.Started), 40*time.Millisecond, "b waited for a to finish")
+				assert.Less(t, r.Timing.DependencyWait, 40*time.Millisecond, "but not for a dependency")
+			}
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		t.Parallel()
+
+		at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+		out, err := json.Marshal(Timing{Started: at, Finished: at.Add(time.Second), DependencyWait: 1234567 * time.Microsecond})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"started":"2024-05-01T12:00:00Z","finished":"2024-05-01T12:00:01Z","dependency_wait":"1.235s","lock_wait":"0s"}`, string(out))
+
+		out, err = json.Marshal(Timing{Finished: at})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"finished":"2024-05-01T12:00:00Z","dependency_wait":"0s","lock_wait":"0s"}`, string(out), "what never ran did not start")
+	})
+}
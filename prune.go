@@ -0,0 +1,214 @@
+package viaduct
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// pruneEntry is a resource the last run applied that is no longer in the
+// manifest.
+type pruneEntry struct {
+	// state is what the last run recorded about it.
+	state ResourceState
+
+	// by is the resource that removes it. It is empty when it could not be
+	// added.
+	by ResourceID
+}
+
+// EnablePrune removes whatever the last run applied that is no longer in the
+// manifest, for the kinds of resource that support it: File, Directory, Link,
+// Apt, Sysctl, Git and Group in the resources package, and any custom resource
+// that implements Prunable.
+//
+// Each one is replaced by the resource that deletes it, and these run before
+// anything else. Something that is still managed under a different ID, such as
+// a resource renamed with SetName, is left alone. Pruning relies on the state
+// saved by the last run, so it does nothing on the first run, or if the state
+// has been disabled.
+func (m *Manifest) EnablePrune() {
+	m.prune = true
+}
+
+// addPrunes adds a resource to remove everything the last run applied that is
+// no longer in the manifest. One that cannot be removed is left in the state
+// to try again next time, and a warning is logged.
+func (m *Manifest) addPrunes(last State, l *Logger) {
+	// Anything still managed under a new ID is left for the resource that
+	// manages it now
+	managed := make(map[string]bool, len(m.resources))
+	for _, r := range m.resources {
+		managed[m.pruneKey(r.ResourceKind, r.Attributes)] = true
+	}
+
+	m.pruned = make(map[ResourceID]pruneEntry)
+	m.pruners = make(map[ResourceID]bool)
+
+	ids := make([]ResourceID, 0, len(last.Resources))
+	for id := range last.Resources {
+		ids = append(ids, id)
+	}
+
+	for _, id := range sortedIDs(ids) {
+		rs := last.Resources[id]
+
+		if _, ok := m.resources[id]; ok || rs.Pruned == nil || rs.Status != Success {
+			continue
+		}
+
+		entry := pruneEntry{state: rs}
+
+		attributes, err := prunedAttributes(rs)
+		if err == nil && managed[m.pruneKey(rs.ResourceKind, attributes)] {
+			continue
+		}
+
+		if err == nil {
+			var r *Resource
+			if r, err = m.addPrune(attributes); err == nil {
+				entry.by = r.ResourceID
+				m.pruners[r.ResourceID] = true
+			}
+		}
+
+		if err != nil {
+			l.Warn("prune-skipped", "resource_id", string(id), "error", err.Error())
+		}
+
+		m.pruned[id] = entry
+	}
+
+	m.orderPrunes()
+}
+
+// prunedAttributes rebuilds the resource that removes a resource from the
+// last run.
+func prunedAttributes(rs ResourceState) (ResourceAttributes, error) {
+	attributes, err := newKind(rs.ResourceKind)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(rs.Pruned, attributes); err != nil {
+		return nil, fmt.Errorf("could not read %s from state: %w", rs.ResourceKind, err)
+	}
+
+	return attributes, nil
+}
+
+// addPrune adds the resource that removes a resource from the last run.
+func (m *Manifest) addPrune(attributes ResourceAttributes) (*Resource, error) {
+	r, err := newResource(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.init(attributes); err != nil {
+		return nil, err
+	}
+
+	if err := m.addResource(r, attributes); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// orderPrunes makes removing a path wait for removing anything under it, so a
+// directory is already empty of whatever the last run put in it by the time it
+// is removed.
+func (m *Manifest) orderPrunes() {
+	ids := sortedIDs(slices.Collect(maps.Keys(m.pruners)))
+
+	paths := make(map[ResourceID][]string, len(ids))
+	for _, id := range ids {
+		paths[id] = m.claimedPaths(m.resources[id])
+	}
+
+	for _, id := range ids {
+		r := m.resources[id]
+
+		for _, other := range ids {
+			if other != id && containsPath(paths[id], paths[other]) {
+				r.DependsOn = append(r.DependsOn, other)
+			}
+		}
+
+		m.resources[id] = r
+	}
+}
+
+// claimedPaths returns the paths a resource claims, expanded.
+func (m *Manifest) claimedPaths(r Resource) []string {
+	c, ok := r.Attributes.(Claimer)
+	if !ok {
+		return nil
+	}
+
+	var paths []string
+	for _, claim := range c.Claims() {
+		if path, ok := strings.CutPrefix(m.claimObject(claim.Object), pathClaimPrefix); ok {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// containsPath reports whether any of the paths in inner is beneath one of the
+// paths in outer.
+func containsPath(outer, inner []string) bool {
+	for _, o := range outer {
+		for _, i := range inner {
+			if strings.HasPrefix(i, strings.TrimSuffix(o, "/")+"/") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// carryPruned keeps anything that was not removed in the state, so the next
+// run tries again rather than forgetting about it.
+func (m *Manifest) carryPruned(s State) {
+	for id, entry := range m.pruned {
+		if r, ok := m.resources[entry.by]; ok && r.Status == Success {
+			continue
+		}
+
+		s.Resources[id] = entry.state
+	}
+}
+
+// prunedJSON returns the resource that removes a resource again, if it
+// supports pruning.
+func prunedJSON(a ResourceAttributes) (json.RawMessage, error) {
+	p, ok := a.(Prunable)
+	if !ok {
+		return nil, nil
+	}
+
+	pruned := p.Pruned()
+	if pruned == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(pruned)
+}
+
+// pruneKey identifies the object a resource manages, so that one still
+// managed under a new ID is not pruned. It is the identity of the resource, or
+// its description if it has none, with "~" expanded so that a path from the
+// home directory matches the full path.
+func (m *Manifest) pruneKey(kind ResourceKind, a ResourceAttributes) string {
+	key := a.Description()
+	if i, ok := a.(Identifier); ok && i.Identity() != "" {
+		key = i.Identity()
+	}
+
+	return string(kind) + "\x00" + m.rt.ExpandPath(key)
+}
@@ -0,0 +1,259 @@
+package viaduct
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPrunableResourceType is a test resource that can be pruned
+type testPrunableResourceType struct {
+	testResourceType
+	Delete bool
+}
+
+func (t *testPrunableResourceType) Pruned() ResourceAttributes {
+	if t.Delete {
+		return nil
+	}
+
+	return &testPrunableResourceType{testResourceType: testResourceType{Value: t.Value}, Delete: true}
+}
+
+func init() {
+	RegisterKind("testPrunableResourceType", func() ResourceAttributes { return &testPrunableResourceType{} })
+	RegisterKind("testIdentityResourceType", func() ResourceAttributes { return &testIdentityResourceType{} })
+	RegisterKind("testClaimResourceType", func() ResourceAttributes { return &testClaimResourceType{} })
+}
+
+// prunableState returns the state a run of the resource would have saved
+func prunableState(t *testing.T, value string, status Status) ResourceState {
+	pruned, err := json.Marshal(&testPrunableResourceType{testResourceType: testResourceType{Value: value}, Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ResourceState{
+		ResourceKind: "testPrunableResourceType",
+		Description:  value,
+		Status:       status,
+		Pruned:       pruned,
+	}
+}
+
+func TestPrunedJSON(t *testing.T) {
+	t.Parallel()
+
+	t.Run("prunable", func(t *testing.T) {
+		t.Parallel()
+
+		out, err := prunedJSON(&testPrunableResourceType{testResourceType: testResourceType{Value: "a"}})
+		assert.NoError(t, err)
+		assert.Contains(t, string(out), `"Delete":true`)
+	})
+
+	t.Run("a removal has nothing to prune", func(t *testing.T) {
+		t.Parallel()
+
+		out, err := prunedJSON(&testPrunableResourceType{Delete: true})
+		assert.NoError(t, err)
+		assert.Nil(t, out)
+	})
+
+	t.Run("not prunable", func(t *testing.T) {
+		t.Parallel()
+
+		out, err := prunedJSON(newTestResource("a"))
+		assert.NoError(t, err)
+		assert.Nil(t, out)
+	})
+}
+
+func TestAddPrunes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("removes what is no longer in the manifest", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.Add(newTestResource("kept"))
+
+		m.addPrunes(State{Resources: map[ResourceID]ResourceState{
+			"old": prunableState(t, "removed", Success),
+		}}, NewSilentLogger())
+
+		if assert.Len(t, m.pruners, 1) {
+			for id := range m.pruners {
+				r := m.resources[id]
+				assert.Equal(t, ResourceKind("testPrunableResourceType"), r.ResourceKind)
+				assert.Equal(t, "removed", r.Attributes.Description())
+				assert.True(t, r.Attributes.(*testPrunableResourceType).Delete)
+			}
+		}
+	})
+
+	t.Run("leaves alone what is still managed under a new ID", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.Add(&testPrunableResourceType{testResourceType: testResourceType{Value: "a", WithLock: true}})
+
+		m.addPrunes(State{Resources: map[ResourceID]ResourceState{
+			"old": prunableState(t, "a", Success),
+		}}, NewSilentLogger())
+
+		assert.Empty(t, m.pruners)
+	})
+
+	t.Run("leaves alone a path still managed from the home directory", func(t *testing.T) {
+		t.Parallel()
+
+		m := NewWithRuntime(homeRuntime("/home/test"))
+		m.Add(&testIdentityResourceType{testResourceType: testResourceType{Value: "a"}, Path: "/home/test/a"})
+
+		rs := prunableState(t, "old", Success)
+		rs.ResourceKind = "testIdentityResourceType"
+		rs.Pruned = []byte(`{"Value":"b","Path":"~/a"}`)
+
+		m.addPrunes(State{Resources: map[ResourceID]ResourceState{"old": rs}}, NewSilentLogger())
+
+		assert.Empty(t, m.pruners)
+	})
+
+	t.Run("removes what is inside a path before the path", func(t *testing.T) {
+		t.Parallel()
+
+		claimed := func(value, path string) ResourceState {
+			rs := prunableState(t, value, Success)
+			rs.ResourceKind = "testClaimResourceType"
+			rs.Pruned = []byte(`{"Value":"` + value + `","Object":"path ` + path + `","State":"absent"}`)
+
+			return rs
+		}
+
+		m := NewWithRuntime(homeRuntime("/home/test"))
+		m.addPrunes(State{Resources: map[ResourceID]ResourceState{
+			"dir":   claimed("dir", "~/dir"),
+			"file":  claimed("file", "/home/test/dir/file"),
+			"other": claimed("other", "/home/test/directory"),
+		}}, NewSilentLogger())
+
+		deps := map[string][]string{}
+		for id := range m.pruners {
+			r := m.resources[id]
+			for _, dep := range r.DependsOn {
+				deps[r.Attributes.Description()] = append(deps[r.Attributes.Description()], m.resources[dep].Attributes.Description())
+			}
+		}
+
+		assert.Equal(t, map[string][]string{"dir": {"file"}}, deps)
+	})
+
+	t.Run("leaves alone what the last run did not apply", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.addPrunes(State{Resources: map[ResourceID]ResourceState{
+			"old": prunableState(t, "a", DependencyFailed),
+		}}, NewSilentLogger())
+
+		assert.Empty(t, m.pruners)
+	})
+
+	t.Run("an unknown kind is kept for next time", func(t *testing.T) {
+		t.Parallel()
+
+		rs := prunableState(t, "a", Success)
+		rs.ResourceKind = "Unregistered"
+
+		m := New()
+		m.addPrunes(State{Resources: map[ResourceID]ResourceState{"old": rs}}, NewSilentLogger())
+		assert.Empty(t, m.pruners)
+
+		s, err := newState(m.resources, rs.Timestamp)
+		assert.NoError(t, err)
+
+		m.carryPruned(s)
+		assert.Equal(t, rs, s.Resources["old"])
+	})
+}
+
+func TestPruneRun(t *testing.T) {
+	t.Run("pruning runs before everything else", func(t *testing.T) {
+		m := New()
+		current := newTestResource("current")
+		m.Add(current)
+
+		m.addPrunes(State{Resources: map[ResourceID]ResourceState{
+			"old": prunableState(t, "removed", Success),
+		}}, NewSilentLogger())
+
+		var pruner *testPrunableResourceType
+		for id := range m.pruners {
+			pruner = m.resources[id].Attributes.(*testPrunableResourceType)
+		}
+		pruner.block = make(chan struct{})
+
+		done := make(chan struct{})
+		go func() {
+			newScheduler(m, 0).run()
+			close(done)
+		}()
+
+		for !pruner.ran.Load() {
+			time.Sleep(time.Millisecond)
+		}
+
+		// Nothing else starts while the pruner is still going
+		time.Sleep(20 * time.Millisecond)
+		assert.False(t, current.ran.Load())
+
+		pruner.release()
+		<-done
+
+		assert.True(t, current.ran.Load())
+	})
+
+	t.Run("a failed removal is kept for next time", func(t *testing.T) {
+		m := New()
+
+		m.addPrunes(State{Resources: map[ResourceID]ResourceState{
+			"old": prunableState(t, "removed", Success),
+		}}, NewSilentLogger())
+
+		for id := range m.pruners {
+			m.resources[id].Attributes.(*testPrunableResourceType).err = assert.AnError
+		}
+
+		newScheduler(m, 0).run()
+
+		s, err := newState(m.resources, m.pruned["old"].state.Timestamp)
+		assert.NoError(t, err)
+
+		m.carryPruned(s)
+		assert.Contains(t, s.Resources, ResourceID("old"))
+	})
+
+	t.Run("a successful removal is forgotten", func(t *testing.T) {
+		m := New()
+
+		m.addPrunes(State{Resources: map[ResourceID]ResourceState{
+			"old": prunableState(t, "removed", Success),
+		}}, NewSilentLogger())
+
+		newScheduler(m, 0).run()
+
+		s, err := newState(m.resources, m.pruned["old"].state.Timestamp)
+		assert.NoError(t, err)
+
+		m.carryPruned(s)
+		assert.NotContains(t, s.Resources, ResourceID("old"))
+
+		// The removal is not prunable itself, so it drops out next time
+		for id := range m.pruners {
+			assert.Nil(t, s.Resources[id].Pruned)
+		}
+	})
+}
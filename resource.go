@@ -1,6 +1,7 @@
 package viaduct
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
@@ -14,7 +15,20 @@ import (
 // errAbandoned is returned when a resource is still running once its timeout
 // has passed. The operation carries on, so the run treats it as a reason to
 // stop rather than as an ordinary failure.
-var errAbandoned = errors.New("timed out")
+var errAbandoned = errors.New("abandoned")
+
+// errCancelled is returned when a resource that supports cancellation ran out
+// of time and stopped. Nothing is left running, so it is an ordinary failure.
+var errCancelled = errors.New("cancelled")
+
+// errRunCancelled is returned when the run itself was cancelled, through the
+// context given to Apply, while the resource was running. It wraps the cause,
+// so it is also context.Canceled or context.DeadlineExceeded.
+var errRunCancelled = errors.New("the run was cancelled")
+
+// cancelGracePeriod is how long a cancelled resource is given to stop before
+// the run gives up on it, as though it did not support cancellation at all.
+const cancelGracePeriod = 10 * time.Second
 
 // ResourceKind is the kind of resource, such as "File" or "Package".
 type ResourceKind string
@@ -31,6 +45,12 @@ type Resource struct {
 	Attributes ResourceAttributes
 	// DependsOn is a list of resource dependencies.
 	DependsOn []ResourceID `json:"DependsOn,omitempty"`
+	// Subscribes makes the resource a handler: it runs once at the end of
+	// the run, and only if one of these resources made a change.
+	Subscribes []ResourceID `json:"Subscribes,omitempty"`
+	// Tags label the resource, so a run can be narrowed down to the resources
+	// with a tag, or leave them out.
+	Tags []string `json:"Tags,omitempty"`
 	// GlobalLock will mean the resource will not run at the same time
 	// as other resources that have this set to true.
 	GlobalLock bool
@@ -41,8 +61,64 @@ type Resource struct {
 	// Timeout overrides how long this resource is given to run. Zero uses
 	// the manifest setting, and a negative duration means no timeout.
 	Timeout time.Duration `json:"Timeout,omitempty"`
+	// Attempts is how many times the resource is tried before it fails. Zero
+	// uses the default, which retries a Retryable resource a couple of times
+	// and tries anything else once.
+	Attempts int `json:"Attempts,omitempty"`
+	// RetryBackoff is how long to wait before the first retry, doubling for
+	// each one after. Zero uses the default of a second.
+	RetryBackoff time.Duration `json:"RetryBackoff,omitempty"`
+	// IgnoreFailure reports a failure of the resource as a warning, without
+	// failing the run or anything that depends on it.
+	IgnoreFailure bool `json:"IgnoreFailure,omitempty"`
 	// Error contains any errors raised during a run.
 	Error `json:"Error"`
+	// Outcome records what the resource did during the run, once it has
+	// finished.
+	Outcome Outcome `json:"Outcome,omitempty"`
+
+	// timing is when the resource ran, and finished once it reached its
+	// outcome.
+	timing Timing
+
+	// guards decide whether the resource runs, just before it would.
+	guards []guard
+}
+
+// UnmarshalJSON decodes a resource, rebuilding its attributes as the
+// registered kind named by its ResourceKind.
+func (r *Resource) UnmarshalJSON(data []byte) error {
+	type plain Resource
+
+	var decoded struct {
+		plain
+		Attributes json.RawMessage
+	}
+
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*r = Resource(decoded.plain)
+
+	if len(decoded.Attributes) > 0 && string(decoded.Attributes) != "null" {
+		a, err := newKind(r.ResourceKind)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(decoded.Attributes, a); err != nil {
+			return fmt.Errorf("%s %s: %w", r.ResourceKind, r.ResourceID, err)
+		}
+
+		r.Attributes = a
+	}
+
+	if r.Message != "" {
+		r.Err = errors.New(r.Message)
+	}
+
+	return nil
 }
 
 type Error struct {
@@ -62,6 +138,11 @@ type ResourceParams struct {
 	// same key, rather than for every lock holder in the run. Setting a key
 	// implies a lock.
 	LockKey string
+
+	// Retryable marks a resource whose failures are often transient, such as
+	// one that goes over the network. It is retried a couple of times before
+	// it fails, unless WithRetry or --retries say otherwise.
+	Retryable bool
 }
 
 // NewResourceParams creates a new ResourceParams.
@@ -107,6 +188,46 @@ type ResourceAttributes interface {
 	Run(log *Logger) error
 }
 
+// Identifier can be implemented by resources that manage one nameable thing,
+// such as the path of a file or the name of a service. The ID of such a
+// resource is made from its kind, operation and identity, like
+// "File.Create:/etc/hosts", rather than from a hash of its attributes. It stays
+// the same when the attributes are edited, so it can be depended on and found
+// in logs and state without SetName, and two resources that manage the same
+// thing the same way cannot both be added to a manifest.
+type Identifier interface {
+	// Identity names what the resource manages. An empty identity gives the
+	// resource a hashed ID, as though it did not implement Identifier.
+	Identity() string
+}
+
+// ContextRunner can be implemented by resources that are able to stop part way
+// through, such as by killing a child process or aborting a transfer. When a
+// resource implements it, RunContext is used instead of Run, and the context is
+// cancelled once the resource has used up its timeout.
+//
+// A resource that only implements Run cannot be stopped, so a timeout abandons
+// it and nothing else in the run is started.
+type ContextRunner interface {
+	// RunContext performs the resource operation, returning early once ctx
+	// is done.
+	RunContext(ctx context.Context, log *Logger) error
+}
+
+// Prunable can be implemented by resources that leave something behind that
+// can be removed again, such as a file or a group. When pruning is enabled, a
+// resource that was applied by the last run but is no longer in the manifest is
+// replaced by the resource Pruned returns.
+//
+// The kind has to be registered with RegisterKind, so that it can be rebuilt
+// from the saved state.
+type Prunable interface {
+	// Pruned returns a resource of the same kind that removes whatever this
+	// one manages, or nil if there is nothing to remove, such as when the
+	// resource is a removal itself.
+	Pruned() ResourceAttributes
+}
+
 // ResourceID is an id of a resource.
 type ResourceID string
 
@@ -151,39 +272,130 @@ func (r *Resource) setID() error {
 		return fmt.Errorf("resource kind has not been set")
 	}
 
-	j, err := json.Marshal(r)
+	if id := identityID(r.ResourceKind, r.Attributes); id != "" {
+		r.ResourceID = id
+		return nil
+	}
+
+	sha, err := hashJSON(r)
 	if err != nil {
 		return err
 	}
 
-	h := sha1.New()
-	h.Write(j)
-	sha := hex.EncodeToString(h.Sum(nil))
-
 	idstr := strings.Join([]string{"id", sha[0:8]}, "-")
 	r.ResourceID = ResourceID(strings.Join([]string{string(r.ResourceKind), idstr}, "_"))
 	return nil
 }
 
-func (r *Resource) preflight() error {
-	log := NewLogger(string(r.ResourceKind), "Preflight")
+// identityID returns the ID of a resource that implements Identifier, or an
+// empty ID if it has no identity.
+func identityID(kind ResourceKind, a ResourceAttributes) ResourceID {
+	i, ok := a.(Identifier)
+	if !ok {
+		return ""
+	}
+
+	identity := i.Identity()
+	if identity == "" {
+		return ""
+	}
+
+	return ResourceID(fmt.Sprintf("%s.%s:%s", kind, a.OperationName(), identity))
+}
+
+// hashJSON hashes the JSON encoding of v, which is how resources are told
+// apart: the same attributes hash the same way from one run to the next.
+func hashJSON(v any) (string, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.New()
+	h.Write(j)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (r *Resource) preflight(rt *Runtime) error {
+	log := rt.NewLogger(string(r.ResourceKind), "Preflight")
 	return r.Attributes.PreflightChecks(log)
 }
 
 // run performs the resource operation, giving up on it if it takes longer than
 // the timeout. A timeout of zero or less lets it run for as long as it takes.
 //
-// A resource operation cannot be cancelled, since Run takes no context, so an
-// abandoned resource carries on in the background: giving up means the run
-// stops waiting for it and reports it as failed, not that whatever it was
-// doing has stopped.
-func (r *Resource) run(timeout time.Duration) (*Logger, error) {
-	log := NewLogger(string(r.ResourceKind), r.Attributes.OperationName())
+// A resource that implements ContextRunner is cancelled when the timeout
+// passes, and the run waits for it to stop. One that only implements Run
+// cannot be cancelled, so giving up on it means the run stops waiting for it
+// and reports it as failed, not that whatever it was doing has stopped.
+func (r *Resource) run(parent context.Context, log *Logger, timeout time.Duration) error {
+	runner, cancellable := r.Attributes.(ContextRunner)
 
 	if timeout <= 0 {
-		return log, r.Attributes.Run(log)
+		if !cancellable {
+			return r.Attributes.Run(log)
+		}
+
+		if err := runner.RunContext(parent, log); err != nil {
+			if parent.Err() != nil {
+				return runCancelledError(parent)
+			}
+
+			return err
+		}
+
+		return nil
+	}
+
+	if !cancellable {
+		return r.runAbandonable(parent, log, timeout)
 	}
 
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.RunContext(ctx, log)
+	}()
+
+	select {
+	case err := <-done:
+		// Whatever error a cancelled operation returns, such as a killed
+		// process, the reason it stopped is the timeout or the run being
+		// cancelled
+		if err != nil && ctx.Err() != nil {
+			if parent.Err() != nil {
+				return runCancelledError(parent)
+			}
+
+			return cancelledError(timeout)
+		}
+
+		return err
+	case <-ctx.Done():
+	}
+
+	select {
+	case <-done:
+		if parent.Err() != nil {
+			return runCancelledError(parent)
+		}
+
+		return cancelledError(timeout)
+	case <-time.After(cancelGracePeriod):
+		if parent.Err() != nil {
+			return runAbandonedError(parent)
+		}
+
+		return abandonedError(timeout)
+	}
+}
+
+// runAbandonable runs a resource that cannot be cancelled, abandoning it once
+// the timeout has passed or the run is cancelled.
+func (r *Resource) runAbandonable(parent context.Context, log *Logger, timeout time.Duration) error {
 	done := make(chan error, 1)
 	go func() {
 		done <- r.Attributes.Run(log)
@@ -191,16 +403,56 @@ func (r *Resource) run(timeout time.Duration) (*Logger, error) {
 
 	select {
 	case err := <-done:
-		return log, err
+		return err
+	case <-parent.Done():
+		return runAbandonedError(parent)
 	case <-time.After(timeout):
-		return log, fmt.Errorf(
-			"%w after %s: the operation was abandoned and may still be running. Raise the limit with WithTimeout, SetResourceTimeout or --resource-timeout",
-			errAbandoned,
-			timeout,
-		)
+		return abandonedError(timeout)
 	}
 }
 
+func cancelledError(timeout time.Duration) error {
+	return fmt.Errorf(
+		"timed out after %s and was %w. Raise the limit with WithTimeout, SetResourceTimeout or --resource-timeout",
+		timeout,
+		errCancelled,
+	)
+}
+
+func abandonedError(timeout time.Duration) error {
+	return fmt.Errorf(
+		"timed out after %s and was %w, so it may still be running. Raise the limit with WithTimeout, SetResourceTimeout or --resource-timeout",
+		timeout,
+		errAbandoned,
+	)
+}
+
+// runCancelledError is for a resource that stopped because the run was
+// cancelled, rather than because it ran out of time.
+func runCancelledError(parent context.Context) error {
+	return fmt.Errorf("%w, so the operation was stopped: %w", errRunCancelled, context.Cause(parent))
+}
+
+// runAbandonedError is for a resource that did not stop when the run was
+// cancelled.
+func runAbandonedError(parent context.Context) error {
+	return fmt.Errorf("%w and the operation was %w, so it may still be running: %w", errRunCancelled, errAbandoned, context.Cause(parent))
+}
+
+// Failed reports whether the resource failed the run, either itself or
+// because something it depends on did. A resource that ignores its failures
+// never does.
 func (r *Resource) Failed() bool {
-	return r.Status == Failed || r.Status == DependencyFailed
+	return (r.Status == Failed && !r.IgnoreFailure) || r.Status == DependencyFailed
+}
+
+// edges returns every resource this one waits for: its dependencies, and the
+// resources it subscribes to as a handler.
+func (r Resource) edges() []ResourceID {
+	return append(append([]ResourceID(nil), r.DependsOn...), r.Subscribes...)
+}
+
+// Handler reports whether the resource only runs when notified.
+func (r Resource) Handler() bool {
+	return len(r.Subscribes) > 0
 }
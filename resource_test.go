@@ -1,9 +1,12 @@
 package viaduct
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -18,8 +21,16 @@ type testResourceType struct {
 	block     chan struct{}
 	blockOnce sync.Once
 
-	// ran records whether the operation was started at all
-	ran atomic.Bool
+	// ran records whether the operation was started at all, and runs how
+	// many times
+	ran  atomic.Bool
+	runs atomic.Int32
+
+	// err is returned from Run, standing in for a resource that fails
+	err error
+
+	// changes makes Run report that it made a change
+	changes bool
 }
 
 func (t *testResourceType) Description() string {
@@ -48,12 +59,17 @@ func (t *testResourceType) PreflightChecks(log *Logger) error {
 
 func (t *testResourceType) Run(log *Logger) error {
 	t.ran.Store(true)
+	t.runs.Add(1)
 
 	if t.block != nil {
 		<-t.block
 	}
 
-	return nil
+	if t.changes {
+		log.Info("changed")
+	}
+
+	return t.err
 }
 
 // release lets a blocking test resource finish
@@ -73,12 +89,56 @@ func newTestResourceWithLockKey(value, key string) *testResourceType {
 	return &testResourceType{Value: value, LockKey: key}
 }
 
+// newChangingTestResource returns a resource whose Run reports a change
+func newChangingTestResource(value string) *testResourceType {
+	return &testResourceType{Value: value, changes: true}
+}
+
+// newFailingTestResource returns a resource whose Run returns an error
+func newFailingTestResource(value string) *testResourceType {
+	return &testResourceType{Value: value, err: errors.New("failed")}
+}
+
 // newBlockingTestResource returns a resource whose Run does not finish until
 // release is called
 func newBlockingTestResource(value string) *testResourceType {
 	return &testResourceType{Value: value, block: make(chan struct{})}
 }
 
+// testContextResourceType is a test resource that supports cancellation, so it
+// stops blocking as soon as its context is done
+type testContextResourceType struct {
+	testResourceType
+}
+
+func (t *testContextResourceType) RunContext(ctx context.Context, log *Logger) error {
+	t.ran.Store(true)
+
+	select {
+	case <-t.block:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newCancellableTestResource returns a resource that blocks until it is
+// released or cancelled
+func newCancellableTestResource(value string) *testContextResourceType {
+	return &testContextResourceType{testResourceType{Value: value, block: make(chan struct{})}}
+}
+
+// testIdentityResourceType is a test resource with an identity, standing in
+// for a resource that manages a path
+type testIdentityResourceType struct {
+	testResourceType
+	Path string
+}
+
+func (t *testIdentityResourceType) Identity() string {
+	return t.Path
+}
+
 var testResource = newTestResource("test")
 
 func TestSetKind(t *testing.T) {
@@ -103,6 +163,29 @@ func TestSetID(t *testing.T) {
 	assert.NoError(t, err)
 
 	assert.Contains(t, string(r.ResourceID), "testResourceType")
+
+	t.Run("from the identity", func(t *testing.T) {
+		t.Parallel()
+
+		r := Resource{ResourceKind: "testIdentityResourceType", Attributes: &testIdentityResourceType{
+			testResourceType: testResourceType{Value: "content"},
+			Path:             "/etc/motd",
+		}}
+		assert.NoError(t, r.setID())
+		assert.Equal(t, ResourceID("testIdentityResourceType.Test:/etc/motd"), r.ResourceID)
+
+		r.Attributes.(*testIdentityResourceType).Value = "other content"
+		assert.NoError(t, r.setID())
+		assert.Equal(t, ResourceID("testIdentityResourceType.Test:/etc/motd"), r.ResourceID, "editing the attributes keeps the ID")
+	})
+
+	t.Run("hashed without an identity", func(t *testing.T) {
+		t.Parallel()
+
+		r := Resource{ResourceKind: "testIdentityResourceType", Attributes: &testIdentityResourceType{}}
+		assert.NoError(t, r.setID())
+		assert.Contains(t, string(r.ResourceID), "testIdentityResourceType_id-")
+	})
 }
 
 func TestNewResource(t *testing.T) {
@@ -115,3 +198,79 @@ func TestNewResource(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	t.Run("prefers RunContext when it is implemented", func(t *testing.T) {
+		t.Parallel()
+
+		a := newCancellableTestResource("a")
+		a.release()
+
+		r := Resource{ResourceKind: "testContextResourceType", Attributes: a}
+
+		err := r.run(context.Background(), NewSilentLogger(), time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, a.ran.Load())
+	})
+
+	t.Run("cancels a resource that overruns", func(t *testing.T) {
+		t.Parallel()
+
+		a := newCancellableTestResource("a")
+		r := Resource{ResourceKind: "testContextResourceType", Attributes: a}
+
+		err := r.run(context.Background(), NewSilentLogger(), 20*time.Millisecond)
+		assert.ErrorIs(t, err, errCancelled)
+		assert.NotErrorIs(t, err, errAbandoned)
+		assert.Contains(t, err.Error(), "was cancelled")
+	})
+
+	t.Run("stopped by the run being cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		for _, timeout := range []time.Duration{0, time.Minute} {
+			a := newCancellableTestResource("a")
+			r := Resource{ResourceKind: "testContextResourceType", Attributes: a}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+
+			err := r.run(ctx, NewSilentLogger(), timeout)
+			assert.ErrorIs(t, err, errRunCancelled)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.NotErrorIs(t, err, errCancelled, "it did not time out")
+			assert.NotContains(t, err.Error(), "timed out")
+		}
+	})
+
+	t.Run("abandoned when the run is cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		a := newBlockingTestResource("a")
+		defer a.release()
+
+		r := Resource{ResourceKind: "testResourceType", Attributes: a}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		err := r.run(ctx, NewSilentLogger(), time.Minute)
+		assert.ErrorIs(t, err, errRunCancelled)
+		assert.ErrorIs(t, err, errAbandoned)
+	})
+
+	t.Run("abandons a resource that cannot be cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		a := newBlockingTestResource("a")
+		defer a.release()
+
+		r := Resource{ResourceKind: "testResourceType", Attributes: a}
+
+		err := r.run(context.Background(), NewSilentLogger(), 20*time.Millisecond)
+		assert.ErrorIs(t, err, errAbandoned)
+		assert.NotEqual(t, errAbandoned.Error(), errCancelled.Error())
+	})
+}
@@ -2,11 +2,13 @@ package resources
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"maps"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/surminus/viaduct"
@@ -60,6 +62,10 @@ type Apt struct {
 	// altpath is the path of the alternative format, so we can ensure it
 	// gets removed
 	altpath string
+	// updatePending is set once the file has changed, until the update after
+	// it succeeds, so a retry that finds the file already written still
+	// updates
+	updatePending bool
 }
 
 func (a *Apt) Description() string {
@@ -69,16 +75,42 @@ func (a *Apt) Description() string {
 	return a.Name
 }
 
+// Identity is the name of the repository. An update on its own manages no
+// repository, so it has no identity.
+func (a *Apt) Identity() string {
+	if a.UpdateOnly {
+		return ""
+	}
+
+	return a.Name
+}
+
+// Claims claims the repository by name. An update on its own claims nothing.
+func (a *Apt) Claims() []viaduct.Claim {
+	switch {
+	case a.UpdateOnly:
+		return nil
+	case a.Delete:
+		return []viaduct.Claim{{Object: "apt repository " + a.Name, State: stateAbsent}}
+	default:
+		return []viaduct.Claim{{Object: "apt repository " + a.Name}}
+	}
+}
+
 // Params allows the resource to dynamically set options that will be passed
 // at compile time
 func (a *Apt) Params() *viaduct.ResourceParams {
 	// Only the update takes the package lock; writing a sources file
 	// contends with nothing
+	params := viaduct.NewResourceParams()
 	if a.Update || a.UpdateOnly {
-		return viaduct.NewResourceParamsWithLockKey(viaduct.PackageLock)
+		params = viaduct.NewResourceParamsWithLockKey(viaduct.PackageLock)
 	}
 
-	return viaduct.NewResourceParams()
+	// Updates and signing keys come over the network
+	params.Retryable = !a.Delete
+
+	return params
 }
 
 // PreflightChecks sets default values for the parameters for a particular
@@ -97,13 +129,13 @@ func (a *Apt) PreflightChecks(log *viaduct.Logger) error {
 		return fmt.Errorf("required parameter: URI")
 	}
 
-	if !viaduct.IsRoot() {
+	if !log.Runtime().IsRoot() {
 		return fmt.Errorf("apt resource must be run as root")
 	}
 
 	// Set optional defaults here
 	if a.Distribution == "" {
-		a.Distribution = viaduct.Attribute.Platform.UbuntuCodename
+		a.Distribution = log.Runtime().Attributes.Platform.UbuntuCodename
 	}
 
 	if a.Source == "" {
@@ -159,9 +191,13 @@ func AptHold(names ...string) *Execute {
 	return aptExec(append([]string{"apt-mark", "hold"}, names...)...)
 }
 
-// InstallDeb installs a deb package from a file using dpkg
+// InstallDeb installs a deb package from a file using dpkg. A path starting
+// with "~" is expanded once the run starts
 func InstallDeb(path string) *Execute {
-	return aptExec("dpkg", "-i", viaduct.ExpandPath(path))
+	e := aptExec("dpkg", "-i", path)
+	e.ExpandPaths = true
+
+	return e
 }
 
 // aptExec builds a command that runs without a shell, so package names and
@@ -184,106 +220,129 @@ func (a *Apt) OperationName() string {
 	return "Create"
 }
 
+// Pruned returns the resource that removes the repository once it is taken out
+// of the manifest
+func (a *Apt) Pruned() viaduct.ResourceAttributes {
+	if a.Delete || a.UpdateOnly {
+		return nil
+	}
+
+	return &Apt{Name: a.Name, URI: a.URI, Format: a.Format, Update: a.Update, Delete: true}
+}
+
 func (a *Apt) Run(log *viaduct.Logger) error {
+	return a.RunContext(context.Background(), log)
+}
+
+// RunContext manages the repository, killing apt-get and any key fetch once ctx
+// is done
+func (a *Apt) RunContext(ctx context.Context, log *viaduct.Logger) error {
 	if a.UpdateOnly {
-		return a.updateApt(log)
+		return a.updateApt(ctx, log)
 	}
 
 	if a.Delete {
-		return a.deleteApt(log)
+		return a.deleteApt(ctx, log)
 	} else {
-		return a.createApt(log)
+		return a.createApt(ctx, log)
+	}
+}
+
+// update runs the update that follows a change to the repository, which stays
+// pending until it succeeds.
+func (a *Apt) update(ctx context.Context, log *viaduct.Logger) error {
+	a.updatePending = true
+
+	if err := a.updateApt(ctx, log); err != nil {
+		return err
 	}
+
+	a.updatePending = false
+
+	return nil
 }
 
 // AptUpdate is a helper function to perform "apt-get update"
 // Should be converted to a proper resource
-func (a *Apt) updateApt(log *viaduct.Logger) error {
-	if viaduct.Cli.DryRun {
+func (a *Apt) updateApt(ctx context.Context, log *viaduct.Logger) error {
+	if log.DryRun() {
 		log.Info("updating")
 		return nil
 	}
 
-	if !viaduct.IsRoot() {
+	if !log.Runtime().IsRoot() {
 		return fmt.Errorf("must be run as root")
 	}
 
 	log.Info("updating")
 
-	cmd := exec.Command("apt-get", "update", "-y")
-	cmd.Stderr = aptStderr()
-
-	if err := cmd.Run(); err != nil {
-		return err
-	}
-
-	return nil
-}
+	cmd := commandContext(ctx, "apt-get", "update", "-y")
 
-func aptStderr() *os.File {
-	if viaduct.Cli.JSON {
-		return nil
-	}
-	return os.Stderr
+	return log.Capture(cmd, false).Done(cmd.Run())
 }
 
 // Create adds a new apt repository
-func (a *Apt) createApt(log *viaduct.Logger) error {
-	if viaduct.Cli.DryRun {
-		log.Info("created", "name", a.Name)
-		return nil
-	}
-
+func (a *Apt) createApt(ctx context.Context, log *viaduct.Logger) error {
 	var content string
 	var err error
 
 	if a.Format == List {
-		content, err = a.listContent(log)
+		content, err = a.listContent(ctx, log)
 	} else {
-		content, err = a.sourceContent(log)
+		content, err = a.sourceContent(ctx, log)
 	}
 	if err != nil {
 		return err
 	}
 
+	var existing string
 	if viaduct.FileExists(a.path) {
 		if con, err := os.ReadFile(a.path); err == nil {
 			if string(con) == content {
+				if a.updatePending {
+					return a.update(ctx, log)
+				}
+
 				log.Noop("up-to-date", "name", a.Name)
 				return nil
 			}
+
+			existing = string(con)
 		} else {
 			return err
 		}
 	}
 
-	// Remove the other type so we don't have repeats
-	if viaduct.FileExists(a.altpath) {
-		if err := os.Remove(a.altpath); err != nil {
-			return err
+	if !log.DryRun() {
+		// Remove the other type so we don't have repeats
+		if viaduct.FileExists(a.altpath) {
+			if err := os.Remove(a.altpath); err != nil {
+				return err
+			}
 		}
-	}
 
-	if err := os.WriteFile(a.path, []byte(content), 0o644); err != nil {
-		return err
+		if err := os.WriteFile(a.path, []byte(content), 0o644); err != nil {
+			return err
+		}
 	}
 
 	log.Info("created", "name", a.Name)
+	log.Diff(a.path, existing, content)
 
 	if a.Update {
-		return a.updateApt(log)
+		return a.update(ctx, log)
 	}
 
 	return nil
 }
 
-func (a *Apt) listContent(log *viaduct.Logger) (string, error) {
+func (a *Apt) listContent(ctx context.Context, log *viaduct.Logger) (string, error) {
 	content := []string{
 		"deb",
 	}
 
 	if a.SigningKey != "" || a.SigningKeyURL != "" {
-		if err := a.receiveSigningKey(log); err == nil {
+		if err := a.receiveSigningKey(ctx, log); err == nil {
 			if a.Parameters == nil {
 				a.Parameters = make(map[string]string)
 			}
@@ -297,8 +356,10 @@ func (a *Apt) listContent(log *viaduct.Logger) (string, error) {
 	if len(a.Parameters) > 0 {
 		var params []string
 
-		for k, v := range a.Parameters {
-			params = append(params, fmt.Sprintf("%s=%s", k, v))
+		// Map order changes from run to run, and the file has to come out
+		// the same each time to be left alone
+		for _, k := range slices.Sorted(maps.Keys(a.Parameters)) {
+			params = append(params, fmt.Sprintf("%s=%s", k, a.Parameters[k]))
 		}
 
 		content = append(content, fmt.Sprintf("[%s]", strings.Join(params, " ")))
@@ -314,13 +375,13 @@ func (a *Apt) listContent(log *viaduct.Logger) (string, error) {
 	return strings.Join(content, " "), nil
 }
 
-func (a *Apt) sourceContent(log *viaduct.Logger) (string, error) {
+func (a *Apt) sourceContent(ctx context.Context, log *viaduct.Logger) (string, error) {
 	content := []string{
 		"Types: deb",
 	}
 
 	if a.SigningKey != "" || a.SigningKeyURL != "" {
-		if err := a.receiveSigningKey(log); err == nil {
+		if err := a.receiveSigningKey(ctx, log); err == nil {
 			if a.Parameters == nil {
 				a.Parameters = make(map[string]string)
 			}
@@ -336,7 +397,8 @@ func (a *Apt) sourceContent(log *viaduct.Logger) (string, error) {
 	}
 
 	if len(a.Parameters) > 0 {
-		for k, v := range a.Parameters {
+		for _, k := range slices.Sorted(maps.Keys(a.Parameters)) {
+			v := a.Parameters[k]
 			if k == "arch" {
 				content = append(content, fmt.Sprintf("Architectures: %s", v))
 				continue
@@ -359,45 +421,50 @@ func (a *Apt) sourceContent(log *viaduct.Logger) (string, error) {
 // receiveSigningKey will fetch a signing key. The commands run without a
 // shell, so a URL or key ID containing shell metacharacters is passed through
 // as a literal argument rather than being interpreted.
-func (a *Apt) receiveSigningKey(log *viaduct.Logger) error {
+func (a *Apt) receiveSigningKey(ctx context.Context, log *viaduct.Logger) error {
 	if viaduct.FileExists(a.signingKeyPath()) {
 		log.Noop("signing-key-exists", "path", a.signingKeyPath())
 		return nil
 	}
 
+	if log.DryRun() {
+		log.Info("signing-key-fetched", "path", a.signingKeyPath())
+		return nil
+	}
+
 	if a.SigningKeyURL != "" {
 		// -f so an HTTP error is an error: without it curl exits 0 and the 404
 		// body goes through gpg --dearmor, which passes non-armoured input
 		// straight through, and the error page is installed as the keyring. The
 		// existence check above then treats it as valid on every later run
-		// nolint:gosec
-		cmd := exec.Command("curl", "-sSfL", a.SigningKeyURL)
-		cmd.Stderr = aptStderr()
+		var key bytes.Buffer
+
+		cmd := commandContext(ctx, "curl", "-sSfL", a.SigningKeyURL)
+		cmd.Stdout = &key
 
-		key, err := cmd.Output()
-		if err != nil {
+		if err := log.Capture(cmd, false).Done(cmd.Run()); err != nil {
 			return fmt.Errorf("could not fetch signing key from %s: %w", a.SigningKeyURL, err)
 		}
 
-		if err := writeCommandOutput(a.signingKeyPath(), bytes.NewReader(key), "gpg", "--dearmor"); err != nil {
+		if err := writeCommandOutput(ctx, log, a.signingKeyPath(), &key, "gpg", "--dearmor"); err != nil {
 			return err
 		}
 	}
 
 	if a.SigningKey != "" {
 		// First we fetch the key using GPG
-		if err := runCommand("gpg", "--recv-keys", "--keyserver", "keyserver.ubuntu.com", a.SigningKey); err != nil {
+		if err := runCommandContext(ctx, log, "gpg", "--recv-keys", "--keyserver", "keyserver.ubuntu.com", a.SigningKey); err != nil {
 			return err
 		}
 
-		// Ensure that the key is deleted from GPG
+		// Ensure that the key is deleted from GPG, even when ctx is done
 		defer func() {
 			//nolint:errcheck
-			runCommand("gpg", "--delete-keys", "--yes", a.SigningKey)
+			runCommand(log, "gpg", "--delete-keys", "--yes", a.SigningKey)
 		}()
 
 		// Then we export the key to disk
-		if err := writeCommandOutput(a.signingKeyPath(), nil, "gpg", "--export", a.SigningKey); err != nil {
+		if err := writeCommandOutput(ctx, log, a.signingKeyPath(), nil, "gpg", "--export", a.SigningKey); err != nil {
 			return err
 		}
 	}
@@ -410,7 +477,7 @@ func (a *Apt) receiveSigningKey(log *viaduct.Logger) error {
 // The content goes to a temporary file that is moved into place, so a command
 // that fails part way through does not leave a truncated file behind for the
 // next run to treat as valid.
-func writeCommandOutput(path string, stdin io.Reader, args ...string) error {
+func writeCommandOutput(ctx context.Context, log *viaduct.Logger, path string, stdin io.Reader, args ...string) error {
 	tmp := path + ".viaduct-tmp"
 
 	f, err := os.Create(tmp)
@@ -418,13 +485,11 @@ func writeCommandOutput(path string, stdin io.Reader, args ...string) error {
 		return err
 	}
 
-	// nolint:gosec
-	cmd := exec.Command(args[0], args[1:]...)
+	cmd := commandContext(ctx, args...)
 	cmd.Stdin = stdin
 	cmd.Stdout = f
-	cmd.Stderr = aptStderr()
 
-	if runErr := cmd.Run(); runErr != nil {
+	if runErr := log.Capture(cmd, false).Done(cmd.Run()); runErr != nil {
 		f.Close()
 		os.Remove(tmp)
 
@@ -445,25 +510,26 @@ func (a *Apt) signingKeyPath() string {
 }
 
 // Delete removes an apt repository
-func (a *Apt) deleteApt(log *viaduct.Logger) error {
-	if viaduct.Cli.DryRun {
-		log.Info("deleted", "name", a.Name)
-		return nil
-	}
-
+func (a *Apt) deleteApt(ctx context.Context, log *viaduct.Logger) error {
 	if !viaduct.FileExists(a.path) {
+		if a.updatePending {
+			return a.update(ctx, log)
+		}
+
 		log.Noop("up-to-date", "name", a.Name)
 		return nil
 	}
 
-	if err := os.Remove(a.path); err != nil {
-		return err
+	if !log.DryRun() {
+		if err := os.Remove(a.path); err != nil {
+			return err
+		}
 	}
 
 	log.Info("deleted", "name", a.Name)
 
 	if a.Update {
-		return a.updateApt(log)
+		return a.update(ctx, log)
 	}
 
 	return nil
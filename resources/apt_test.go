@@ -1,6 +1,7 @@
 package resources
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,6 +26,17 @@ func TestAptExecuteHelpers(t *testing.T) {
 		// The helpers are valid resources in their own right
 		assert.NoError(t, e.PreflightChecks(testLogger))
 	}
+
+	// A path from the home directory is expanded against the runtime of the
+	// run, rather than when the resource is built
+	deb := InstallDeb("~/test.deb")
+	assert.Equal(t, []string{"dpkg", "-i", "~/test.deb"}, deb.Args)
+
+	rt := &viaduct.Runtime{Options: &viaduct.Options{Silent: true}, Attributes: &viaduct.SystemAttributes{}}
+	rt.Attributes.User.HomeDir = "/home/test"
+
+	assert.NoError(t, deb.PreflightChecks(rt.NewLogger("Test", "Test")))
+	assert.Equal(t, []string{"dpkg", "-i", "/home/test/test.deb"}, deb.Args)
 }
 
 func TestAptParams(t *testing.T) {
@@ -41,4 +53,47 @@ func TestAptParams(t *testing.T) {
 		assert.False(t, params.GlobalLock)
 		assert.Empty(t, params.LockKey)
 	})
+
+	t.Run("retried unless deleting", func(t *testing.T) {
+		assert.True(t, (&Apt{Name: "test", URI: "https://example.com"}).Params().Retryable)
+		assert.False(t, (&Apt{Name: "test", Delete: true}).Params().Retryable)
+	})
+}
+
+func TestAptPruned(t *testing.T) {
+	a := &Apt{Name: "docker", URI: "https://download.docker.com/linux/ubuntu", Format: Sources, Update: true}
+
+	assert.Equal(t, &Apt{
+		Name:   "docker",
+		URI:    "https://download.docker.com/linux/ubuntu",
+		Format: Sources,
+		Update: true,
+		Delete: true,
+	}, a.Pruned())
+
+	assert.Nil(t, (&Apt{UpdateOnly: true}).Pruned())
+}
+
+func TestAptRetry(t *testing.T) {
+	t.Parallel()
+
+	// Not root, so the update after the file is written fails
+	rt := &viaduct.Runtime{Options: &viaduct.Options{Silent: true}, Attributes: &viaduct.SystemAttributes{}}
+	log := rt.NewLogger("Apt", "Create")
+
+	dir := t.TempDir()
+	a := &Apt{
+		Name:         "test",
+		URI:          "https://example.com",
+		Distribution: "noble",
+		Source:       "main",
+		Format:       List,
+		Update:       true,
+		path:         filepath.Join(dir, "test.list"),
+		altpath:      filepath.Join(dir, "test.sources"),
+	}
+
+	assert.EqualError(t, a.Run(log), "must be run as root")
+	assert.FileExists(t, a.path)
+	assert.EqualError(t, a.Run(log), "must be run as root", "a retry still updates, though the file is up to date")
 }
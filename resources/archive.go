@@ -11,7 +11,6 @@ import (
 	"path"
 	"path/filepath"
 	"slices"
-	"strconv"
 	"strings"
 
 	"github.com/surminus/viaduct"
@@ -49,6 +48,17 @@ func (a *Archive) Description() string {
 	return fmt.Sprintf("%s -> %s", a.Path, a.Dest)
 }
 
+// Identity is the directory the archive is extracted to.
+func (a *Archive) Identity() string {
+	return a.Dest
+}
+
+// Claims claims the directory the archive is extracted to, which other
+// resources that want a directory there can share.
+func (a *Archive) Claims() []viaduct.Claim {
+	return pathClaim(a.Dest, stateDirectory)
+}
+
 func (a *Archive) Params() *viaduct.ResourceParams {
 	return viaduct.NewResourceParams()
 }
@@ -81,16 +91,16 @@ func (a *Archive) OperationName() string {
 }
 
 func (a *Archive) Run(log *viaduct.Logger) error {
-	apath := viaduct.ExpandPath(a.Path)
-	dest := viaduct.ExpandPath(a.Dest)
+	apath := log.Runtime().ExpandPath(a.Path)
+	dest := log.Runtime().ExpandPath(a.Dest)
 
-	if viaduct.Cli.DryRun {
-		log.Info("extracted", "path", apath, "dest", dest)
+	if a.NotIfExists && a.upToDate(dest) {
+		log.Noop("up-to-date", "path", apath, "dest", dest)
 		return nil
 	}
 
-	if a.NotIfExists && a.upToDate(dest) {
-		log.Noop("up-to-date", "path", apath, "dest", dest)
+	if log.DryRun() {
+		log.Info("extracted", "path", apath, "dest", dest)
 		return nil
 	}
 
@@ -103,7 +113,7 @@ func (a *Archive) Run(log *viaduct.Logger) error {
 		return err
 	}
 
-	log.Info("extracted", "path", apath, "dest", dest, "files", strconv.Itoa(count))
+	log.Info("extracted", "path", apath, "dest", dest, "files", count)
 
 	return nil
 }
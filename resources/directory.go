@@ -13,6 +13,11 @@ type Directory struct {
 	Path string
 	// Delete removes the directory if set to true.
 	Delete bool
+	// IfEmpty only deletes the directory when there is nothing in it,
+	// warning and leaving it alone otherwise. It is how a directory taken
+	// out of the manifest is pruned, so files that something else put in it
+	// are never lost.
+	IfEmpty bool
 
 	// NoRecursive applies the ownership to the directory itself, leaving
 	// whatever is inside it alone. The default is to apply it to the whole
@@ -38,6 +43,21 @@ func (d *Directory) Description() string {
 	return d.Path
 }
 
+// Identity is the path of the directory.
+func (d *Directory) Identity() string {
+	return d.Path
+}
+
+// Claims claims the path of the directory, which other resources that want a
+// directory there can share.
+func (d *Directory) Claims() []viaduct.Claim {
+	if d.Delete {
+		return pathClaim(d.Path, stateAbsent)
+	}
+
+	return pathClaim(d.Path, stateDirectory)
+}
+
 func (d *Directory) Params() *viaduct.ResourceParams {
 	return viaduct.NewResourceParams()
 }
@@ -50,7 +70,7 @@ func (d *Directory) PreflightChecks(log *viaduct.Logger) error {
 		return fmt.Errorf("required parameter: Path")
 	}
 
-	return d.preflightPermissions(pdir)
+	return d.preflightPermissions(log, pdir)
 }
 
 func (d *Directory) OperationName() string {
@@ -61,6 +81,17 @@ func (d *Directory) OperationName() string {
 	return "Create"
 }
 
+// Pruned returns the resource that deletes the directory once it is taken out
+// of the manifest, as long as it is empty. Anything pruned from inside it is
+// removed first.
+func (d *Directory) Pruned() viaduct.ResourceAttributes {
+	if d.Delete {
+		return nil
+	}
+
+	return &Directory{Path: d.Path, Delete: true, IfEmpty: true}
+}
+
 func (d *Directory) Run(log *viaduct.Logger) error {
 	if d.Delete {
 		return d.deleteDirectory(log)
@@ -71,15 +102,17 @@ func (d *Directory) Run(log *viaduct.Logger) error {
 
 // Create creates a directory
 func (d *Directory) createDirectory(log *viaduct.Logger) error {
-	path := viaduct.ExpandPath(d.Path)
-
-	if viaduct.Cli.DryRun {
-		log.Info("created", "path", path)
-		return nil
-	}
+	path := log.Runtime().ExpandPath(d.Path)
 
 	if !viaduct.DirExists(path) {
-		if err := os.MkdirAll(viaduct.ExpandPath(path), d.Mode); err != nil {
+		// A directory a dry run would have created has no permissions to
+		// compare against
+		if log.DryRun() {
+			log.Info("created", "path", path)
+			return nil
+		}
+
+		if err := os.MkdirAll(log.Runtime().ExpandPath(path), d.Mode); err != nil {
 			return err
 		}
 
@@ -97,17 +130,27 @@ func (d *Directory) createDirectory(log *viaduct.Logger) error {
 
 // Delete deletes a directory.
 func (d *Directory) deleteDirectory(log *viaduct.Logger) error {
-	path := viaduct.ExpandPath(d.Path)
-
-	if viaduct.Cli.DryRun {
-		log.Info("deleted", "path", path)
-		return nil
-	}
+	path := log.Runtime().ExpandPath(d.Path)
 
 	if viaduct.DirExists(path) {
-		if err := os.RemoveAll(viaduct.ExpandPath(d.Path)); err != nil {
-			return err
+		if d.IfEmpty {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return err
+			}
+
+			if len(entries) > 0 {
+				log.Warn("kept", "path", path, "reason", "not empty")
+				return nil
+			}
 		}
+
+		if !log.DryRun() {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+
 		log.Info("deleted", "path", path)
 	} else {
 		log.Noop("up-to-date", "path", path)
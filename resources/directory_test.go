@@ -98,3 +98,34 @@ func TestDirectory(t *testing.T) {
 		assert.Equal(t, false, viaduct.DirExists(d.Path))
 	})
 }
+
+func TestDirectoryPruned(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, &Directory{Path: "/opt/app", Delete: true, IfEmpty: true}, Dir("/opt/app").Pruned())
+	assert.Nil(t, (&Directory{Path: "/opt/app", Delete: true}).Pruned())
+
+	t.Run("only removes an empty directory", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "dir")
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(path, "unmanaged"), []byte("keep"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		pruned := Dir(path).Pruned()
+		assert.NoError(t, pruned.Run(testLogger))
+		assert.True(t, viaduct.FileExists(filepath.Join(path, "unmanaged")))
+
+		if err := os.Remove(filepath.Join(path, "unmanaged")); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NoError(t, pruned.Run(testLogger))
+		assert.False(t, viaduct.DirExists(path))
+	})
+}
@@ -1,6 +1,7 @@
 package resources
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -44,8 +45,18 @@ func (a *Download) Description() string {
 	return fmt.Sprintf("%s -> %s", a.URL, a.Path)
 }
 
+// Identity is the path the file is downloaded to.
+func (a *Download) Identity() string {
+	return a.Path
+}
+
+// Claims claims the path the file is downloaded to.
+func (a *Download) Claims() []viaduct.Claim {
+	return pathClaim(a.Path, "")
+}
+
 func (a *Download) Params() *viaduct.ResourceParams {
-	return viaduct.NewResourceParams()
+	return &viaduct.ResourceParams{Retryable: true}
 }
 
 func (a *Download) PreflightChecks(log *viaduct.Logger) error {
@@ -57,7 +68,7 @@ func (a *Download) PreflightChecks(log *viaduct.Logger) error {
 		return fmt.Errorf("required parameter: Path")
 	}
 
-	return a.preflightPermissions(pfile)
+	return a.preflightPermissions(log, pfile)
 }
 
 func (a *Download) OperationName() string {
@@ -65,11 +76,17 @@ func (a *Download) OperationName() string {
 }
 
 func (a *Download) Run(log *viaduct.Logger) error {
-	return a.get(log)
+	return a.RunContext(context.Background(), log)
 }
 
-func (a *Download) get(log *viaduct.Logger) error {
-	path := viaduct.ExpandPath(a.Path)
+// RunContext downloads the file, aborting the transfer and removing what was
+// written so far once ctx is done
+func (a *Download) RunContext(ctx context.Context, log *viaduct.Logger) error {
+	return a.get(ctx, log)
+}
+
+func (a *Download) get(ctx context.Context, log *viaduct.Logger) error {
+	path := log.Runtime().ExpandPath(a.Path)
 
 	if a.CreateDirIfMissing {
 		if err := ensureParentDir(log, path); err != nil {
@@ -77,13 +94,13 @@ func (a *Download) get(log *viaduct.Logger) error {
 		}
 	}
 
-	if viaduct.Cli.DryRun {
-		log.Info("downloaded", "url", a.URL, "path", path)
+	if viaduct.FileExists(path) && a.NotIfExists {
+		log.Noop("up-to-date", "url", a.URL, "path", path)
 		return nil
 	}
 
-	if viaduct.FileExists(path) && a.NotIfExists {
-		log.Noop("up-to-date", "url", a.URL, "path", path)
+	if log.DryRun() {
+		log.Info("downloaded", "url", a.URL, "path", path)
 		return nil
 	}
 
@@ -92,8 +109,17 @@ func (a *Download) get(log *viaduct.Logger) error {
 		return err
 	}
 
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL, nil)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+
+	// Cancelling the request also fails the copy below part way through, so
+	// a download that runs out of time stops writing and is removed
 	var client http.Client
-	resp, err := client.Get(a.URL)
+	resp, err := client.Do(req)
 	if err != nil {
 		file.Close()
 		os.Remove(path)
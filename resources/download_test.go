@@ -1,6 +1,7 @@
 package resources
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -95,6 +96,29 @@ func TestDown(t *testing.T) {
 		assert.Equal(t, false, viaduct.FileExists(d.Path))
 	})
 
+	t.Run("cancelled download is removed", func(t *testing.T) {
+		// Not parallel: gock intercepts the global transport.
+		defer gock.Off()
+
+		testurl := "http://test-cancelled.com"
+
+		gock.New(testurl).
+			Get("/").
+			Reply(200).
+			BodyString("OK")
+
+		d := newTestDownload(t, testurl, "test/acceptance/download/cancelled.txt")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := d.RunContext(ctx, testLogger)
+		assert.Error(t, err)
+
+		// Nothing is left behind for the next run to mistake for the file
+		assert.Equal(t, false, viaduct.FileExists(d.Path))
+	})
+
 	t.Run("create with missing parent dir", func(t *testing.T) {
 		// Not parallel: gock intercepts the global transport, so running
 		// alongside the other gock-based subtest would clobber its mocks.
@@ -1,14 +1,15 @@
 package resources
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"os/exec"
 	"strings"
 
 	"github.com/surminus/viaduct"
 )
 
+// Execute runs a command, through bash or with its arguments passed directly.
 type Execute struct {
 	// Command is the command to run. It is passed to "bash -c", so shell
 	// syntax such as pipes and redirection works, and any value containing
@@ -21,6 +22,11 @@ type Execute struct {
 	// shell metacharacters need no quoting. Set either Command or Args.
 	Args []string
 
+	// ExpandPaths expands "~" at the start of any of Args to the home
+	// directory of the user the run is for, as resources that take a path
+	// do. Optional.
+	ExpandPaths bool
+
 	// WorkingDirectory is where to run the command. Optional.
 	WorkingDirectory string
 
@@ -93,6 +99,12 @@ func (e *Execute) PreflightChecks(log *viaduct.Logger) error {
 	}
 
 	// Set optional defaults here
+	if e.ExpandPaths {
+		for i, arg := range e.Args {
+			e.Args[i] = log.Runtime().ExpandPath(arg)
+		}
+	}
+
 	return nil
 }
 
@@ -101,33 +113,37 @@ func (e *Execute) OperationName() string {
 }
 
 func (e *Execute) Run(log *viaduct.Logger) error {
-	return e.runExecute(log)
+	return e.RunContext(context.Background(), log)
+}
+
+// RunContext runs the command, killing it once ctx is done
+func (e *Execute) RunContext(ctx context.Context, log *viaduct.Logger) error {
+	return e.runExecute(ctx, log)
 }
 
 // Run runs the given command
-func (e *Execute) runExecute(log *viaduct.Logger) error {
+func (e *Execute) runExecute(ctx context.Context, log *viaduct.Logger) error {
 	if e.Unless != "" {
-		// nolint:gosec
-		ucmd := exec.Command("bash", "-c", e.Unless)
-		setCommandOutput(ucmd)
+		ucmd := commandContext(ctx, "bash", "-c", e.Unless)
+		output := log.Capture(ucmd, true)
 
-		if err := ucmd.Run(); err == nil {
+		if err := output.Done(ucmd.Run()); err == nil {
 			log.Noop("skipped", "command", e.Description())
 			return nil
 		}
 	}
 
 	log.Info("started", "command", e.Description())
-	if viaduct.Cli.DryRun {
+	if log.DryRun() {
 		return nil
 	}
 
-	cmd := e.command()
-	setCommandOutput(cmd)
+	cmd := e.command(ctx)
+	output := log.Capture(cmd, true)
 	cmd.Dir = e.WorkingDirectory
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("command failed: %s", e.Description())
+	if err := output.Done(cmd.Run()); err != nil {
+		return fmt.Errorf("command failed: %s: %w", e.Description(), err)
 	}
 	log.Info("finished", "command", e.Description())
 
@@ -136,29 +152,10 @@ func (e *Execute) runExecute(log *viaduct.Logger) error {
 
 // command builds the command to run, using a shell only when the command was
 // given as a single string
-func (e *Execute) command() *exec.Cmd {
+func (e *Execute) command(ctx context.Context) *exec.Cmd {
 	if len(e.Args) > 0 {
-		// nolint:gosec
-		return exec.Command(e.Args[0], e.Args[1:]...)
-	}
-
-	// nolint:gosec
-	return exec.Command("bash", "-c", e.Command)
-}
-
-func setCommandOutput(cmd *exec.Cmd) {
-	if viaduct.Cli.Silent || viaduct.Cli.JSON {
-		cmd.Stdout = nil
-		cmd.Stderr = nil
-		return
-	}
-
-	if viaduct.Cli.Quiet {
-		cmd.Stdout = nil
-		cmd.Stderr = os.Stderr
-		return
+		return commandContext(ctx, e.Args...)
 	}
 
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	return commandContext(ctx, "bash", "-c", e.Command)
 }
@@ -1,8 +1,14 @@
 package resources
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/surminus/viaduct"
@@ -34,6 +40,58 @@ func TestExecute(t *testing.T) {
 		err := e.Run(testLogger)
 		assert.Error(t, err)
 	})
+
+	t.Run("with its output in the error", func(t *testing.T) {
+		e := newTestExecute(t)
+		e.Command = "echo 'no such table' >&2; exit 3"
+
+		err := e.Run(testLogger)
+		assert.EqualError(t, err, "command failed: echo 'no such table' >&2; exit 3: exit status 3: no such table")
+
+		var cmdErr *viaduct.CommandError
+		if assert.ErrorAs(t, err, &cmdErr) {
+			assert.Equal(t, "no such table\n", cmdErr.Stderr)
+		}
+	})
+
+	t.Run("killed once the context is done", func(t *testing.T) {
+		e := newTestExecute(t)
+		e.Command = "sleep 30"
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := e.RunContext(ctx, testLogger)
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 10*time.Second)
+	})
+
+	t.Run("what the command started is killed with it", func(t *testing.T) {
+		pidFile := filepath.Join(t.TempDir(), "pid")
+
+		e := newTestExecute(t)
+		e.Command = "sleep 30 & echo $! > " + pidFile + "; wait"
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		assert.Error(t, e.RunContext(ctx, testLogger))
+
+		content, err := os.ReadFile(pidFile)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+		assert.NoError(t, err)
+
+		// Once killed, the sleep is gone, or a zombie waiting to be reaped
+		assert.Eventually(t, func() bool {
+			stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+			return err != nil || strings.Contains(string(stat), ") Z ")
+		}, 5*time.Second, 10*time.Millisecond)
+	})
 }
 
 func TestExecuteLock(t *testing.T) {
@@ -24,6 +24,9 @@ type File struct {
 	// exist, rather than relying on a separately declared Directory resource.
 	// The parent is created with 0755 and default ownership.
 	CreateDirIfMissing bool
+	// Sensitive keeps the content out of the diff logged by a dry run, for a
+	// file that holds secrets.
+	Sensitive bool
 
 	// PermissionsOnly manages the mode and ownership of a file whose content
 	// belongs to something else, leaving that content alone. The file has to
@@ -71,6 +74,24 @@ func (f *File) Description() string {
 	return f.Path
 }
 
+// Identity is the path of the file.
+func (f *File) Identity() string {
+	return f.Path
+}
+
+// Claims claims the path of the file. Managing only its permissions leaves it
+// to whatever else writes it, as long as the file stays.
+func (f *File) Claims() []viaduct.Claim {
+	switch {
+	case f.Delete:
+		return pathClaim(f.Path, stateAbsent)
+	case f.PermissionsOnly:
+		return pathClaim(f.Path, statePresent)
+	default:
+		return pathClaim(f.Path, "")
+	}
+}
+
 func (f *File) Params() *viaduct.ResourceParams {
 	return viaduct.NewResourceParams()
 }
@@ -103,7 +124,7 @@ func (f *File) PreflightChecks(log *viaduct.Logger) error {
 		f.Mode = 0o644
 	}
 
-	return f.preflightPermissions(pfile)
+	return f.preflightPermissions(log, pfile)
 }
 
 // managesOwnership reports whether any ownership was asked for
@@ -152,6 +173,17 @@ func (f *File) OperationName() string {
 	}
 }
 
+// Pruned returns the resource that deletes the file once it is taken out of
+// the manifest. A file that is only having its permissions managed belongs to
+// something else, so it is left alone.
+func (f *File) Pruned() viaduct.ResourceAttributes {
+	if f.Delete || f.PermissionsOnly {
+		return nil
+	}
+
+	return DeleteFile(f.Path)
+}
+
 func (f *File) Run(log *viaduct.Logger) error {
 	switch {
 	case f.Delete:
@@ -165,22 +197,23 @@ func (f *File) Run(log *viaduct.Logger) error {
 
 // Create creates or updates a file
 func (f *File) createFile(log *viaduct.Logger) error {
-	return writeManagedFile(log, f.Path, f.Content, &f.Permissions, f.CreateDirIfMissing)
+	return writeManagedFile(log, f.Path, f.Content, &f.Permissions, f.CreateDirIfMissing, f.Sensitive)
 }
 
 // setPermissions applies the mode and ownership to a file that already exists,
 // leaving its content alone
 func (f *File) setPermissions(log *viaduct.Logger) error {
-	path := viaduct.ExpandPath(f.Path)
-
-	if viaduct.Cli.DryRun {
-		log.Info("permissions-managed", "path", path)
-		return nil
-	}
+	path := log.Runtime().ExpandPath(f.Path)
 
 	// There is no content to fall back on, so a missing file is an error
-	// rather than something to create
+	// rather than something to create. A dry run has not created anything,
+	// so there the file may yet come from an earlier resource.
 	if !viaduct.FileExists(path) {
+		if log.DryRun() {
+			log.Info("permissions-managed", "path", path)
+			return nil
+		}
+
 		return fmt.Errorf("file does not exist: %s", path)
 	}
 
@@ -219,12 +252,7 @@ func (f *File) setPermissions(log *viaduct.Logger) error {
 
 // Delete deletes a file
 func (f *File) deleteFile(log *viaduct.Logger) error {
-	path := viaduct.ExpandPath(f.Path)
-
-	if viaduct.Cli.DryRun {
-		log.Info("deleted", "path", path)
-		return nil
-	}
+	path := log.Runtime().ExpandPath(f.Path)
 
 	// If the file does not exist, return early
 	if !viaduct.FileExists(path) {
@@ -232,6 +260,11 @@ func (f *File) deleteFile(log *viaduct.Logger) error {
 		return nil
 	}
 
+	if log.DryRun() {
+		log.Info("deleted", "path", path)
+		return nil
+	}
+
 	if err := os.Remove(path); err != nil {
 		return err
 	}
@@ -238,3 +238,104 @@ func TestFileOperationName(t *testing.T) {
 	assert.Equal(t, "Delete", DeleteFile("/tmp/test").OperationName())
 	assert.Equal(t, "Update", SetPermissions("/tmp/test", 0o600).OperationName())
 }
+
+func TestFileDiff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newTestFile(t, path)
+	f.Content = "new\n"
+
+	log := newRecordingLogger()
+	assert.NoError(t, f.Run(log))
+
+	// What was written is already on disk, so only a dry run shows it
+	assert.Equal(t, "new\n", viaduct.FileContents(path))
+	assert.Empty(t, diffs(log))
+}
+
+func TestFileDryRun(t *testing.T) {
+	dryRun(t)
+
+	t.Run("reports a diff without writing", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		f := newTestFile(t, path)
+		f.Content = "new\n"
+
+		log := newRecordingLogger()
+		assert.NoError(t, f.Run(log))
+
+		assert.Equal(t, "old\n", viaduct.FileContents(path))
+		assert.Equal(t, viaduct.OutcomeChanged, log.Outcome())
+
+		d := diffs(log)
+		if assert.Len(t, d, 1) {
+			assert.Contains(t, d[0], "-old\n")
+			assert.Contains(t, d[0], "+new\n")
+		}
+	})
+
+	t.Run("a sensitive file has no diff", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		f := newTestFile(t, path)
+		f.Content = "secret\n"
+		f.Sensitive = true
+
+		log := newRecordingLogger()
+		assert.NoError(t, f.Run(log))
+
+		assert.Equal(t, viaduct.OutcomeChanged, log.Outcome())
+		assert.Empty(t, diffs(log))
+	})
+
+	t.Run("up to date file is unchanged", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(path, []byte("Test Content"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		log := newRecordingLogger()
+		assert.NoError(t, newTestFile(t, path).Run(log))
+
+		assert.Equal(t, viaduct.OutcomeUnchanged, log.Outcome())
+		assert.Empty(t, diffs(log))
+	})
+
+	t.Run("missing file is not created", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file")
+
+		log := newRecordingLogger()
+		assert.NoError(t, newTestFile(t, path).Run(log))
+
+		assert.False(t, viaduct.FileExists(path))
+		assert.Equal(t, viaduct.OutcomeChanged, log.Outcome())
+	})
+
+	t.Run("deleting a missing file is unchanged", func(t *testing.T) {
+		log := newRecordingLogger()
+		assert.NoError(t, DeleteFile(filepath.Join(t.TempDir(), "file")).Run(log))
+
+		assert.Equal(t, viaduct.OutcomeUnchanged, log.Outcome())
+	})
+}
+
+func TestFilePruned(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, DeleteFile("/etc/motd"), CreateFile("/etc/motd", "hello").Pruned())
+
+	// Nothing to remove for a removal, or for a file that belongs to
+	// something else
+	assert.Nil(t, DeleteFile("/etc/motd").Pruned())
+	assert.Nil(t, SetPermissions("/etc/motd", 0o644).Pruned())
+}
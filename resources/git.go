@@ -1,11 +1,14 @@
 package resources
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/surminus/viaduct"
 )
 
@@ -24,6 +27,12 @@ type Git struct {
 	Ensure bool
 	// Delete will remove the Git directory.
 	Delete bool
+	// IfClean only deletes the repository when it has nothing of its own:
+	// no uncommitted or stashed changes, and no commits that a remote
+	// doesn't have. Otherwise it warns and leaves the repository alone. It
+	// is how a repository taken out of the manifest is pruned, so work in a
+	// clone is never lost.
+	IfClean bool
 
 	// Permissions manages permissions for the repository
 	Permissions
@@ -38,8 +47,23 @@ func (g *Git) Description() string {
 	return fmt.Sprintf("%s -> %s", g.URL, g.Path)
 }
 
+// Identity is the path the repository is cloned to.
+func (g *Git) Identity() string {
+	return g.Path
+}
+
+// Claims claims the path the repository is cloned to.
+func (g *Git) Claims() []viaduct.Claim {
+	if g.Delete {
+		return pathClaim(g.Path, stateAbsent)
+	}
+
+	return pathClaim(g.Path, "")
+}
+
 func (g *Git) Params() *viaduct.ResourceParams {
-	return viaduct.NewResourceParams()
+	// Cloning and pulling go over the network
+	return &viaduct.ResourceParams{Retryable: !g.Delete}
 }
 
 // PreflightChecks sets default values for the parameters for a particular
@@ -63,7 +87,7 @@ func (g *Git) PreflightChecks(log *viaduct.Logger) error {
 		g.RemoteName = "origin"
 	}
 
-	return g.preflightPermissions(pdir)
+	return g.preflightPermissions(log, pdir)
 }
 
 func (g *Git) OperationName() string {
@@ -74,20 +98,35 @@ func (g *Git) OperationName() string {
 	return "Create"
 }
 
+// Pruned returns the resource that deletes the repository once it is taken out
+// of the manifest, as long as it has nothing of its own that would be lost
+func (g *Git) Pruned() viaduct.ResourceAttributes {
+	if g.Delete {
+		return nil
+	}
+
+	return &Git{Path: g.Path, URL: g.URL, Delete: true, IfClean: true}
+}
+
 func (g *Git) Run(log *viaduct.Logger) error {
+	return g.RunContext(context.Background(), log)
+}
+
+// RunContext clones or pulls the repository, aborting the transfer once ctx is
+// done
+func (g *Git) RunContext(ctx context.Context, log *viaduct.Logger) error {
 	if g.Delete {
 		return g.deleteGit(log)
 	} else {
-		return g.createGit(log)
+		return g.createGit(ctx, log)
 	}
 }
 
-func (g *Git) createGit(log *viaduct.Logger) error {
-	path := viaduct.ExpandPath(g.Path)
+func (g *Git) createGit(ctx context.Context, log *viaduct.Logger) error {
+	path := log.Runtime().ExpandPath(g.Path)
 
-	if viaduct.Cli.DryRun {
-		log.Info("cloned", "url", g.URL, "path", path)
-		return nil
+	if log.DryRun() {
+		return g.dryRunGit(log, path)
 	}
 
 	if viaduct.FileExists(path) && g.Ensure {
@@ -101,17 +140,17 @@ func (g *Git) createGit(log *viaduct.Logger) error {
 			return err
 		}
 
-		if viaduct.Attribute.User.Username != "root" {
-			err = os.Setenv("SSH_KNOWN_HOSTS", viaduct.ExpandPath("~/.ssh/known_hosts"))
+		if log.Runtime().Attributes.User.Username != "root" {
+			err = os.Setenv("SSH_KNOWN_HOSTS", log.Runtime().ExpandPath("~/.ssh/known_hosts"))
 			if err != nil {
 				return err
 			}
 		}
 
-		progress := gitProgress()
+		progress := gitProgress(log)
 
 		// nolint:exhaustivestruct
-		err = w.Pull(&git.PullOptions{
+		err = w.PullContext(ctx, &git.PullOptions{
 			RemoteName:    g.RemoteName,
 			Progress:      progress,
 			ReferenceName: plumbing.ReferenceName(g.Reference),
@@ -128,16 +167,19 @@ func (g *Git) createGit(log *viaduct.Logger) error {
 	}
 
 	if !viaduct.FileExists(path) {
-		progress := gitProgress()
+		progress := gitProgress(log)
 
 		// nolint:exhaustivestruct
-		_, err := git.PlainClone(path, false, &git.CloneOptions{
+		_, err := git.PlainCloneContext(ctx, path, false, &git.CloneOptions{
 			Progress:      progress,
 			ReferenceName: plumbing.ReferenceName(g.Reference),
 			RemoteName:    g.RemoteName,
 			URL:           g.URL,
 		})
 		if err != nil {
+			// A clone that stopped part way through is not a repository the
+			// next run can pull into, so don't leave it behind
+			os.RemoveAll(path)
 			return err
 		}
 
@@ -151,8 +193,31 @@ func (g *Git) createGit(log *viaduct.Logger) error {
 	)
 }
 
-func gitProgress() *os.File {
-	if viaduct.Cli.Quiet || viaduct.Cli.Silent || viaduct.Cli.JSON {
+// dryRunGit reports what createGit would do without touching the repository.
+// Whether a pull brings anything in can't be known without fetching, which
+// writes to the repository, so one is always reported.
+func (g *Git) dryRunGit(log *viaduct.Logger, path string) error {
+	if !viaduct.FileExists(path) {
+		log.Info("cloned", "url", g.URL, "path", path)
+		return nil
+	}
+
+	if g.Ensure {
+		log.Info("pulled", "url", g.URL, "path", path)
+	}
+
+	return g.setDirectoryPermissions(
+		log,
+		path,
+		true,
+	)
+}
+
+func gitProgress(log *viaduct.Logger) *os.File {
+	// With --output=jsonl, STDOUT is the stream of events, which has to stay
+	// JSON
+	opts := log.Runtime().Options
+	if opts.Quiet || opts.Silent || opts.JSON || opts.Output == viaduct.OutputJSONL {
 		devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0755)
 		if err != nil {
 			return nil
@@ -163,16 +228,20 @@ func gitProgress() *os.File {
 }
 
 func (g *Git) deleteGit(log *viaduct.Logger) error {
-	path := viaduct.ExpandPath(g.Path)
-
-	if viaduct.Cli.DryRun {
-		log.Info("deleted", "path", path)
-		return nil
-	}
+	path := log.Runtime().ExpandPath(g.Path)
 
 	if viaduct.DirExists(path) {
-		if err := os.RemoveAll(path); err != nil {
-			return err
+		if g.IfClean {
+			if reason := localWork(path); reason != "" {
+				log.Warn("kept", "path", path, "reason", reason)
+				return nil
+			}
+		}
+
+		if !log.DryRun() {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
 		}
 
 		log.Info("deleted", "path", path)
@@ -182,3 +251,95 @@ func (g *Git) deleteGit(log *viaduct.Logger) error {
 
 	return nil
 }
+
+// localWork says what deleting the repository at path would lose: changes that
+// are not committed, stashed changes, or commits on HEAD or a branch that no
+// remote-tracking branch has. It also refuses a repository with commits but no
+// remote to compare them with, and anything that isn't a repository at all. It
+// is empty for a clone with nothing of its own.
+func localWork(path string) string {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "not a repository: " + err.Error()
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return err.Error()
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return err.Error()
+	}
+
+	if !status.IsClean() {
+		return "uncommitted changes"
+	}
+
+	if _, err := r.Reference(plumbing.ReferenceName("refs/stash"), false); err == nil {
+		return "stashed changes"
+	}
+
+	refs, err := r.References()
+	if err != nil {
+		return err.Error()
+	}
+
+	var remotes, locals []*plumbing.Reference
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		switch {
+		case ref.Type() != plumbing.HashReference:
+		case ref.Name().IsRemote():
+			remotes = append(remotes, ref)
+		case ref.Name().IsBranch():
+			locals = append(locals, ref)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err.Error()
+	}
+
+	// A detached HEAD is on no branch, so its commits are checked on their own
+	if head, err := r.Head(); err == nil {
+		locals = append(locals, head)
+	}
+
+	if len(locals) == 0 {
+		return ""
+	}
+
+	if len(remotes) == 0 {
+		return "no remote to compare its commits with"
+	}
+
+	pushed := make(map[plumbing.Hash]bool)
+
+	for _, ref := range remotes {
+		c, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			return err.Error()
+		}
+
+		err = object.NewCommitPreorderIter(c, pushed, nil).ForEach(func(c *object.Commit) error {
+			pushed[c.Hash] = true
+			return nil
+		})
+
+		// A shallow clone has no history past where it was cut off
+		if err != nil && !errors.Is(err, plumbing.ErrObjectNotFound) {
+			return err.Error()
+		}
+	}
+
+	for _, ref := range locals {
+		if !pushed[ref.Hash()] {
+			return fmt.Sprintf("commits on %s that no remote has", ref.Name().Short())
+		}
+	}
+
+	return ""
+}
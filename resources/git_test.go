@@ -1,9 +1,14 @@
 package resources
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/stretchr/testify/assert"
 	"github.com/surminus/viaduct"
 )
@@ -41,3 +46,135 @@ func TestGit(t *testing.T) {
 		assert.Equal(t, false, viaduct.DirExists(g.Path))
 	})
 }
+
+func TestGitPruned(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, &Git{Path: "/opt/app", URL: "https://example.com/app.git", Delete: true, IfClean: true},
+		Repo("/opt/app", "https://example.com/app.git").Pruned())
+	assert.Nil(t, (&Git{Path: "/opt/app", Delete: true}).Pruned())
+
+	t.Run("only removes a clean clone", func(t *testing.T) {
+		t.Parallel()
+
+		path := newTestClone(t)
+		if err := os.WriteFile(filepath.Join(path, "work"), []byte("uncommitted"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		pruned := Repo(path, "https://example.com/app.git").Pruned()
+		assert.NoError(t, pruned.Run(testLogger))
+		assert.True(t, viaduct.FileExists(filepath.Join(path, "work")))
+
+		if err := os.Remove(filepath.Join(path, "work")); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NoError(t, pruned.Run(testLogger))
+		assert.False(t, viaduct.DirExists(path))
+	})
+
+	t.Run("keeps a clone with commits that were not pushed", func(t *testing.T) {
+		t.Parallel()
+
+		path := newTestClone(t)
+		testCommit(t, path, "unpushed")
+
+		assert.Equal(t, "commits on master that no remote has", localWork(path))
+
+		assert.NoError(t, Repo(path, "https://example.com/app.git").Pruned().Run(testLogger))
+		assert.True(t, viaduct.DirExists(path))
+	})
+
+	t.Run("keeps a repository with no remote", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "repo")
+		if _, err := git.PlainInit(path, false); err != nil {
+			t.Fatal(err)
+		}
+		testCommit(t, path, "first")
+
+		assert.Equal(t, "no remote to compare its commits with", localWork(path))
+	})
+
+	t.Run("keeps a clone with stashed changes", func(t *testing.T) {
+		t.Parallel()
+
+		path := newTestClone(t)
+		r, err := git.PlainOpen(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		head, err := r.Head()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.Storer.SetReference(plumbing.NewHashReference("refs/stash", head.Hash())); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "stashed changes", localWork(path))
+	})
+}
+
+func TestGitProgress(t *testing.T) {
+	t.Parallel()
+
+	rt := &viaduct.Runtime{Options: &viaduct.Options{}, Attributes: &viaduct.Attribute}
+	assert.Equal(t, os.Stdout, gitProgress(rt.NewLogger("Git", "Create")))
+
+	rt = &viaduct.Runtime{Options: &viaduct.Options{Output: viaduct.OutputJSONL}, Attributes: &viaduct.Attribute}
+	progress := gitProgress(rt.NewLogger("Git", "Create"))
+	assert.NotEqual(t, os.Stdout, progress, "STDOUT is the stream of events")
+	progress.Close()
+}
+
+// newTestClone returns a clone of a repository with a commit in it, with
+// nothing of its own
+func newTestClone(t *testing.T) string {
+	t.Helper()
+
+	origin := filepath.Join(t.TempDir(), "origin")
+	if _, err := git.PlainInit(origin, false); err != nil {
+		t.Fatal(err)
+	}
+	testCommit(t, origin, "first")
+
+	path := filepath.Join(t.TempDir(), "repo")
+	if _, err := git.PlainClone(path, false, &git.CloneOptions{URL: origin}); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// testCommit commits a file to the repository at path
+func testCommit(t *testing.T, path, name string) {
+	t.Helper()
+
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(path, name), []byte(name), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Add(name); err != nil {
+		t.Fatal(err)
+	}
+
+	author := &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	if _, err := w.Commit(name, &git.CommitOptions{Author: author}); err != nil {
+		t.Fatal(err)
+	}
+}
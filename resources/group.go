@@ -33,6 +33,20 @@ func (g *Group) Description() string {
 	return g.Name
 }
 
+// Identity is the name of the group.
+func (g *Group) Identity() string {
+	return g.Name
+}
+
+// Claims claims the group by name.
+func (g *Group) Claims() []viaduct.Claim {
+	if g.Delete {
+		return []viaduct.Claim{{Object: "group " + g.Name, State: stateAbsent}}
+	}
+
+	return []viaduct.Claim{{Object: "group " + g.Name, State: statePresent}}
+}
+
 func (g *Group) Params() *viaduct.ResourceParams {
 	// groupadd and groupdel lock the group database, so avoid running
 	// alongside other resources that write it
@@ -47,7 +61,7 @@ func (g *Group) PreflightChecks(log *viaduct.Logger) error {
 		return fmt.Errorf("required parameter: Name")
 	}
 
-	if !viaduct.IsRoot() {
+	if !log.Runtime().IsRoot() {
 		return fmt.Errorf("group resource must be run as root")
 	}
 
@@ -63,6 +77,16 @@ func (g *Group) OperationName() string {
 	return "Create"
 }
 
+// Pruned returns the resource that deletes the group once it is taken out of
+// the manifest
+func (g *Group) Pruned() viaduct.ResourceAttributes {
+	if g.Delete {
+		return nil
+	}
+
+	return &Group{Name: g.Name, Delete: true}
+}
+
 func (g *Group) Run(log *viaduct.Logger) error {
 	if g.Delete {
 		return g.delete(log)
@@ -84,7 +108,7 @@ func (g *Group) create(log *viaduct.Logger) error {
 		return nil
 	}
 
-	if viaduct.Cli.DryRun {
+	if log.DryRun() {
 		log.Info("created", "group", g.Name)
 		return nil
 	}
@@ -101,7 +125,7 @@ func (g *Group) create(log *viaduct.Logger) error {
 
 	args = append(args, g.Name)
 
-	if err := runCommand(args...); err != nil {
+	if err := runCommand(log, args...); err != nil {
 		return fmt.Errorf("groupadd failed for %s: %w", g.Name, err)
 	}
 
@@ -116,12 +140,12 @@ func (g *Group) delete(log *viaduct.Logger) error {
 		return nil
 	}
 
-	if viaduct.Cli.DryRun {
+	if log.DryRun() {
 		log.Info("deleted", "group", g.Name)
 		return nil
 	}
 
-	if err := runCommand("groupdel", g.Name); err != nil {
+	if err := runCommand(log, "groupdel", g.Name); err != nil {
 		return fmt.Errorf("groupdel failed for %s: %w", g.Name, err)
 	}
 
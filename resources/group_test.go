@@ -46,3 +46,8 @@ func TestGroupExistingGIDConflict(t *testing.T) {
 	err := g.create(testLogger)
 	assert.EqualError(t, err, "group root exists with gid 0, not 12345")
 }
+
+func TestGroupPruned(t *testing.T) {
+	assert.Equal(t, &Group{Name: "docker", Delete: true}, SystemGroup("docker").Pruned())
+	assert.Nil(t, (&Group{Name: "docker", Delete: true}).Pruned())
+}
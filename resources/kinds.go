@@ -0,0 +1,25 @@
+package resources
+
+import "github.com/surminus/viaduct"
+
+//go:generate go run ../internal/kinddoc
+
+// init registers every resource kind in the package, so each can be rebuilt
+// from saved state by name
+func init() {
+	viaduct.RegisterKind("Apt", func() viaduct.ResourceAttributes { return &Apt{} })
+	viaduct.RegisterKind("Archive", func() viaduct.ResourceAttributes { return &Archive{} })
+	viaduct.RegisterKind("Directory", func() viaduct.ResourceAttributes { return &Directory{} })
+	viaduct.RegisterKind("Download", func() viaduct.ResourceAttributes { return &Download{} })
+	viaduct.RegisterKind("Execute", func() viaduct.ResourceAttributes { return &Execute{} })
+	viaduct.RegisterKind("File", func() viaduct.ResourceAttributes { return &File{} })
+	viaduct.RegisterKind("Git", func() viaduct.ResourceAttributes { return &Git{} })
+	viaduct.RegisterKind("Group", func() viaduct.ResourceAttributes { return &Group{} })
+	viaduct.RegisterKind("Line", func() viaduct.ResourceAttributes { return &Line{} })
+	viaduct.RegisterKind("Link", func() viaduct.ResourceAttributes { return &Link{} })
+	viaduct.RegisterKind("Package", func() viaduct.ResourceAttributes { return &Package{} })
+	viaduct.RegisterKind("Service", func() viaduct.ResourceAttributes { return &Service{} })
+	viaduct.RegisterKind("Sysctl", func() viaduct.ResourceAttributes { return &Sysctl{} })
+	viaduct.RegisterKind("Template", func() viaduct.ResourceAttributes { return &Template{} })
+	viaduct.RegisterKind("User", func() viaduct.ResourceAttributes { return &User{} })
+}
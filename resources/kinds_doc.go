@@ -0,0 +1,151 @@
+// Code generated by kinddoc; DO NOT EDIT.
+
+package resources
+
+import "github.com/surminus/viaduct"
+
+// init documents every resource kind in the package, for --list-kinds
+func init() {
+	viaduct.DocumentKind("Apt", "Apt configures Ubuntu apt repositories. It will automatically use sudo if the user is not root.", map[string]string{
+		"Name":          "Name is the name of the resource, and is what the file written to disk will be based on",
+		"URI":           "URI is the source URI of the repository",
+		"Distribution":  "Distribution is normally the codename of the distribution. Defaults to the Ubuntu codename. For Sources format, this represents Suites.",
+		"Source":        "Source is repository type. Defaults to main. For Sources format, this represents Components.",
+		"Parameters":    "Parameters is a map of optional parameters that gets represented as key value pairs, eg \"[arch=amd64]\"",
+		"SigningKey":    "SigningKey will use the legacy apt-key command to retrieve a key",
+		"SigningKeyURL": "SigningKeyURL will retrieve the signing key for the package, and include it as part of the source list",
+		"Format":        "Format will either use the list or sources format",
+		"PublicPgpKey":  "PublicPgpKey is just a string representation of a public key. This is only applicable to Sources format.",
+		"Delete":        "Delete will remove the apt repository if set to true.",
+		"Update":        "Update will perform an apt update after adding the repository.",
+		"UpdateOnly":    "UpdateOnly will only perform an apt update.",
+	})
+	viaduct.DocumentKind("Archive", "Archive extracts a tar or zip archive into a destination directory. Supported formats are tar.gz, tgz, tar.bz2, tbz2, tar and zip, detected by file extension.", map[string]string{
+		"Path":        "Path is the path to the archive file",
+		"Dest":        "Dest is the directory to extract into",
+		"Strip":       "Strip removes this number of leading path components from archive entries, like tar --strip-components. Optional.",
+		"Pick":        "Pick only extracts entries with these paths, after stripping. Optional.",
+		"NotIfExists": "NotIfExists will skip extraction if all Pick entries already exist within Dest, or if Dest exists when Pick is not set. Optional.",
+	})
+	viaduct.DocumentKind("Directory", "Directory manages a directory on the filesystem", map[string]string{
+		"Path":        "Path is the path of the directory",
+		"Delete":      "Delete removes the directory if set to true.",
+		"IfEmpty":     "IfEmpty only deletes the directory when there is nothing in it, warning and leaving it alone otherwise. It is how a directory taken out of the manifest is pruned, so files that something else put in it are never lost.",
+		"NoRecursive": "NoRecursive applies the ownership to the directory itself, leaving whatever is inside it alone. The default is to apply it to the whole tree.",
+		"Mode":        "Mode is the permissions set of the file",
+		"User":        "User sets the user permissions by user name",
+		"Group":       "Group sets the group permissions by group name",
+		"UID":         "UID sets the user permissions by UID",
+		"GID":         "GID sets the group permissions by GID",
+		"Root":        "Root enforces using the root user",
+	})
+	viaduct.DocumentKind("Download", "Download will fetch data from the given URL, and write it to the given path.", map[string]string{
+		"URL":                "URL is where to download the data from",
+		"Path":               "Path is where to store the downloaded data",
+		"NotIfExists":        "NotIfExists will not download the file if it already exists",
+		"CreateDirIfMissing": "CreateDirIfMissing creates the parent directory if it does not already exist. The parent is created with 0755 and default ownership.",
+		"Checksum":           "Checksum is the expected SHA256 hex digest of the downloaded file. When set, the download is verified after writing and fails on mismatch.",
+		"Mode":               "Mode is the permissions set of the file",
+		"User":               "User sets the user permissions by user name",
+		"Group":              "Group sets the group permissions by group name",
+		"UID":                "UID sets the user permissions by UID",
+		"GID":                "GID sets the group permissions by GID",
+		"Root":               "Root enforces using the root user",
+	})
+	viaduct.DocumentKind("Execute", "Execute runs a command, through bash or with its arguments passed directly.", map[string]string{
+		"Command":          "Command is the command to run. It is passed to \"bash -c\", so shell syntax such as pipes and redirection works, and any value containing spaces or shell metacharacters has to be quoted by the caller. Use Args when you want arguments passed to the program verbatim.",
+		"Args":             "Args runs a command without a shell: Args[0] is the program, and the rest are passed as literal arguments, so values containing spaces or shell metacharacters need no quoting. Set either Command or Args.",
+		"ExpandPaths":      "ExpandPaths expands \"~\" at the start of any of Args to the home directory of the user the run is for, as resources that take a path do. Optional.",
+		"WorkingDirectory": "WorkingDirectory is where to run the command. Optional.",
+		"Unless":           "Unless is another command to run, which if exits cleanly signifies that we should not run the execute command. It runs through bash in the same way as Command. Optional.",
+		"Lock":             "Lock ensures the command does not run at the same time as other resources holding a lock, such as Package. Useful for commands that need the dpkg lock, like apt-get or dpkg. Optional.",
+		"LockKey":          "LockKey narrows the lock to a single domain, such as viaduct.PackageLock, so the command only waits for other resources using the same key. Implies Lock. Optional.",
+	})
+	viaduct.DocumentKind("File", "File manages files on the filesystem", map[string]string{
+		"Path":               "Path is the path of the file",
+		"Content":            "Content is the content of the file",
+		"Delete":             "Delete will delete the file rather than create it if set to true.",
+		"CreateDirIfMissing": "CreateDirIfMissing creates the parent directory if it does not already exist, rather than relying on a separately declared Directory resource. The parent is created with 0755 and default ownership.",
+		"Sensitive":          "Sensitive keeps the content out of the diff logged by a dry run, for a file that holds secrets.",
+		"PermissionsOnly":    "PermissionsOnly manages the mode and ownership of a file whose content belongs to something else, leaving that content alone. The file has to exist already. Only what is set is applied: nothing is defaulted, so a Mode on its own leaves ownership as it is, and a User on its own leaves the mode and the group as they are.",
+		"Mode":               "Mode is the permissions set of the file",
+		"User":               "User sets the user permissions by user name",
+		"Group":              "Group sets the group permissions by group name",
+		"UID":                "UID sets the user permissions by UID",
+		"GID":                "GID sets the group permissions by GID",
+		"Root":               "Root enforces using the root user",
+	})
+	viaduct.DocumentKind("Git", "Git manages a Git repository", map[string]string{
+		"Path":       "Path specifies where to clone the repository to. Required.",
+		"URL":        "URL is the URL of the Git repository. Required.",
+		"Reference":  "Reference specifies the reference to fetch. Defaults to \"refs/heads/main\".",
+		"RemoteName": "Remote specifies the remote name. Defaults to \"origin\".",
+		"Ensure":     "Ensure will continue to pull the latest changes. Optional.",
+		"Delete":     "Delete will remove the Git directory.",
+		"IfClean":    "IfClean only deletes the repository when it has nothing of its own: no uncommitted or stashed changes, and no commits that a remote doesn't have. Otherwise it warns and leaves the repository alone. It is how a repository taken out of the manifest is pruned, so work in a clone is never lost.",
+		"Mode":       "Mode is the permissions set of the file",
+		"User":       "User sets the user permissions by user name",
+		"Group":      "Group sets the group permissions by group name",
+		"UID":        "UID sets the user permissions by UID",
+		"GID":        "GID sets the group permissions by GID",
+		"Root":       "Root enforces using the root user",
+	})
+	viaduct.DocumentKind("Group", "Group manages a group. To add a user to a group, use the Groups option on the User resource: this is for managing the group itself, such as creating one with a fixed GID before the users that belong to it.", map[string]string{
+		"Name":   "Name is the name of the group",
+		"GID":    "GID is the group ID. Optional.",
+		"System": "System creates a system group. Optional.",
+		"Delete": "Delete removes the group instead of creating it. Optional.",
+	})
+	viaduct.DocumentKind("Line", "Line manages a single line within a file, for editing files that are not fully managed by a File resource. If the file does not exist it is created containing the line.", map[string]string{
+		"Path":   "Path is the file to manage",
+		"Line":   "Line is the line that should be present in the file",
+		"Match":  "Match is a regular expression. If a line matches, it is replaced with Line rather than Line being appended. If multiple lines match, the first is replaced and the rest are removed. Optional.",
+		"Delete": "Delete removes lines rather than adding them. Lines are removed if they match the Match expression, or are equal to Line if Match is not set.",
+	})
+	viaduct.DocumentKind("Link", "Link creates a symlink. If the file exists and is not a symlink, it will be created and replaced with the link. If the file exists, is a symlink but does not have the right source, it will be replaced.", map[string]string{
+		"Path":               "Path is the path of the symlinked file/directory",
+		"Source":             "Source is the original file/directory we are linking to",
+		"Delete":             "Delete will delete the symlink.",
+		"CreateDirIfMissing": "CreateDirIfMissing creates the parent directory of the symlink if it does not already exist. The parent is created with 0755 and default ownership.",
+	})
+	viaduct.DocumentKind("Package", "Package installs one or more packages. Specify the package names.", map[string]string{
+		"Names":     "Names are the package names",
+		"Verbose":   "Verbose displays output from STDOUT. Optional.",
+		"Uninstall": "Uninstall will uninstall the specified packages.",
+		"Purge":     "Purge uninstalls the specified packages and removes their configuration, like \"apt-get purge\". Not every package manager has an equivalent, so this is only supported on Debian and Arch derivatives.",
+		"Hold":      "Hold marks the specified packages as held back, so the package manager will not upgrade them, like \"apt-mark hold\". It does not install them: chain this after a Pkg if the packages need installing too. Only supported on Debian derivatives.",
+		"Unhold":    "Unhold releases a hold, letting the packages be upgraded again.",
+	})
+	viaduct.DocumentKind("Service", "Service manages a systemd service, allowing it to be enabled or disabled on boot, and started, stopped or restarted.", map[string]string{
+		"Name":    "Name is the name of the service unit",
+		"Action":  "Action is performed against the service: one of start, stop or restart. Optional.",
+		"Enable":  "Enable enables the service on boot. Optional.",
+		"Disable": "Disable disables the service on boot. Optional.",
+	})
+	viaduct.DocumentKind("Sysctl", "Sysctl writes a sysctl configuration file to /etc/sysctl.d and applies it with \"sysctl --system\" if any of the values are not currently set.", map[string]string{
+		"Name":   "Name is the name of the configuration file. A \".conf\" suffix is added if not present.",
+		"Values": "Values are the sysctl keys and their desired values",
+		"Delete": "Delete removes the configuration file. The values stay applied until they are set again or the machine reboots.",
+	})
+	viaduct.DocumentKind("Template", "Template renders a Go template file from disk and writes the result to the destination. For templates embedded into the configuration binary, see NewTemplate.", map[string]string{
+		"Source":             "Source is the path to the template file",
+		"Dest":               "Dest is where to write the rendered file",
+		"Variables":          "Variables are made available to the template. Referencing a variable that does not exist in this map is an error.",
+		"CreateDirIfMissing": "CreateDirIfMissing creates the parent directory of Dest if it does not already exist. The parent is created with 0755 and default ownership.",
+		"Sensitive":          "Sensitive keeps the rendered content out of the diff logged by a dry run, for a template that renders secrets.",
+		"Mode":               "Mode is the permissions set of the file",
+		"User":               "User sets the user permissions by user name",
+		"Group":              "Group sets the group permissions by group name",
+		"UID":                "UID sets the user permissions by UID",
+		"GID":                "GID sets the group permissions by GID",
+		"Root":               "Root enforces using the root user",
+	})
+	viaduct.DocumentKind("User", "User creates a user. If the user already exists, only the supplementary Groups are applied.", map[string]string{
+		"Name":   "Name is the name of the user",
+		"UID":    "UID is the user ID. Optional.",
+		"GID":    "GID is the group ID. If set, a group with the same name as the user is created with this ID before the user is created. Optional.",
+		"System": "System creates a system user. Optional.",
+		"Shell":  "Shell is the login shell. Defaults to /bin/bash. Optional.",
+		"Groups": "Groups are supplementary groups the user should belong to. Optional.",
+	})
+}
@@ -0,0 +1,176 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/surminus/viaduct"
+)
+
+func TestLoadManifest(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.yaml")
+
+	content := fmt.Sprintf(`
+resources:
+  - kind: File
+    depends_on: config-dir
+    attributes:
+      path: %[1]s/config/settings
+      content: "setting = true\n"
+      mode: "0600"
+  - kind: Directory
+    name: config-dir
+    attributes:
+      path: %[1]s/config
+`, dir)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	m, err := viaduct.LoadManifest(path)
+	assert.NoError(t, err)
+
+	m.DisableState()
+
+	_, err = m.Apply(context.Background())
+	assert.NoError(t, err)
+
+	settings := filepath.Join(dir, "config", "settings")
+	if got, err := os.ReadFile(settings); assert.NoError(t, err) {
+		assert.Equal(t, "setting = true\n", string(got))
+	}
+
+	if info, err := os.Stat(settings); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+}
+
+func TestKindsDocumented(t *testing.T) {
+	t.Parallel()
+
+	for _, k := range viaduct.Kinds() {
+		assert.NotEmpty(t, k.Doc, "%s is not documented: run go generate", k.Name)
+	}
+}
+
+func TestManifestJSON(t *testing.T) {
+	t.Parallel()
+
+	m := viaduct.New()
+	dir := m.Add(&Directory{Path: "/tmp/viaduct-json", Permissions: Permissions{Mode: 0o700}})
+	m.Add(CreateFile("/tmp/viaduct-json/file", "content"), dir)
+	m.Add(Pkgs("curl", "git"))
+
+	out, err := json.Marshal(m)
+	assert.NoError(t, err)
+
+	var decoded viaduct.Manifest
+	assert.NoError(t, json.Unmarshal(out, &decoded))
+
+	again, err := json.Marshal(&decoded)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(out), string(again))
+}
+
+func TestIdentity(t *testing.T) {
+	t.Parallel()
+
+	m := viaduct.New()
+
+	for _, tc := range []struct {
+		attributes viaduct.ResourceAttributes
+		expected   viaduct.ResourceID
+	}{
+		{CreateFile("/etc/motd", "Welcome!"), "File.Create:/etc/motd"},
+		{DeleteFile("/etc/motd"), "File.Delete:/etc/motd"},
+		{&Package{Names: []string{"git", "curl"}}, "Package.Install:curl git"},
+		{&Service{Name: "nginx", Action: "restart"}, "Service.Restart:nginx"},
+		{AppendLine("/etc/hosts", "127.0.0.1 example"), "Line.Update:/etc/hosts 127.0.0.1 example"},
+	} {
+		r, err := m.AddE(tc.attributes)
+		if assert.NoError(t, err) {
+			assert.Equal(t, tc.expected, r.ResourceID)
+		}
+	}
+
+	_, err := m.AddE(CreateFile("/etc/motd", "Hello!"))
+	assert.ErrorContains(t, err, "File /etc/motd is already managed by File.Create:/etc/motd")
+
+	_, err = m.AddE(&Package{Names: []string{"curl", "git"}})
+	assert.ErrorContains(t, err, "resource already exists")
+
+	r, err := m.AddE(&Execute{Command: "true"})
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(r.ResourceID), "Execute_id-")
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "foo")
+
+	for _, tc := range []struct {
+		name      string
+		resources []viaduct.ResourceAttributes
+		conflicts []string
+	}{
+		{
+			name:      "a file and its deletion",
+			resources: []viaduct.ResourceAttributes{CreateFile(path, "foo"), DeleteFile(path)},
+			conflicts: []string{"File File.Create:" + path + " and File File.Delete:" + path + " both manage path " + path},
+		},
+		{
+			name:      "a file and a line in it",
+			resources: []viaduct.ResourceAttributes{CreateFile(path, "foo"), AppendLine(path, "bar")},
+			conflicts: []string{"File File.Create:" + path + " and Line Line.Update:" + path + " bar both manage path " + path},
+		},
+		{
+			name:      "lines in the same file",
+			resources: []viaduct.ResourceAttributes{AppendLine(path, "foo"), AppendLine(path, "bar"), SetPermissions(path, 0o600)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rt, err := viaduct.NewRuntime(viaduct.Options{DryRun: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m := viaduct.NewWithRuntime(rt)
+			m.DisableState()
+
+			for _, a := range tc.resources {
+				m.Add(a)
+			}
+
+			_, err = m.Apply(context.Background())
+			if len(tc.conflicts) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			var conflictErr *viaduct.ConflictError
+			if assert.ErrorAs(t, err, &conflictErr) && assert.Len(t, conflictErr.Conflicts, len(tc.conflicts)) {
+				for i, c := range conflictErr.Conflicts {
+					assert.Contains(t, c.String(), tc.conflicts[i])
+				}
+			}
+		})
+	}
+
+	t.Run("packages", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, []viaduct.Claim{{Object: "package curl", State: "installed"}}, Pkg("curl").Claims())
+		assert.Equal(t, []viaduct.Claim{{Object: "package curl", State: "removed"}}, PurgePkg("curl").Claims())
+		assert.Empty(t, HoldPkg("curl").Claims())
+	})
+}
@@ -54,6 +54,23 @@ func (l *Line) Description() string {
 	return l.Path
 }
 
+// Identity is the path of the file and the line being managed in it: the
+// Match expression if there is one, or else the line itself.
+func (l *Line) Identity() string {
+	if l.Match != "" {
+		return l.Path + " " + l.Match
+	}
+
+	return l.Path + " " + l.Line
+}
+
+// Claims claims the path of the file. Lines only need the file to be there, so
+// any number of them can manage the same file, but not alongside a resource
+// that writes the whole file.
+func (l *Line) Claims() []viaduct.Claim {
+	return pathClaim(l.Path, statePresent)
+}
+
 func (l *Line) Params() *viaduct.ResourceParams {
 	return viaduct.NewResourceParams()
 }
@@ -105,23 +122,21 @@ func (l *Line) Run(log *viaduct.Logger) error {
 }
 
 func (l *Line) updateLine(log *viaduct.Logger) error {
-	path := viaduct.ExpandPath(l.Path)
-
-	if viaduct.Cli.DryRun {
-		log.Info("updated", "path", path, "line", l.Line)
-		return nil
-	}
+	path := log.Runtime().ExpandPath(l.Path)
 
 	// Serialise edits to this file so a concurrent Line resource on the
 	// same path can't clobber our read-modify-write.
 	defer lockPath(path)()
 
 	if !viaduct.FileExists(path) {
-		if err := writeLines(path, []string{l.Line}); err != nil {
-			return err
+		if !log.DryRun() {
+			if err := writeLines(path, []string{l.Line}); err != nil {
+				return err
+			}
 		}
 
 		log.Info("created", "path", path, "line", l.Line)
+		log.Diff(path, "", joinLines([]string{l.Line}))
 		return nil
 	}
 
@@ -167,22 +182,20 @@ func (l *Line) updateLine(log *viaduct.Logger) error {
 		return nil
 	}
 
-	if err := writeLines(path, out); err != nil {
-		return err
+	if !log.DryRun() {
+		if err := writeLines(path, out); err != nil {
+			return err
+		}
 	}
 
 	log.Info("updated", "path", path, "line", l.Line)
+	log.Diff(path, joinLines(lines), joinLines(out))
 
 	return nil
 }
 
 func (l *Line) deleteLine(log *viaduct.Logger) error {
-	path := viaduct.ExpandPath(l.Path)
-
-	if viaduct.Cli.DryRun {
-		log.Info("deleted", "path", path)
-		return nil
-	}
+	path := log.Runtime().ExpandPath(l.Path)
 
 	// Serialise edits to this file so a concurrent Line resource on the
 	// same path can't clobber our read-modify-write.
@@ -216,11 +229,14 @@ func (l *Line) deleteLine(log *viaduct.Logger) error {
 		return nil
 	}
 
-	if err := writeLines(path, out); err != nil {
-		return err
+	if !log.DryRun() {
+		if err := writeLines(path, out); err != nil {
+			return err
+		}
 	}
 
 	log.Info("deleted", "path", path)
+	log.Diff(path, joinLines(lines), joinLines(out))
 
 	return nil
 }
@@ -245,5 +261,10 @@ func writeLines(path string, lines []string) error {
 		mode = info.Mode()
 	}
 
-	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), mode)
+	return os.WriteFile(path, []byte(joinLines(lines)), mode)
+}
+
+// joinLines renders lines as file content, ending with a newline
+func joinLines(lines []string) string {
+	return strings.Join(lines, "\n") + "\n"
 }
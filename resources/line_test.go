@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/surminus/viaduct"
 )
 
 func newTestLine(t *testing.T, content string, line *Line) *Line {
@@ -220,3 +221,42 @@ func TestLineHelpers(t *testing.T) {
 	assert.Equal(t, &Line{Path: "/tmp/f", Match: "^foo", Line: "foo=1"}, ReplaceLine("/tmp/f", "^foo", "foo=1"))
 	assert.Equal(t, &Line{Path: "/tmp/f", Match: "^foo", Delete: true}, DeleteLine("/tmp/f", "^foo"))
 }
+
+func TestLineDryRun(t *testing.T) {
+	dryRun(t)
+
+	t.Run("reports a diff without writing", func(t *testing.T) {
+		l := newTestLine(t, "a\nb\n", ReplaceLine("", "^b", "c"))
+
+		log := newRecordingLogger()
+		assert.NoError(t, l.Run(log))
+
+		assert.Equal(t, "a\nb\n", readTestFile(t, l.Path))
+		assert.Equal(t, viaduct.OutcomeChanged, log.Outcome())
+
+		d := diffs(log)
+		if assert.Len(t, d, 1) {
+			assert.Contains(t, d[0], "-b\n")
+			assert.Contains(t, d[0], "+c\n")
+		}
+	})
+
+	t.Run("matching line is unchanged", func(t *testing.T) {
+		l := newTestLine(t, "a\nb\n", AppendLine("", "b"))
+
+		log := newRecordingLogger()
+		assert.NoError(t, l.Run(log))
+
+		assert.Equal(t, viaduct.OutcomeUnchanged, log.Outcome())
+	})
+
+	t.Run("delete leaves the file alone", func(t *testing.T) {
+		l := newTestLine(t, "a\nb\n", DeleteLine("", "^a"))
+
+		log := newRecordingLogger()
+		assert.NoError(t, l.Run(log))
+
+		assert.Equal(t, "a\nb\n", readTestFile(t, l.Path))
+		assert.Equal(t, viaduct.OutcomeChanged, log.Outcome())
+	})
+}
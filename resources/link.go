@@ -38,6 +38,20 @@ func (l *Link) Description() string {
 	return fmt.Sprintf("%s -> %s", l.Source, l.Path)
 }
 
+// Identity is the path of the link.
+func (l *Link) Identity() string {
+	return l.Path
+}
+
+// Claims claims the path of the link.
+func (l *Link) Claims() []viaduct.Claim {
+	if l.Delete {
+		return pathClaim(l.Path, stateAbsent)
+	}
+
+	return pathClaim(l.Path, "")
+}
+
 func (l *Link) Params() *viaduct.ResourceParams {
 	return viaduct.NewResourceParams()
 }
@@ -62,6 +76,16 @@ func (l *Link) OperationName() string {
 	return "Create"
 }
 
+// Pruned returns the resource that deletes the symlink once it is taken out of
+// the manifest
+func (l *Link) Pruned() viaduct.ResourceAttributes {
+	if l.Delete {
+		return nil
+	}
+
+	return &Link{Path: l.Path, Delete: true}
+}
+
 func (l *Link) Run(log *viaduct.Logger) error {
 	if l.Delete {
 		return l.deleteLink(log)
@@ -79,12 +103,12 @@ func (l *Link) createLink(log *viaduct.Logger) error {
 
 	// The source should always be the full path, so we will
 	// attempt to expand it
-	source, err := filepath.Abs(viaduct.ExpandPath(l.Source))
+	source, err := filepath.Abs(log.Runtime().ExpandPath(l.Source))
 	if err != nil {
 		return err
 	}
 
-	path := viaduct.ExpandPath(l.Path)
+	path := log.Runtime().ExpandPath(l.Path)
 
 	if l.CreateDirIfMissing {
 		if err := ensureParentDir(log, path); err != nil {
@@ -92,31 +116,26 @@ func (l *Link) createLink(log *viaduct.Logger) error {
 		}
 	}
 
-	if viaduct.Cli.DryRun {
+	// If the file exists and is a symlink, let's check the source is correct
+	if viaduct.LinkExists(path) {
+		src, err := os.Readlink(path)
+		if err == nil && src == source {
+			// Everything is as we want it, so return
+			log.Noop("up-to-date", "source", source, "path", path)
+			return nil
+		}
+	}
+
+	if log.DryRun() {
 		log.Info("created", "source", source, "path", path)
 		return nil
 	}
 
-	// If the file exists and is a symlink, let's check the source is correct
+	// Whatever is there is either a symlink to the wrong source or not a
+	// symlink at all, so it is replaced
 	if viaduct.LinkExists(path) {
-		src, err := os.Readlink(path)
-		if err == nil {
-			// If the source is not correct, let's delete the symlink
-			if src != source {
-				if err := os.Remove(path); err != nil {
-					return err
-				}
-			} else {
-				// Otherwise everything is as we want it, so return
-				log.Noop("up-to-date", "source", source, "path", path)
-				return nil
-			}
-		} else {
-			// If there is an error, then it isn't a symlink. In this case,
-			// let's delete the file and replace it with a link.
-			if err := os.Remove(path); err != nil {
-				return err
-			}
+		if err := os.Remove(path); err != nil {
+			return err
 		}
 	}
 
@@ -131,15 +150,15 @@ func (l *Link) createLink(log *viaduct.Logger) error {
 
 // Delete deletes the symlink from the Path
 func (l *Link) deleteLink(log *viaduct.Logger) error {
-	path := viaduct.ExpandPath(l.Path)
+	path := log.Runtime().ExpandPath(l.Path)
 
-	if viaduct.Cli.DryRun {
-		log.Info("deleted", "path", path)
+	if !viaduct.LinkExists(path) {
+		log.Noop("up-to-date", "path", path)
 		return nil
 	}
 
-	if !viaduct.LinkExists(path) {
-		log.Noop("up-to-date", "path", path)
+	if log.DryRun() {
+		log.Info("deleted", "path", path)
 		return nil
 	}
 
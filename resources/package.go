@@ -1,9 +1,11 @@
 package resources
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
 	"os/exec"
+	"slices"
 	"strings"
 
 	"github.com/surminus/viaduct"
@@ -39,6 +41,33 @@ func (p *Package) Description() string {
 	return strings.Join(p.Names, ", ")
 }
 
+// Identity is the names of the packages, sorted so that the order they are
+// given in does not matter. They are separated by spaces rather than commas,
+// so the ID can be given to --only-id.
+func (p *Package) Identity() string {
+	return strings.Join(slices.Sorted(slices.Values(p.Names)), " ")
+}
+
+// Claims claims each package, as installed or removed. Holding a package
+// leaves it however it was, so it claims nothing.
+func (p *Package) Claims() []viaduct.Claim {
+	if p.Hold || p.Unhold {
+		return nil
+	}
+
+	state := "installed"
+	if p.Uninstall || p.Purge {
+		state = "removed"
+	}
+
+	claims := make([]viaduct.Claim, 0, len(p.Names))
+	for _, name := range p.Names {
+		claims = append(claims, viaduct.Claim{Object: "package " + name, State: state})
+	}
+
+	return claims
+}
+
 func (p *Package) Params() *viaduct.ResourceParams {
 	// Package managers take their own lock, and their maintainer scripts write
 	// the passwd database, so the package lock covers both
@@ -53,12 +82,12 @@ func (p *Package) PreflightChecks(log *viaduct.Logger) error {
 		return fmt.Errorf("required parameter: Names")
 	}
 
-	if !viaduct.IsRoot() {
+	if !log.Runtime().IsRoot() {
 		return fmt.Errorf("package resource must be run as root")
 	}
 
-	if p.Purge && !purgeSupported(viaduct.Attribute.Platform.ID) {
-		return fmt.Errorf("purge is not supported on %s", viaduct.Attribute.Platform.ID)
+	if p.Purge && !purgeSupported(log.Runtime().Attributes.Platform.ID) {
+		return fmt.Errorf("purge is not supported on %s", log.Runtime().Attributes.Platform.ID)
 	}
 
 	if p.Hold && p.Unhold {
@@ -69,8 +98,8 @@ func (p *Package) PreflightChecks(log *viaduct.Logger) error {
 		return fmt.Errorf("cannot hold and uninstall the same packages")
 	}
 
-	if (p.Hold || p.Unhold) && !holdSupported(viaduct.Attribute.Platform.ID) {
-		return fmt.Errorf("holding packages is not supported on %s", viaduct.Attribute.Platform.ID)
+	if (p.Hold || p.Unhold) && !holdSupported(log.Runtime().Attributes.Platform.ID) {
+		return fmt.Errorf("holding packages is not supported on %s", log.Runtime().Attributes.Platform.ID)
 	}
 
 	// Set optional defaults here
@@ -179,43 +208,184 @@ func (p *Package) OperationName() string {
 }
 
 func (p *Package) Run(log *viaduct.Logger) error {
+	return p.RunContext(context.Background(), log)
+}
+
+// RunContext runs the package manager, killing it once ctx is done
+func (p *Package) RunContext(ctx context.Context, log *viaduct.Logger) error {
 	switch {
 	case p.Hold, p.Unhold:
-		return p.hold(log)
+		return p.hold(ctx, log)
 	case p.Uninstall, p.Purge:
-		return p.uninstall(log)
+		return p.uninstall(ctx, log)
 	default:
-		return p.install(log)
+		return p.install(ctx, log)
 	}
 }
 
-func (p *Package) install(log *viaduct.Logger) error {
-	log.Info("installing", "packages", strings.Join(p.Names, ", "))
-	if viaduct.Cli.DryRun {
+// install installs the packages. Only the ones that are missing are reported,
+// so a dry run names what it would install and installing packages that are
+// already there is not reported as a change, but a real run hands every
+// package to the package manager, which also upgrades those already installed.
+func (p *Package) install(ctx context.Context, log *viaduct.Logger) error {
+	platform := log.Runtime().Attributes.Platform.ID
+
+	states, err := p.states(ctx, log, platform)
+	if err != nil {
+		return err
+	}
+
+	if missing := packagesToInstall(p.Names, states); len(missing) > 0 {
+		log.Info("installing", "packages", strings.Join(missing, ", "))
+	} else {
+		log.Noop("up-to-date", "packages", strings.Join(p.Names, ", "))
+	}
+
+	if log.DryRun() {
 		return nil
 	}
 
-	return installPkg(viaduct.Attribute.Platform.ID, p.Names, p.Verbose)
+	return installPkg(ctx, log, platform, p.Names, p.Verbose)
 }
 
-func (p *Package) uninstall(log *viaduct.Logger) error {
-	if p.Purge {
-		log.Info("purging", "packages", strings.Join(p.Names, ", "))
-	} else {
-		log.Info("uninstalling", "packages", strings.Join(p.Names, ", "))
+// uninstall removes the packages. Only the ones that are still there are
+// reported, but a real run hands every package to the package manager.
+func (p *Package) uninstall(ctx context.Context, log *viaduct.Logger) error {
+	platform := log.Runtime().Attributes.Platform.ID
+
+	states, err := p.states(ctx, log, platform)
+	if err != nil {
+		return err
+	}
+
+	present := p.Names
+	if states != nil {
+		present = packagesToRemove(p.Names, states, p.Purge)
 	}
 
-	if viaduct.Cli.DryRun {
+	switch {
+	case len(present) == 0:
+		log.Noop("up-to-date", "packages", strings.Join(p.Names, ", "))
+	case p.Purge:
+		log.Info("purging", "packages", strings.Join(present, ", "))
+	default:
+		log.Info("uninstalling", "packages", strings.Join(present, ", "))
+	}
+
+	if log.DryRun() {
 		return nil
 	}
 
-	return removePkg(viaduct.Attribute.Platform.ID, p.Names, p.Verbose, p.Purge)
+	return removePkg(ctx, log, platform, p.Names, p.Verbose, p.Purge)
+}
+
+// states looks up the packages, to report what the run changes. A dry run
+// can't say what it would do without them, but a real run doesn't need them,
+// so when they can't be looked up every package is reported as changing.
+func (p *Package) states(ctx context.Context, log *viaduct.Logger, platform string) (map[string]string, error) {
+	states, err := packageStates(ctx, platform, p.Names)
+	if err != nil && log.DryRun() {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+// packagesToInstall returns the packages that are not fully installed. One a
+// previous install left half done is installed again.
+func packagesToInstall(names []string, states map[string]string) []string {
+	var change []string
+
+	for _, name := range names {
+		switch states[name] {
+		case "installed", "triggers-awaited", "triggers-pending":
+		default:
+			change = append(change, name)
+		}
+	}
+
+	return change
+}
+
+// packagesToRemove returns the packages that are at least partly installed. A
+// purge also takes a package whose configuration was left behind by an
+// earlier removal.
+func packagesToRemove(names []string, states map[string]string, purge bool) []string {
+	var change []string
+
+	for _, name := range names {
+		switch states[name] {
+		case "", "not-installed":
+		case "config-files":
+			if purge {
+				change = append(change, name)
+			}
+		default:
+			change = append(change, name)
+		}
+	}
+
+	return change
+}
+
+// packageStates looks up the packages, returning the dpkg state of each one it
+// finds, which is "installed" for any found on other platforms. A name the
+// package manager does not report back exactly, such as one pinned to a
+// version, is left out, so it is always handed to the package manager.
+func packageStates(ctx context.Context, platform string, names []string) (map[string]string, error) {
+	var args []string
+
+	switch platform {
+	case "debian", "ubuntu", "linuxmint":
+		args = []string{"dpkg-query", "-W", "-f=${Package} ${db:Status-Status}\n"}
+	case "fedora", "centos":
+		args = []string{"rpm", "-q", "--qf", "%{NAME} installed\n"}
+	case "arch", "manjaro":
+		// pacman follows each name with its version rather than a state
+		args = []string{"pacman", "-Q"}
+	default:
+		return nil, fmt.Errorf("unrecognised distribution: %s", platform)
+	}
+
+	// Each of these exits non-zero when any of the packages is missing, while
+	// still listing the ones it found
+	out, err := commandContext(ctx, append(args, names...)...).Output()
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("%s failed: %w", args[0], err)
+	}
+
+	return parsePackageStates(string(out), platform), nil
+}
+
+// parsePackageStates reads the output of a package query, one package per line
+// with its name first. rpm reports missing packages on stdout as well, in a
+// sentence that starts with "package" instead.
+func parsePackageStates(out, platform string) map[string]string {
+	states := make(map[string]string)
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] == "package" {
+			continue
+		}
+
+		switch platform {
+		case "arch", "manjaro":
+			states[fields[0]] = "installed"
+		default:
+			states[fields[0]] = fields[1]
+		}
+	}
+
+	return states
 }
 
 // hold marks packages as held back, or releases them, leaving alone any that
 // are already in the state we want
-func (p *Package) hold(log *viaduct.Logger) error {
-	held, err := heldPackages()
+func (p *Package) hold(ctx context.Context, log *viaduct.Logger) error {
+	held, err := heldPackages(ctx)
 	if err != nil {
 		return err
 	}
@@ -233,11 +403,11 @@ func (p *Package) hold(log *viaduct.Logger) error {
 
 	log.Info(action, "packages", strings.Join(change, ", "))
 
-	if viaduct.Cli.DryRun {
+	if log.DryRun() {
 		return nil
 	}
 
-	return runPkgCmd(append([]string{"apt-mark", action}, change...), p.Verbose)
+	return runPkgCmd(ctx, log, append([]string{"apt-mark", action}, change...), p.Verbose)
 }
 
 // holdsToChange returns the packages whose hold state does not match what was
@@ -255,8 +425,8 @@ func holdsToChange(names []string, held map[string]bool, want bool) []string {
 }
 
 // heldPackages returns the set of packages currently marked as held back
-func heldPackages() (map[string]bool, error) {
-	out, err := exec.Command("apt-mark", "showhold").Output()
+func heldPackages(ctx context.Context) (map[string]bool, error) {
+	out, err := commandContext(ctx, "apt-mark", "showhold").Output()
 	if err != nil {
 		return nil, fmt.Errorf("apt-mark showhold failed: %w", err)
 	}
@@ -269,22 +439,22 @@ func heldPackages() (map[string]bool, error) {
 	return held, nil
 }
 
-func installPkg(platform string, pkgs []string, verbose bool) error {
+func installPkg(ctx context.Context, log *viaduct.Logger, platform string, pkgs []string, verbose bool) error {
 	args, err := installArgs(platform, pkgs)
 	if err != nil {
 		return err
 	}
 
-	return runPkgCmd(args, verbose)
+	return runPkgCmd(ctx, log, args, verbose)
 }
 
-func removePkg(platform string, pkgs []string, verbose, purge bool) error {
+func removePkg(ctx context.Context, log *viaduct.Logger, platform string, pkgs []string, verbose, purge bool) error {
 	args, err := removeArgs(platform, pkgs, purge)
 	if err != nil {
 		return err
 	}
 
-	return runPkgCmd(args, verbose)
+	return runPkgCmd(ctx, log, args, verbose)
 }
 
 // installArgs builds the command that installs packages on a platform
@@ -325,23 +495,12 @@ func removeArgs(platform string, pkgs []string, purge bool) ([]string, error) {
 	}
 }
 
-func runPkgCmd(args []string, verbose bool) (err error) {
-	// nolint:gosec
-	cmd := exec.Command(args[0], args[1:]...)
-
-	if viaduct.Cli.JSON {
-		cmd.Stdout = nil
-		cmd.Stderr = nil
-	} else {
-		if verbose {
-			cmd.Stdout = os.Stdout
-		}
-		cmd.Stderr = os.Stderr
-	}
-
-	err = cmd.Run()
+func runPkgCmd(ctx context.Context, log *viaduct.Logger, args []string, verbose bool) error {
+	cmd := commandContext(ctx, args...)
 
-	return err
+	// Package managers are chatty, so their output only goes to the console
+	// when asked for, but it is always in the log
+	return log.Capture(cmd, verbose).Done(cmd.Run())
 }
 
 func aptGetArgs(command string, packages []string) []string {
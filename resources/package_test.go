@@ -1,15 +1,18 @@
 package resources
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/surminus/viaduct"
 )
 
 // Installing and uninstalling packages needs root and a real package manager,
 // so the acceptance coverage lives in the Docker integration tests. What we can
-// check here is the shortcuts, the operation naming and which platforms
-// support what.
+// check here is the shortcuts, the operation naming, which platforms support
+// what, and what a fake package manager is handed.
 
 func TestPackageShortcuts(t *testing.T) {
 	assert.Equal(t, []string{"curl"}, Pkg("curl").Names)
@@ -134,3 +137,106 @@ func TestHoldSupported(t *testing.T) {
 		assert.False(t, holdSupported(platform), platform)
 	}
 }
+
+func TestParsePackageStates(t *testing.T) {
+	t.Run("dpkg", func(t *testing.T) {
+		out := "curl installed\nvim config-files\ngit half-installed\n"
+
+		assert.Equal(t, map[string]string{
+			"curl": "installed",
+			"vim":  "config-files",
+			"git":  "half-installed",
+		}, parsePackageStates(out, "debian"))
+	})
+
+	t.Run("rpm skips the packages it did not find", func(t *testing.T) {
+		out := "curl installed\npackage vim is not installed\n"
+
+		assert.Equal(t, map[string]string{"curl": "installed"}, parsePackageStates(out, "fedora"))
+	})
+
+	t.Run("pacman lists versions", func(t *testing.T) {
+		out := "curl 8.5.0-1\n"
+
+		assert.Equal(t, map[string]string{"curl": "installed"}, parsePackageStates(out, "arch"))
+	})
+}
+
+func TestPackagesToChange(t *testing.T) {
+	states := map[string]string{
+		"curl": "installed",
+		"vim":  "config-files",
+		"git":  "half-installed",
+	}
+	names := []string{"curl", "vim", "git", "jq"}
+
+	t.Run("install skips what is installed", func(t *testing.T) {
+		assert.Equal(t, []string{"vim", "git", "jq"}, packagesToInstall(names, states))
+		assert.Empty(t, packagesToInstall([]string{"curl"}, states))
+	})
+
+	t.Run("remove only touches what is there", func(t *testing.T) {
+		assert.Equal(t, []string{"curl", "git"}, packagesToRemove(names, states, false))
+		assert.Empty(t, packagesToRemove([]string{"jq"}, states, false))
+	})
+
+	t.Run("purge takes leftover configuration too", func(t *testing.T) {
+		assert.Equal(t, []string{"curl", "vim", "git"}, packagesToRemove(names, states, true))
+	})
+}
+
+// TestPackageRun runs against a fake rpm, which only knows curl is installed,
+// and a fake dnf, which writes down what it was asked to do
+func TestPackageRun(t *testing.T) {
+	dir := t.TempDir()
+	args := filepath.Join(dir, "dnf-args")
+
+	scripts := map[string]string{
+		"rpm": "#!/bin/sh\necho 'curl installed'\necho 'package git is not installed'\nexit 1\n",
+		"dnf": "#!/bin/sh\necho \"$@\" > " + args + "\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	rt := &viaduct.Runtime{Options: &viaduct.Options{JSON: true}, Attributes: &viaduct.SystemAttributes{}}
+	rt.Attributes.Platform.ID = "fedora"
+
+	t.Run("a real run installs every package, and reports what was missing", func(t *testing.T) {
+		log := rt.NewLogger("Package", "Install")
+		assert.NoError(t, Pkgs("curl", "git").Run(log))
+
+		content, err := os.ReadFile(args)
+		assert.NoError(t, err)
+		assert.Equal(t, "install -y curl git\n", string(content), "installed packages are upgraded as before")
+
+		entries := log.Entries()
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "installing", entries[0].Message)
+			assert.Equal(t, "git", entries[0].Fields["packages"])
+		}
+	})
+
+	t.Run("installing what is there is no change", func(t *testing.T) {
+		log := rt.NewLogger("Package", "Install")
+		assert.NoError(t, Pkg("curl").Run(log))
+
+		content, err := os.ReadFile(args)
+		assert.NoError(t, err)
+		assert.Equal(t, "install -y curl\n", string(content))
+		assert.Equal(t, viaduct.OutcomeUnchanged, log.Outcome())
+	})
+
+	t.Run("uninstalling hands every package over too", func(t *testing.T) {
+		log := rt.NewLogger("Package", "Uninstall")
+		assert.NoError(t, (&Package{Names: []string{"curl", "git"}, Uninstall: true}).Run(log))
+
+		content, err := os.ReadFile(args)
+		assert.NoError(t, err)
+		assert.Equal(t, "remove -y curl git\n", string(content))
+	})
+}
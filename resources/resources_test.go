@@ -1,6 +1,10 @@
 package resources
 
-import "github.com/surminus/viaduct"
+import (
+	"testing"
+
+	"github.com/surminus/viaduct"
+)
 
 var testLogger *viaduct.Logger
 
@@ -8,3 +12,32 @@ func init() {
 	viaduct.Cli.SetSilent()
 	testLogger = viaduct.NewLogger("Test", "Testing")
 }
+
+// dryRun turns on dry run mode for the rest of the test. It changes global
+// state, so the test must not run in parallel.
+func dryRun(t *testing.T) {
+	viaduct.Cli.SetDryRun()
+	t.Cleanup(func() { viaduct.Cli.DryRun = false })
+}
+
+// newRecordingLogger returns a logger that keeps its entries, so a test can
+// check what a resource reported
+func newRecordingLogger() *viaduct.Logger {
+	viaduct.Cli.SetJSON()
+	defer func() { viaduct.Cli.JSON = false }()
+
+	return viaduct.NewLogger("Test", "Testing")
+}
+
+// diffs returns the diffs a logger recorded
+func diffs(log *viaduct.Logger) []string {
+	var out []string
+
+	for _, e := range log.Entries() {
+		if e.Level == "DIFF" {
+			out = append(out, e.Fields["diff"].(string))
+		}
+	}
+
+	return out
+}
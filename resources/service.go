@@ -1,8 +1,8 @@
 package resources
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
 	"strings"
 
 	"github.com/surminus/viaduct"
@@ -54,6 +54,34 @@ func (s *Service) Description() string {
 	return s.Name
 }
 
+// Identity is the name of the service.
+func (s *Service) Identity() string {
+	return s.Name
+}
+
+// Claims claims whether the service starts at boot and whether it is running,
+// for each that the resource changes.
+func (s *Service) Claims() []viaduct.Claim {
+	var claims []viaduct.Claim
+
+	switch {
+	case s.Enable:
+		claims = append(claims, viaduct.Claim{Object: "service " + s.Name + " at boot", State: "enabled"})
+	case s.Disable:
+		claims = append(claims, viaduct.Claim{Object: "service " + s.Name + " at boot", State: "disabled"})
+	}
+
+	switch s.Action {
+	case "":
+	case "stop":
+		claims = append(claims, viaduct.Claim{Object: "service " + s.Name, State: "stopped"})
+	default:
+		claims = append(claims, viaduct.Claim{Object: "service " + s.Name, State: "running"})
+	}
+
+	return claims
+}
+
 func (s *Service) Params() *viaduct.ResourceParams {
 	return viaduct.NewResourceParams()
 }
@@ -80,7 +108,7 @@ func (s *Service) PreflightChecks(log *viaduct.Logger) error {
 		return fmt.Errorf("requires one of Action, Enable or Disable")
 	}
 
-	if !viaduct.IsRoot() {
+	if !log.Runtime().IsRoot() {
 		return fmt.Errorf("service resource must be run as root")
 	}
 
@@ -106,37 +134,37 @@ func (s *Service) OperationName() string {
 }
 
 func (s *Service) Run(log *viaduct.Logger) error {
+	return s.RunContext(context.Background(), log)
+}
+
+// RunContext manages the service, killing systemctl once ctx is done
+func (s *Service) RunContext(ctx context.Context, log *viaduct.Logger) error {
 	if s.Enable {
-		if err := s.setEnabled(log, true); err != nil {
+		if err := s.setEnabled(ctx, log, true); err != nil {
 			return err
 		}
 	}
 
 	if s.Disable {
-		if err := s.setEnabled(log, false); err != nil {
+		if err := s.setEnabled(ctx, log, false); err != nil {
 			return err
 		}
 	}
 
 	if s.Action != "" {
-		return s.runAction(log)
+		return s.runAction(ctx, log)
 	}
 
 	return nil
 }
 
-func (s *Service) setEnabled(log *viaduct.Logger, enable bool) error {
+func (s *Service) setEnabled(ctx context.Context, log *viaduct.Logger, enable bool) error {
 	verb := "disable"
 	if enable {
 		verb = "enable"
 	}
 
-	if viaduct.Cli.DryRun {
-		log.Info(verb+"d", "service", s.Name)
-		return nil
-	}
-
-	state := s.enableState()
+	state := s.enableState(ctx)
 
 	// A masked unit cannot be enabled, and systemctl errors with an
 	// opaque message, so fail with a clear one instead.
@@ -158,7 +186,12 @@ func (s *Service) setEnabled(log *viaduct.Logger, enable bool) error {
 		return nil
 	}
 
-	if err := runCommand("systemctl", verb, s.Name); err != nil {
+	if log.DryRun() {
+		log.Info(verb+"d", "service", s.Name)
+		return nil
+	}
+
+	if err := runCommandContext(ctx, log, "systemctl", verb, s.Name); err != nil {
 		return fmt.Errorf("systemctl %s failed for %s: %w", verb, s.Name, err)
 	}
 
@@ -167,34 +200,34 @@ func (s *Service) setEnabled(log *viaduct.Logger, enable bool) error {
 	return nil
 }
 
-func (s *Service) runAction(log *viaduct.Logger) error {
+func (s *Service) runAction(ctx context.Context, log *viaduct.Logger) error {
 	msg := map[string]string{
 		"start":   "started",
 		"stop":    "stopped",
 		"restart": "restarted",
 	}[s.Action]
 
-	if viaduct.Cli.DryRun {
-		log.Info(msg, "service", s.Name)
-		return nil
-	}
-
 	// Starting an active service or stopping an inactive one is a noop;
 	// restart always runs
 	switch s.Action {
 	case "start":
-		if s.isActive() {
+		if s.isActive(ctx) {
 			log.Noop(msg, "service", s.Name)
 			return nil
 		}
 	case "stop":
-		if !s.isActive() {
+		if !s.isActive(ctx) {
 			log.Noop(msg, "service", s.Name)
 			return nil
 		}
 	}
 
-	if err := runCommand("systemctl", s.Action, s.Name); err != nil {
+	if log.DryRun() {
+		log.Info(msg, "service", s.Name)
+		return nil
+	}
+
+	if err := runCommandContext(ctx, log, "systemctl", s.Action, s.Name); err != nil {
 		return fmt.Errorf("systemctl %s failed for %s: %w", s.Action, s.Name, err)
 	}
 
@@ -206,13 +239,13 @@ func (s *Service) runAction(log *viaduct.Logger) error {
 // enableState returns the systemctl enablement state of the unit, such as
 // "enabled", "disabled", "static" or "masked". Returns an empty string if
 // the state cannot be determined.
-func (s *Service) enableState() string {
+func (s *Service) enableState(ctx context.Context) string {
 	// is-enabled prints the state to stdout and exits non-zero for some
 	// states (disabled, masked), so the exit code is ignored.
-	out, _ := exec.Command("systemctl", "is-enabled", s.Name).Output()
+	out, _ := commandContext(ctx, "systemctl", "is-enabled", s.Name).Output()
 	return strings.TrimSpace(string(out))
 }
 
-func (s *Service) isActive() bool {
-	return exec.Command("systemctl", "is-active", "--quiet", s.Name).Run() == nil
+func (s *Service) isActive(ctx context.Context) bool {
+	return commandContext(ctx, "systemctl", "is-active", "--quiet", s.Name).Run() == nil
 }
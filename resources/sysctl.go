@@ -20,6 +20,10 @@ type Sysctl struct {
 	// Values are the sysctl keys and their desired values
 	Values map[string]string
 
+	// Delete removes the configuration file. The values stay applied until
+	// they are set again or the machine reboots.
+	Delete bool
+
 	// path is a private attribute for where to write the file
 	path string
 }
@@ -28,6 +32,20 @@ func (s *Sysctl) Description() string {
 	return s.Name
 }
 
+// Identity is the name of the sysctl file.
+func (s *Sysctl) Identity() string {
+	return s.Name
+}
+
+// Claims claims the sysctl file by name.
+func (s *Sysctl) Claims() []viaduct.Claim {
+	if s.Delete {
+		return []viaduct.Claim{{Object: "sysctl " + s.Name, State: stateAbsent}}
+	}
+
+	return []viaduct.Claim{{Object: "sysctl " + s.Name}}
+}
+
 func (s *Sysctl) Params() *viaduct.ResourceParams {
 	return viaduct.NewResourceParams()
 }
@@ -46,11 +64,11 @@ func (s *Sysctl) PreflightChecks(log *viaduct.Logger) error {
 		return fmt.Errorf("name must be a plain filename, not a path: %s", s.Name)
 	}
 
-	if len(s.Values) == 0 {
+	if len(s.Values) == 0 && !s.Delete {
 		return fmt.Errorf("required parameter: Values")
 	}
 
-	if !viaduct.IsRoot() {
+	if !log.Runtime().IsRoot() {
 		return fmt.Errorf("sysctl resource must be run as root")
 	}
 
@@ -65,37 +83,56 @@ func (s *Sysctl) PreflightChecks(log *viaduct.Logger) error {
 }
 
 func (s *Sysctl) OperationName() string {
+	if s.Delete {
+		return "Delete"
+	}
+
 	return "Apply"
 }
 
-func (s *Sysctl) Run(log *viaduct.Logger) error {
-	if viaduct.Cli.DryRun {
-		log.Info("applied", "path", s.path)
+// Pruned returns the resource that deletes the configuration file once it is
+// taken out of the manifest
+func (s *Sysctl) Pruned() viaduct.ResourceAttributes {
+	if s.Delete {
 		return nil
 	}
 
+	return &Sysctl{Name: s.Name, Delete: true}
+}
+
+func (s *Sysctl) Run(log *viaduct.Logger) error {
+	if s.Delete {
+		return s.deleteSysctl(log)
+	}
+
 	content := s.content()
 
+	var existing string
 	changed := true
 	if viaduct.FileExists(s.path) {
-		if current, err := os.ReadFile(s.path); err == nil {
-			changed = string(current) != content
-		} else {
+		current, err := os.ReadFile(s.path)
+		if err != nil {
 			return err
 		}
+
+		existing = string(current)
+		changed = existing != content
 	}
 
 	if changed {
-		// Minimal systems may not have /etc/sysctl.d
-		if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
-			return err
-		}
-
-		if err := os.WriteFile(s.path, []byte(content), 0o644); err != nil {
-			return err
+		if !log.DryRun() {
+			// Minimal systems may not have /etc/sysctl.d
+			if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+				return err
+			}
+
+			if err := os.WriteFile(s.path, []byte(content), 0o644); err != nil {
+				return err
+			}
 		}
 
 		log.Info("created", "path", s.path)
+		log.Diff(s.path, existing, content)
 	} else {
 		log.Noop("up-to-date", "path", s.path)
 	}
@@ -105,7 +142,12 @@ func (s *Sysctl) Run(log *viaduct.Logger) error {
 		return nil
 	}
 
-	if err := runCommand("sysctl", "--system"); err != nil {
+	if log.DryRun() {
+		log.Info("applied", "path", s.path)
+		return nil
+	}
+
+	if err := runCommand(log, "sysctl", "--system"); err != nil {
 		return fmt.Errorf("sysctl --system failed: %w", err)
 	}
 
@@ -114,6 +156,24 @@ func (s *Sysctl) Run(log *viaduct.Logger) error {
 	return nil
 }
 
+// deleteSysctl removes the configuration file
+func (s *Sysctl) deleteSysctl(log *viaduct.Logger) error {
+	if !viaduct.FileExists(s.path) {
+		log.Noop("up-to-date", "path", s.path)
+		return nil
+	}
+
+	if !log.DryRun() {
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+	}
+
+	log.Info("deleted", "path", s.path)
+
+	return nil
+}
+
 func (s *Sysctl) content() string {
 	keys := make([]string, 0, len(s.Values))
 	for k := range s.Values {
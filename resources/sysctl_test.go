@@ -24,6 +24,16 @@ func TestSysctlPreflightChecks(t *testing.T) {
 		assert.EqualError(t, err, "required parameter: Values")
 	})
 
+	t.Run("delete needs no values", func(t *testing.T) {
+		s := &Sysctl{Name: "99-test", Delete: true}
+
+		// Only the root check stands between this and success
+		err := s.PreflightChecks(testLogger)
+		if err != nil {
+			assert.EqualError(t, err, "sysctl resource must be run as root")
+		}
+	})
+
 	t.Run("rejects path separators in name", func(t *testing.T) {
 		s := &Sysctl{Name: "../sudoers", Values: map[string]string{"vm.swappiness": "10"}}
 
@@ -45,3 +55,11 @@ func TestSysctlContent(t *testing.T) {
 	expected := "fs.file-max = 65536\nvm.max_map_count = 262144\nvm.swappiness = 10\n"
 	assert.Equal(t, expected, s.content())
 }
+
+func TestSysctlPruned(t *testing.T) {
+	s := &Sysctl{Name: "99-test", Values: map[string]string{"vm.swappiness": "10"}}
+
+	assert.Equal(t, &Sysctl{Name: "99-test", Delete: true}, s.Pruned())
+	assert.Equal(t, "Delete", s.Pruned().OperationName())
+	assert.Nil(t, (&Sysctl{Name: "99-test", Delete: true}).Pruned())
+}
@@ -28,6 +28,10 @@ type Template struct {
 	// already exist. The parent is created with 0755 and default ownership.
 	CreateDirIfMissing bool
 
+	// Sensitive keeps the rendered content out of the diff logged by a dry
+	// run, for a template that renders secrets.
+	Sensitive bool
+
 	// Permissions manages permissions for the rendered file
 	Permissions
 }
@@ -36,6 +40,16 @@ func (t *Template) Description() string {
 	return fmt.Sprintf("%s -> %s", t.Source, t.Dest)
 }
 
+// Identity is the path the template is written to.
+func (t *Template) Identity() string {
+	return t.Dest
+}
+
+// Claims claims the path the template is written to.
+func (t *Template) Claims() []viaduct.Claim {
+	return pathClaim(t.Dest, "")
+}
+
 func (t *Template) Params() *viaduct.ResourceParams {
 	return viaduct.NewResourceParams()
 }
@@ -56,7 +70,7 @@ func (t *Template) PreflightChecks(log *viaduct.Logger) error {
 		t.Mode = 0o644
 	}
 
-	return t.preflightPermissions(pfile)
+	return t.preflightPermissions(log, pfile)
 }
 
 func (t *Template) OperationName() string {
@@ -64,18 +78,7 @@ func (t *Template) OperationName() string {
 }
 
 func (t *Template) Run(log *viaduct.Logger) error {
-	if viaduct.Cli.DryRun {
-		if t.CreateDirIfMissing {
-			if err := ensureParentDir(log, viaduct.ExpandPath(t.Dest)); err != nil {
-				return err
-			}
-		}
-
-		log.Info("created", "path", viaduct.ExpandPath(t.Dest))
-		return nil
-	}
-
-	source := viaduct.ExpandPath(t.Source)
+	source := log.Runtime().ExpandPath(t.Source)
 	if !viaduct.FileExists(source) {
 		return fmt.Errorf("source template does not exist: %s", source)
 	}
@@ -88,7 +91,7 @@ func (t *Template) Run(log *viaduct.Logger) error {
 	// Writing goes through the same helper as the File resource, so a
 	// rendered template gets the same content comparison and permission
 	// handling
-	return writeManagedFile(log, t.Dest, content, &t.Permissions, t.CreateDirIfMissing)
+	return writeManagedFile(log, t.Dest, content, &t.Permissions, t.CreateDirIfMissing, t.Sensitive)
 }
 
 func (t *Template) render(source string) (string, error) {
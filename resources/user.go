@@ -42,6 +42,11 @@ func (u *User) Description() string {
 	return u.Name
 }
 
+// Identity is the name of the user.
+func (u *User) Identity() string {
+	return u.Name
+}
+
 func (u *User) Params() *viaduct.ResourceParams {
 	// useradd and friends lock the passwd and group databases, so avoid
 	// running alongside other resources that write them
@@ -61,7 +66,7 @@ func (u *User) PreflightChecks(log *viaduct.Logger) error {
 		u.Shell = "/bin/bash"
 	}
 
-	if !viaduct.IsRoot() {
+	if !log.Runtime().IsRoot() {
 		return fmt.Errorf("user resource must be run as root")
 	}
 
@@ -85,17 +90,17 @@ func (u *User) Run(log *viaduct.Logger) error {
 // create creates the group (if a GID is given), the user, and assigns
 // any supplementary groups
 func (u *User) create(log *viaduct.Logger) error {
-	if viaduct.Cli.DryRun {
+	if log.DryRun() {
 		log.Info("created", "user", u.Name)
 		return nil
 	}
 
 	if u.GID != 0 && !groupExists(u.Name) {
-		if err := runCommand("groupadd", "-g", strconv.Itoa(u.GID), u.Name); err != nil {
+		if err := runCommand(log, "groupadd", "-g", strconv.Itoa(u.GID), u.Name); err != nil {
 			return fmt.Errorf("groupadd failed for %s: %w", u.Name, err)
 		}
 
-		log.Info("created", "group", u.Name, "gid", strconv.Itoa(u.GID))
+		log.Info("created", "group", u.Name, "gid", u.GID)
 	}
 
 	args := []string{"useradd"}
@@ -118,7 +123,7 @@ func (u *User) create(log *viaduct.Logger) error {
 
 	args = append(args, "-s", u.Shell, u.Name)
 
-	if err := runCommand(args...); err != nil {
+	if err := runCommand(log, args...); err != nil {
 		return fmt.Errorf("useradd failed for %s: %w", u.Name, err)
 	}
 
@@ -144,12 +149,12 @@ func (u *User) update(log *viaduct.Logger, usr *user.User) error {
 		return nil
 	}
 
-	if viaduct.Cli.DryRun {
+	if log.DryRun() {
 		log.Info("groups-added", "user", u.Name, "groups", strings.Join(missing, ","))
 		return nil
 	}
 
-	if err := runCommand("usermod", "-a", "-G", strings.Join(missing, ","), u.Name); err != nil {
+	if err := runCommand(log, "usermod", "-a", "-G", strings.Join(missing, ","), u.Name); err != nil {
 		return fmt.Errorf("usermod failed for %s: %w", u.Name, err)
 	}
 
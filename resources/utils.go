@@ -1,6 +1,7 @@
 package resources
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"os"
@@ -10,10 +11,15 @@ import (
 	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/surminus/viaduct"
 )
 
+// commandWaitDelay bounds how long a killed command is waited on, in case
+// something it started is still holding its output open.
+const commandWaitDelay = 5 * time.Second
+
 // pathMutexes serialises resources that do read-modify-write on a shared
 // file, keyed by path. Resources run concurrently and the global lock is
 // too coarse, so this gives same-file edits their own lock while leaving
@@ -29,6 +35,26 @@ func lockPath(path string) func() {
 	return m.Unlock
 }
 
+// The states resources leave what they claim in. A resource that replaces
+// what it claims outright, such as a file with its content, leaves it in no
+// particular state, which conflicts with any other claim.
+const (
+	statePresent   = "present"
+	stateAbsent    = "absent"
+	stateDirectory = "directory"
+)
+
+// pathClaim claims a path, cleaned so that equivalent paths are the same
+// object. An empty path claims nothing, and fails the preflight checks
+// anyway.
+func pathClaim(path, state string) []viaduct.Claim {
+	if path == "" {
+		return nil
+	}
+
+	return []viaduct.Claim{{Object: "path " + filepath.Clean(path), State: state}}
+}
+
 // ensureParentDir creates the parent directory of the given path if it does
 // not already exist. It backs the CreateDirIfMissing option on the
 // path-writing resources, letting a resource make its own parent without a
@@ -44,7 +70,7 @@ func ensureParentDir(log *viaduct.Logger, path string) error {
 		return nil
 	}
 
-	if viaduct.Cli.DryRun {
+	if log.DryRun() {
 		log.Info("created-parent", "path", dir)
 		return nil
 	}
@@ -62,15 +88,16 @@ func ensureParentDir(log *viaduct.Logger, path string) error {
 // rendered template goes through the same create-or-update lifecycle as a
 // managed file, without either resource reaching into the other.
 //
-// The caller is responsible for having run preflight checks, since the mode
-// and ownership in perms are resolved there.
+// A dry run logs a diff of the content it would write, unless sensitive is
+// set. The caller is responsible for having run preflight checks, since the
+// mode and ownership in perms are resolved there.
 func writeManagedFile(
 	log *viaduct.Logger,
 	path, content string,
 	perms *Permissions,
-	createDirIfMissing bool,
+	createDirIfMissing, sensitive bool,
 ) error {
-	path = viaduct.ExpandPath(path)
+	path = log.Runtime().ExpandPath(path)
 
 	if createDirIfMissing {
 		if err := ensureParentDir(log, path); err != nil {
@@ -78,31 +105,40 @@ func writeManagedFile(
 		}
 	}
 
-	if viaduct.Cli.DryRun {
-		log.Info("created", "path", path)
-		return nil
-	}
+	exists := viaduct.FileExists(path)
 
-	shouldWriteFile := true
-	if viaduct.FileExists(path) {
-		existing, err := os.ReadFile(path)
+	var existing string
+	if exists {
+		current, err := os.ReadFile(path)
 		if err != nil {
 			return err
 		}
 
-		shouldWriteFile = string(existing) != content
+		existing = string(current)
 	}
 
-	if shouldWriteFile {
-		if err := os.WriteFile(path, []byte(content), perms.Mode); err != nil {
-			return err
+	if !exists || existing != content {
+		if !log.DryRun() {
+			if err := os.WriteFile(path, []byte(content), perms.Mode); err != nil {
+				return err
+			}
 		}
 
 		log.Info("created", "path", path)
+
+		if !sensitive {
+			log.Diff(path, existing, content)
+		}
 	} else {
 		log.Noop("up-to-date", "path", path)
 	}
 
+	// A file a dry run would have created has no permissions to compare
+	// against, and is created with the mode anyway
+	if log.DryRun() && !exists {
+		return nil
+	}
+
 	return perms.setFilePermissions(log, path)
 }
 
@@ -128,13 +164,34 @@ func fileOwnership(path string) (ownership, error) {
 	return ownership{uid: int(stat.Uid), gid: int(stat.Gid)}, nil
 }
 
-// runCommand runs a system command, directing output according to the
-// CLI flags
-func runCommand(args ...string) error {
+// runCommand runs a system command, capturing its output into the log and
+// copying it to the console according to the CLI flags
+func runCommand(log *viaduct.Logger, args ...string) error {
+	return runCommandContext(context.Background(), log, args...)
+}
+
+// runCommandContext is like runCommand, but kills the command once ctx is
+// done
+func runCommandContext(ctx context.Context, log *viaduct.Logger, args ...string) error {
+	cmd := commandContext(ctx, args...)
+	return log.Capture(cmd, true).Done(cmd.Run())
+}
+
+// commandContext builds a command that is killed once ctx is done, so a
+// resource that has run out of time stops rather than carrying on in the
+// background. The command runs in a process group of its own, and the whole
+// group is killed, so whatever it started goes with it, such as the commands
+// run by bash -c
+func commandContext(ctx context.Context, args ...string) *exec.Cmd {
 	// nolint:gosec
-	cmd := exec.Command(args[0], args[1:]...)
-	setCommandOutput(cmd)
-	return cmd.Run()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.WaitDelay = commandWaitDelay
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	return cmd
 }
 
 // Permissions can be used with some resources to manage how they set
@@ -166,7 +223,7 @@ const (
 	DefaultFilePermissions      fs.FileMode = 0o644
 )
 
-func (p *Permissions) preflightPermissions(t ptype) error {
+func (p *Permissions) preflightPermissions(log *viaduct.Logger, t ptype) error {
 	if p.Mode == 0 {
 		if t == pdir {
 			p.Mode = DefaultDirectoryPermissions
@@ -183,7 +240,7 @@ func (p *Permissions) preflightPermissions(t ptype) error {
 	}
 
 	if p.User == "" && p.UID == 0 && !p.Root {
-		if uid, err := strconv.Atoi(viaduct.Attribute.User.Uid); err != nil {
+		if uid, err := strconv.Atoi(log.Runtime().Attributes.User.Uid); err != nil {
 			return err
 		} else {
 			p.UID = uid
@@ -191,7 +248,7 @@ func (p *Permissions) preflightPermissions(t ptype) error {
 	}
 
 	if p.Group == "" && p.GID == 0 && !p.Root {
-		if gid, err := strconv.Atoi(viaduct.Attribute.User.Gid); err != nil {
+		if gid, err := strconv.Atoi(log.Runtime().Attributes.User.Gid); err != nil {
 			return err
 		} else {
 			p.GID = gid
@@ -240,8 +297,10 @@ func applyChmod(log *viaduct.Logger, path string, mode os.FileMode) error {
 		return nil
 	}
 
-	if err := os.Chmod(path, mode); err != nil {
-		return err
+	if !log.DryRun() {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
 	}
 
 	log.Info("chmod", "path", path, "mode", mode.String())
@@ -250,15 +309,17 @@ func applyChmod(log *viaduct.Logger, path string, mode os.FileMode) error {
 
 func applyChown(log *viaduct.Logger, path string, uid, gid int) error {
 	if viaduct.MatchChown(path, uid, gid) {
-		log.Noop("chown-unchanged", "path", path, "uid", strconv.Itoa(uid), "gid", strconv.Itoa(gid))
+		log.Noop("chown-unchanged", "path", path, "uid", uid, "gid", gid)
 		return nil
 	}
 
-	if err := os.Chown(path, uid, gid); err != nil {
-		return err
+	if !log.DryRun() {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
 	}
 
-	log.Info("chown", "path", path, "uid", strconv.Itoa(uid), "gid", strconv.Itoa(gid))
+	log.Info("chown", "path", path, "uid", uid, "gid", gid)
 	return nil
 }
 
@@ -291,15 +352,19 @@ func (p *Permissions) setDirectoryPermissions(
 			}
 
 			wasUpdated = true
+			if log.DryRun() {
+				break
+			}
+
 			if err := os.Chown(f, uid, gid); err != nil {
 				return err
 			}
 		}
 
 		if wasUpdated {
-			log.Info("chown-recursive", "path", path, "uid", strconv.Itoa(uid), "gid", strconv.Itoa(gid))
+			log.Info("chown-recursive", "path", path, "uid", uid, "gid", gid)
 		} else {
-			log.Noop("chown-recursive-unchanged", "path", path, "uid", strconv.Itoa(uid), "gid", strconv.Itoa(gid))
+			log.Noop("chown-recursive-unchanged", "path", path, "uid", uid, "gid", gid)
 		}
 	} else {
 		if err := applyChown(log, path, uid, gid); err != nil {
@@ -0,0 +1,116 @@
+package viaduct
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// defaultRetries is how many times a Retryable resource is retried before
+	// it fails.
+	defaultRetries = 2
+
+	// defaultRetryBackoff is how long to wait before the first retry. It
+	// doubles for each retry after that.
+	defaultRetryBackoff = time.Second
+)
+
+// WithRetry tries the resource up to attempts times before it fails, waiting
+// backoff before the first retry and twice as long before each one after. A
+// backoff of zero uses the default of a second.
+//
+// Resources that mark themselves Retryable, such as Download, Git and Apt in
+// the resources package, are already tried three times. Use this to retry
+// anything else that fails now and again, or WithRetry(r, 1, 0) to stop a
+// resource being retried at all. The --retries flag overrides it.
+func (m *Manifest) WithRetry(r *Resource, attempts int, backoff time.Duration) {
+	if v, ok := m.resources[r.ResourceID]; ok {
+		v.Attempts = attempts
+		v.RetryBackoff = backoff
+		m.resources[r.ResourceID] = v
+	}
+}
+
+// attemptsFor returns how many times a resource is tried. The --retries flag
+// wins for anything that would be retried otherwise, then the resource, then
+// whether it is Retryable. A negative --retries turns retries off altogether.
+func (m *Manifest) attemptsFor(r *Resource) int {
+	attempts := 1
+
+	switch {
+	case r.Attempts > 0:
+		attempts = r.Attempts
+	case r.Attributes.Params().Retryable:
+		attempts = defaultRetries + 1
+	}
+
+	switch {
+	case m.rt.Options.Retries < 0:
+		return 1
+	case m.rt.Options.Retries > 0 && (attempts > 1 || r.Attempts > 0):
+		return m.rt.Options.Retries + 1
+	default:
+		return attempts
+	}
+}
+
+// backoffFor returns how long to wait before the first retry of a resource.
+func (m *Manifest) backoffFor(r *Resource) time.Duration {
+	if r.RetryBackoff > 0 {
+		return r.RetryBackoff
+	}
+
+	return defaultRetryBackoff
+}
+
+// AttemptResult is one attempt at running a resource: how long it took, and
+// the error it failed with if it did.
+type AttemptResult struct {
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// runAttempts runs a resource until it succeeds or runs out of attempts,
+// returning each attempt it took. Every attempt logs to the same logger, so a
+// failed attempt is still in the resource's log entries once it succeeds.
+//
+// A resource the run gave up on is never retried, since it may still be
+// running, and nothing is retried once the run has given up on anything or
+// --fail-fast has stopped it.
+func (m *Manifest) runAttempts(ctx context.Context, r *Resource, log *Logger) ([]AttemptResult, error) {
+	attempts := m.attemptsFor(r)
+	backoff := m.backoffFor(r)
+
+	var results []AttemptResult
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := r.run(ctx, log, m.timeoutFor(r))
+
+		result := AttemptResult{Duration: roundDuration(time.Since(start)).String()}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+
+		if err == nil || attempt >= attempts || errors.Is(err, errAbandoned) || m.abandonedErr() != nil || m.stopped() || ctx.Err() != nil {
+			return results, err
+		}
+
+		log.Warn("retrying",
+			"attempt", attempt,
+			"attempts", attempts,
+			"backoff", backoff,
+			"error", err.Error(),
+		)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return results, err
+		}
+
+		backoff *= 2
+	}
+}
@@ -0,0 +1,178 @@
+package viaduct
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyResourceType is a test resource that fails a number of times before
+// it succeeds
+type flakyResourceType struct {
+	testResourceType
+	failures  int32
+	retryable bool
+}
+
+func (f *flakyResourceType) Params() *ResourceParams {
+	return &ResourceParams{Retryable: f.retryable}
+}
+
+func (f *flakyResourceType) Run(log *Logger) error {
+	if f.runs.Add(1) <= f.failures {
+		return errors.New("flaky")
+	}
+
+	return nil
+}
+
+func newFlakyResource(value string, failures int32) *flakyResourceType {
+	return &flakyResourceType{testResourceType: testResourceType{Value: value}, failures: failures}
+}
+
+func TestAttemptsFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		retries   int
+		attempts  int
+		retryable bool
+		expected  int
+	}{
+		{"tried once by default", 0, 0, false, 1},
+		{"retryable resources are retried", 0, 0, true, defaultRetries + 1},
+		{"set on the resource", 0, 5, false, 5},
+		{"the resource wins over retryable", 0, 1, true, 1},
+		{"the flag wins", 4, 2, false, 5},
+		{"the flag wins over retryable", 4, 0, true, 5},
+		{"the flag does not retry anything else", 4, 0, false, 1},
+		{"a negative flag turns retries off", -1, 5, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Cli.SetRetries(tt.retries)
+			defer Cli.SetRetries(0)
+
+			r := &Resource{Attempts: tt.attempts, Attributes: &flakyResourceType{retryable: tt.retryable}}
+			assert.Equal(t, tt.expected, New().attemptsFor(r))
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	t.Run("succeeds on a later attempt", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := newFlakyResource("a", 2)
+		r := m.Add(a)
+		m.WithRetry(r, 3, time.Millisecond)
+		m.collector = newResultCollector()
+
+		newScheduler(m, 0).run()
+
+		assert.Equal(t, Success, m.resources[r.ResourceID].Status)
+		assert.Equal(t, int32(3), a.runs.Load())
+
+		results := m.collector.Results()
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, 3, results[0].Attempts)
+		}
+	})
+
+	t.Run("each attempt is in the result", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		r := m.Add(newFlakyResource("a", 1))
+		m.WithRetry(r, 3, time.Millisecond)
+		m.collector = newResultCollector()
+
+		newScheduler(m, 0).run()
+
+		results := m.collector.Results()
+		if assert.Len(t, results, 1) && assert.Len(t, results[0].AttemptLog, 2) {
+			assert.Equal(t, "flaky", results[0].AttemptLog[0].Error)
+			assert.NotEmpty(t, results[0].AttemptLog[0].Duration)
+			assert.Empty(t, results[0].AttemptLog[1].Error, "the second attempt succeeded")
+		}
+
+		out, err := json.Marshal(results[0])
+		assert.NoError(t, err)
+		assert.Contains(t, string(out), `"attempt_log":[{"duration":"`)
+		assert.Contains(t, string(out), `"error":"flaky"}`)
+	})
+
+	t.Run("every attempt is logged", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		r := m.Add(newFlakyResource("a", 2))
+		m.WithRetry(r, 3, time.Millisecond)
+
+		log := &Logger{jsonMode: true}
+		res := m.resources[r.ResourceID]
+		attempts, err := m.runAttempts(context.Background(), &res, log)
+		assert.NoError(t, err)
+		assert.Len(t, attempts, 3)
+
+		var retries []any
+		for _, entry := range log.Entries() {
+			if entry.Message == "retrying" {
+				retries = append(retries, entry.Fields["attempt"])
+			}
+		}
+		assert.Equal(t, []any{int64(1), int64(2)}, retries)
+	})
+
+	t.Run("fails once out of attempts", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := newFlakyResource("a", 5)
+		r := m.Add(a)
+		m.WithRetry(r, 2, time.Millisecond)
+
+		newScheduler(m, 0).run()
+
+		assert.Equal(t, Failed, m.resources[r.ResourceID].Status)
+		assert.Equal(t, int32(2), a.runs.Load())
+	})
+
+	t.Run("backs off between attempts", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		r := m.Add(newFlakyResource("a", 2))
+		m.WithRetry(r, 3, 20*time.Millisecond)
+
+		start := time.Now()
+		newScheduler(m, 0).run()
+
+		// 20ms, then 40ms
+		assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+	})
+
+	t.Run("an abandoned resource is not retried", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := newBlockingTestResource("a")
+		defer a.release()
+
+		r := m.Add(a)
+		m.WithRetry(r, 3, time.Millisecond)
+		m.WithTimeout(r, 20*time.Millisecond)
+
+		res := m.resources[r.ResourceID]
+		attempts, err := m.runAttempts(context.Background(), &res, NewSilentLogger())
+		assert.ErrorIs(t, err, errAbandoned)
+		assert.Len(t, attempts, 1)
+	})
+}
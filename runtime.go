@@ -0,0 +1,243 @@
+package viaduct
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Runtime is what a run needs to know beyond the manifest itself: the options
+// it was asked to run with, and the attributes of the machine it is running
+// on. Every resource gets it through its Logger.
+//
+// A standalone binary uses the default runtime, which is the package-level Cli
+// and Attribute read by Init. A program that embeds viaduct creates its own
+// with NewRuntime, so nothing is read from its command line.
+type Runtime struct {
+	Options    *Options
+	Attributes *SystemAttributes
+
+	// mu guards the sinks, which are read by every Logger.
+	mu sync.Mutex
+
+	// replacement is used in place of the console once set with SetSinks,
+	// and added goes alongside whatever else there is.
+	replacement []Sink
+	replaced    bool
+	added       []Sink
+
+	// logFile is the sink for Options.LogFile, opened the first time it is
+	// needed.
+	logFile     *FileSink
+	logFileErr  error
+	logFileOnce sync.Once
+
+	// stream is where run events go with --output=jsonl.
+	stream *eventStream
+}
+
+// defaultRuntime is the runtime made of the package-level Cli and Attribute.
+// It points at them rather than copying them, so setting an option on Cli
+// still applies to a manifest that already exists.
+var defaultRuntime = &Runtime{Options: &Cli, Attributes: &Attribute}
+
+// DefaultRuntime returns the runtime made of the package-level Cli and
+// Attribute, calling Init first if nothing has yet.
+func DefaultRuntime() *Runtime {
+	Init()
+	return defaultRuntime
+}
+
+// helperRuntime returns the default runtime for the package-level helpers,
+// such as ExpandPath and NewLogger. The attributes of the machine are filled
+// in, but Init is not called, so a helper never reads the command line of a
+// program that has flags of its own. The options are whatever Cli holds.
+func helperRuntime() *Runtime {
+	initDefaultAttributes()
+	return defaultRuntime
+}
+
+// NewRuntime returns a runtime with the given options, reading the attributes
+// of the machine and creating a temporary directory for the run. Unlike Init,
+// it does not read the command line.
+func NewRuntime(opts Options) (*Runtime, error) {
+	attributes, err := newAttributes()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Runtime{Options: &opts, Attributes: &attributes}, nil
+}
+
+// NewLogger returns a logger for a resource that logs according to the
+// runtime's options.
+func (rt *Runtime) NewLogger(resource, action string) *Logger {
+	var l *Logger
+
+	switch {
+	case rt.Options.JSON:
+		l = &Logger{Resource: resource, Action: action, jsonMode: true}
+	case rt.Options.Silent:
+		l = NewSilentLogger()
+	case rt.Options.Quiet:
+		l = NewQuietLogger(resource, action)
+	default:
+		l = NewStandardLogger(resource, action)
+	}
+
+	l.rt = rt
+	// A test report has what each resource logged, however the run is
+	// reported on the console
+	l.buffered = len(rt.Options.Reports) > 0
+
+	return l
+}
+
+// SetSinks sets where the run's log events go, in place of the console. The
+// console can be kept among them with ConsoleSink.
+func (rt *Runtime) SetSinks(sinks ...Sink) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.replacement = sinks
+	rt.replaced = true
+}
+
+// AddSink sends the run's log events to s as well as wherever else they go,
+// which by default is the console.
+func (rt *Runtime) AddSink(s Sink) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.added = append(rt.added, s)
+}
+
+// ConsoleSink returns the console the run logs to by default, writing to
+// STDERR, or STDOUT with the Stdout option for everything but warnings and
+// errors.
+func (rt *Runtime) ConsoleSink() *ConsoleSink {
+	return NewConsoleSink(rt.infoWriter(), os.Stderr)
+}
+
+// sinks returns where log events go: the sinks given with SetSinks, or
+// otherwise the console, followed by the log file and any sinks added with
+// AddSink. In JSON mode the console is left out by default, since what is
+// logged is in the report, and so it is when streaming run events, which
+// include what is logged.
+func (rt *Runtime) sinks(jsonMode bool) []Sink {
+	var out []Sink
+
+	stream := rt.events()
+	if stream != nil {
+		out = append(out, stream)
+	}
+
+	rt.mu.Lock()
+	out = append(out, rt.replacement...)
+	added := rt.added
+	rt.mu.Unlock()
+
+	if rt.onConsole(jsonMode) {
+		out = append(out, rt.ConsoleSink())
+	}
+
+	if f, err := rt.openLogFile(); err == nil && f != nil {
+		out = append(out, f)
+	}
+
+	return append(out, added...)
+}
+
+// onConsole says whether what the run logs goes to the console, which it does
+// unless the console has been replaced with SetSinks, or the run is reporting
+// in JSON.
+func (rt *Runtime) onConsole(jsonMode bool) bool {
+	if jsonMode || rt.Options.Output == OutputJSONL {
+		return false
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return !rt.replaced
+}
+
+// openLogFile opens the file given with the LogFile option, once. It returns
+// nil when there is no log file.
+func (rt *Runtime) openLogFile() (*FileSink, error) {
+	if rt.Options.LogFile == "" {
+		return nil, nil
+	}
+
+	rt.logFileOnce.Do(func() {
+		rt.logFile, rt.logFileErr = NewFileSink(rt.ExpandPath(rt.Options.LogFile))
+	})
+
+	return rt.logFile, rt.logFileErr
+}
+
+// infoWriter returns the destination for non-error output on the console.
+func (rt *Runtime) infoWriter() io.Writer {
+	if rt.Options.Stdout {
+		return os.Stdout
+	}
+
+	return os.Stderr
+}
+
+// ExpandPath ensures that "~" is expanded to the home directory of the user
+// in the runtime's attributes.
+func (rt *Runtime) ExpandPath(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+
+	p, err := filepath.Abs(strings.Replace(path, "~", rt.Attributes.User.HomeDir, 1))
+	if err != nil {
+		return path
+	}
+
+	return p
+}
+
+// IsRoot reports whether the run is running as root.
+func (rt *Runtime) IsRoot() bool {
+	return rt.Attributes.runuser.Username == "root"
+}
+
+// IsUbuntu reports whether the run is on Ubuntu, or a distribution based on
+// it such as Linux Mint.
+func (rt *Runtime) IsUbuntu() bool {
+	platform := rt.Attributes.Platform
+	return strings.Contains(platform.ID, "ubuntu") || strings.Contains(platform.IDLike, "ubuntu")
+}
+
+// SetUser sets the user that paths starting with "~" belong to, logging it
+// according to the runtime's options.
+func (rt *Runtime) SetUser(username string) {
+	rt.Attributes.setUser(rt.NewLogger("Attribute", "Set"), username)
+}
+
+// CommandTrue runs a command with bash, returning true if it exits cleanly.
+// Its output goes to the console, unless the runtime is quiet or silent.
+func (rt *Runtime) CommandTrue(command string) bool {
+	// nolint:gosec
+	cmd := exec.Command("bash", "-c", command)
+	if rt.Options.Quiet {
+		cmd.Stderr = os.Stderr
+	} else if !rt.Options.Silent {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+
+	return cmd.Run() == nil
+}
+
+// TmpFile returns the path for a temporary file in the run's temporary
+// directory.
+func (rt *Runtime) TmpFile(path string) string {
+	return filepath.Join(rt.Attributes.TmpDir, path)
+}
@@ -0,0 +1,128 @@
+package viaduct
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dryRunResourceType is a test resource that records whether its logger said
+// the run was a dry run
+type dryRunResourceType struct {
+	testResourceType
+	dryRun bool
+}
+
+func (d *dryRunResourceType) Run(log *Logger) error {
+	d.dryRun = log.DryRun()
+	return nil
+}
+
+func TestNewRuntime(t *testing.T) {
+	rt, err := NewRuntime(Options{DryRun: true, Quiet: true})
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(rt.Attributes.TmpDir) })
+
+	assert.True(t, rt.Options.DryRun)
+	assert.True(t, DirExists(rt.Attributes.TmpDir))
+	assert.NotEmpty(t, rt.Attributes.User.HomeDir)
+
+	t.Run("leaves the default runtime alone", func(t *testing.T) {
+		assert.False(t, DefaultRuntime().Options.DryRun)
+		assert.NotEqual(t, DefaultRuntime().Attributes.TmpDir, rt.Attributes.TmpDir)
+	})
+
+	t.Run("loggers use its options", func(t *testing.T) {
+		l := rt.NewLogger("Test", "Test")
+		assert.True(t, l.Quiet)
+		assert.True(t, l.DryRun())
+		assert.Same(t, rt, l.Runtime())
+	})
+
+	t.Run("a logger without one uses the default", func(t *testing.T) {
+		assert.Same(t, DefaultRuntime(), NewStandardLogger("Test", "Test").Runtime())
+	})
+}
+
+// homeRuntime returns a runtime for a user whose home directory is home
+func homeRuntime(home string) *Runtime {
+	rt := &Runtime{Options: &Options{Silent: true}, Attributes: &SystemAttributes{}}
+	rt.Attributes.User.HomeDir = home
+
+	return rt
+}
+
+func TestRuntimeExpandPath(t *testing.T) {
+	rt := &Runtime{Attributes: &SystemAttributes{}}
+	rt.Attributes.User.HomeDir = "/home/test"
+
+	assert.Equal(t, "/home/test/.bashrc", rt.ExpandPath("~/.bashrc"))
+	assert.Equal(t, "/etc/hosts", rt.ExpandPath("/etc/hosts"))
+	assert.Equal(t, "/tmp/test/file", (&Runtime{Attributes: &SystemAttributes{TmpDir: "/tmp/test"}}).TmpFile("file"))
+}
+
+func TestNewWithRuntime(t *testing.T) {
+	rt, err := NewRuntime(Options{DryRun: true, Silent: true})
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(rt.Attributes.TmpDir) })
+
+	m := NewWithRuntime(rt)
+	assert.Same(t, rt, m.Runtime())
+
+	m.SetStatePath(filepath.Join(t.TempDir(), "state.json"))
+	d := &dryRunResourceType{testResourceType: testResourceType{Value: "dry"}}
+	m.Add(d)
+
+	_, err = m.Apply(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, d.dryRun)
+}
+
+func TestInitKeepsPresetValues(t *testing.T) {
+	t.Run("options", func(t *testing.T) {
+		quiet, concurrency := true, 0
+		setOption(&quiet, false)
+		setOption(&concurrency, 4)
+		assert.True(t, quiet)
+		assert.Equal(t, 4, concurrency)
+	})
+
+	t.Run("attributes", func(t *testing.T) {
+		a := SystemAttributes{Hostname: "preset", TmpDir: t.TempDir()}
+		initAttributes(&a)
+		assert.Equal(t, "preset", a.Hostname)
+		assert.NotEmpty(t, a.User.Username)
+	})
+}
+
+func TestHelpersLeaveTheCommandLineAlone(t *testing.T) {
+	if os.Getenv("VIADUCT_TEST_HELPERS") == "1" {
+		// A program with flags of its own, which the default runtime doesn't
+		// know about and would exit on
+		os.Args = []string{"host", "--host", "example.com"}
+		Cli.SetSilent()
+
+		ExpandPath("~/.bashrc")
+		IsRoot()
+		IsUbuntu()
+		TmpFile("file")
+		CommandTrue("true")
+		NewLogger("Test", "Test").Info("logged")
+		NewStandardLogger("Test", "Test").Runtime()
+		Log("logged")
+		Attribute.SetUser(Attribute.User.Username)
+
+		os.Exit(0)
+	}
+
+	// nolint:gosec
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelpersLeaveTheCommandLineAlone$")
+	cmd.Env = append(os.Environ(), "VIADUCT_TEST_HELPERS=1")
+
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
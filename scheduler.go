@@ -0,0 +1,399 @@
+package viaduct
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// scheduler runs the resources in a manifest in dependency order.
+//
+// Rather than every resource waiting for its dependencies to finish, a
+// resource is only queued once everything it depends on has reached a
+// terminal status, and finishing is what releases whatever was waiting on it.
+// Queued resources are started as there is room under the concurrency, so a
+// manifest of thousands of resources only ever has a goroutine for each
+// resource that is actually running, and nothing polls.
+type scheduler struct {
+	m *Manifest
+
+	// ctx stops anything else from starting once it is done, and is the
+	// parent of the context each resource runs with.
+	ctx context.Context
+
+	// lock guards the resources in the manifest, which are updated as each
+	// one finishes.
+	lock sync.RWMutex
+
+	// locks serialise resources that share a lock key.
+	locks *lockSet
+
+	// limit is how many resources run at once. Zero or less means no limit.
+	limit int
+
+	// started is when the run started, which is what resources count how
+	// long they waited for their dependencies from.
+	started time.Time
+
+	// mu guards everything below, and is held while taking or releasing a
+	// lock so that a resource parking behind a lock can't miss its release.
+	mu sync.Mutex
+
+	// waiting counts the dependencies each resource is still waiting on.
+	waiting map[ResourceID]int
+
+	// dependents lists the resources waiting on each resource.
+	dependents map[ResourceID][]ResourceID
+
+	// ready is when each resource stopped waiting on its dependencies.
+	ready map[ResourceID]time.Time
+
+	// queued is when each resource that asks for a lock first tried to take
+	// it.
+	queued map[ResourceID]time.Time
+
+	// queue holds the resources that are ready to start, in the order they
+	// start.
+	queue []ResourceID
+
+	// parked holds the resources waiting for a lock that something else
+	// holds. They go back on the queue when a lock is released.
+	parked []ResourceID
+
+	// running counts the resources that have been started and not finished
+	// or parked.
+	running int
+
+	wg sync.WaitGroup
+}
+
+// newScheduler creates a scheduler for the manifest that runs at most
+// concurrency resources at once. Zero or less means no limit.
+func newScheduler(m *Manifest, concurrency int) *scheduler {
+	return &scheduler{
+		m:          m,
+		ctx:        context.Background(),
+		locks:      newLockSet(),
+		limit:      concurrency,
+		waiting:    make(map[ResourceID]int, len(m.resources)),
+		dependents: make(map[ResourceID][]ResourceID, len(m.resources)),
+		ready:      make(map[ResourceID]time.Time, len(m.resources)),
+		queued:     make(map[ResourceID]time.Time),
+	}
+}
+
+// run applies every resource in the manifest, returning once they have all
+// reached a terminal status. Anything being pruned is removed first, so a
+// resource that replaces it starts from a clean slate. Handlers are held back
+// until everything else has finished, so each one runs at most once however
+// many resources notify it.
+func (s *scheduler) run() {
+	s.started = time.Now()
+
+	var pruners, resources, handlers []ResourceID
+
+	for id, r := range s.m.resources {
+		switch {
+		case s.m.pruners[id]:
+			pruners = append(pruners, id)
+		case r.Handler():
+			handlers = append(handlers, id)
+		default:
+			resources = append(resources, id)
+		}
+	}
+
+	s.runPhase(pruners)
+	s.runPhase(resources)
+	s.runPhase(handlers)
+}
+
+// runPhase applies a set of resources, returning once they have all reached a
+// terminal status. Anything outside the set is either finished already or not
+// in the manifest at all, so it is not waited on.
+func (s *scheduler) runPhase(ids []ResourceID) {
+	phase := make(map[ResourceID]bool, len(ids))
+	for _, id := range ids {
+		phase[id] = true
+	}
+
+	s.mu.Lock()
+
+	for _, id := range ids {
+		for _, dep := range s.m.resources[id].edges() {
+			if !phase[dep] {
+				continue
+			}
+
+			s.waiting[id]++
+			s.dependents[dep] = append(s.dependents[dep], id)
+		}
+	}
+
+	var ready []ResourceID
+	for _, id := range ids {
+		if s.waiting[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+
+	// Queue in a stable order, so the same manifest is released the same way
+	// each run
+	s.enqueue(ready)
+	s.dispatch()
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// enqueue queues resources that are no longer waiting on anything, noting
+// when they became ready. It must be called with mu held.
+func (s *scheduler) enqueue(ids []ResourceID) {
+	now := time.Now()
+
+	for _, id := range sortedIDs(ids) {
+		s.ready[id] = now
+		s.queue = append(s.queue, id)
+	}
+}
+
+// dispatch starts queued resources for as long as there is room under the
+// limit. It must be called with mu held.
+func (s *scheduler) dispatch() {
+	for len(s.queue) > 0 && (s.limit <= 0 || s.running < s.limit) {
+		id := s.queue[0]
+		s.queue = s.queue[1:]
+
+		s.running++
+		s.wg.Add(1)
+
+		go s.start(id, s.ready[id])
+	}
+}
+
+// start applies a resource, then releases its dependents and starts whatever
+// there is now room for. A resource that parks behind a lock is started again
+// once the lock is released, so it is only finished once it has run.
+func (s *scheduler) start(id ResourceID, ready time.Time) {
+	defer s.wg.Done()
+
+	s.lock.RLock()
+	r := s.m.resources[id]
+	s.lock.RUnlock()
+
+	r.timing.DependencyWait = ready.Sub(s.started)
+	done := s.apply(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running--
+
+	if done {
+		s.finish(id)
+	}
+
+	s.dispatch()
+}
+
+// finish queues any dependents of a resource that are no longer waiting on
+// anything. It must be called with mu held.
+func (s *scheduler) finish(id ResourceID) {
+	var ready []ResourceID
+
+	for _, dep := range s.dependents[id] {
+		s.waiting[dep]--
+
+		if s.waiting[dep] == 0 {
+			ready = append(ready, dep)
+		}
+	}
+
+	s.enqueue(ready)
+}
+
+// acquire takes the lock a resource asks for if it is free, returning the
+// function that releases it. Otherwise the resource is parked until whatever
+// holds the lock releases it, so a resource queueing behind a lock key doesn't
+// take up room that unrelated work could run in.
+func (s *scheduler) acquire(r *Resource) (func(), bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued, ok := s.queued[r.ResourceID]
+	if !ok {
+		queued = time.Now()
+		s.queued[r.ResourceID] = queued
+	}
+
+	release, ok := s.locks.tryAcquire(r.LockKey)
+	if !ok {
+		s.parked = append(s.parked, r.ResourceID)
+		return nil, false
+	}
+
+	r.timing.LockWait = time.Since(queued)
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		release()
+
+		// Whatever was parked goes ahead of anything queued since, as it
+		// has been ready for longer
+		s.queue = append(s.parked, s.queue...)
+		s.parked = nil
+		s.dispatch()
+	}, true
+}
+
+// apply runs a single resource whose dependencies have all finished. It
+// returns false if the resource was parked behind a lock rather than run.
+func (s *scheduler) apply(r Resource) bool {
+	m := s.m
+
+	// What is left out of the run is still reported, so it is clear it did
+	// not run rather than missing
+	if m.deselected[r.ResourceID] {
+		m.skip(&r, &s.lock)
+		return true
+	}
+
+	if err := m.abandonedErr(); err != nil {
+		m.fail(&r, &s.lock, DependencyFailed, err)
+		return true
+	}
+
+	if err := s.ctx.Err(); err != nil {
+		m.fail(&r, &s.lock, DependencyFailed, fmt.Errorf("not started: %w", err))
+		return true
+	}
+
+	if err := m.dependencyCheck(&r, &s.lock); err != nil {
+		m.fail(&r, &s.lock, DependencyFailed, err)
+		return true
+	}
+
+	if r.Handler() && !m.notified(&r, &s.lock) {
+		m.skip(&r, &s.lock)
+		return true
+	}
+
+	// With --fail-fast nothing new starts once something has failed, and
+	// what never started has not failed itself
+	if m.stopped() {
+		m.skip(&r, &s.lock)
+		return true
+	}
+
+	if r.GlobalLock {
+		release, ok := s.acquire(&r)
+		if !ok {
+			return false
+		}
+		defer release()
+	}
+
+	// The lock is released when a resource is abandoned, because holding it
+	// would block everything sharing the key for the rest of the run. That
+	// means whatever was waiting for it has to check again: an abandoned
+	// operation still holds the real lock, dpkg's or passwd's, so starting the
+	// next one now would just fail against it.
+	if err := m.abandonedErr(); err != nil {
+		m.fail(&r, &s.lock, DependencyFailed, err)
+		return true
+	}
+
+	if m.stopped() {
+		m.skip(&r, &s.lock)
+		return true
+	}
+
+	if err := s.ctx.Err(); err != nil {
+		m.fail(&r, &s.lock, DependencyFailed, fmt.Errorf("not started: %w", err))
+		return true
+	}
+
+	// Guards are evaluated as late as possible, so they see what everything
+	// the resource waited for has done
+	guard, out, err := r.guardedOut(s.ctx, m.timeoutFor(&r))
+	if err != nil {
+		m.fail(&r, &s.lock, Failed, err)
+		m.failFast(&r)
+		return true
+	}
+
+	if out {
+		logger := m.resourceLogger(&r)
+		logger.Noop("guarded", "guard", guard)
+
+		m.skipLogged(&r, &s.lock, logger)
+		return true
+	}
+
+	// Run the resource operation, bounded by its own timeout on each attempt
+	logger := m.resourceLogger(&r)
+	m.emit(RunEvent{
+		Type:         EventResourceStarted,
+		ResourceID:   r.ResourceID,
+		ResourceKind: r.ResourceKind,
+		Operation:    r.Attributes.OperationName(),
+		Description:  r.Attributes.Description(),
+	})
+
+	r.timing.Started = time.Now()
+	attempts, runErr := m.runAttempts(s.ctx, &r, logger)
+	duration := time.Since(r.timing.Started)
+	if runErr != nil {
+		if errors.Is(runErr, errAbandoned) {
+			// The operation is still going and the machine is in a state we no
+			// longer know, so nothing else should start. A cancelled operation
+			// has stopped, so it fails on its own like any other error
+			id := r.ResourceID
+			m.abandoned.CompareAndSwap(nil, &id)
+		}
+
+		m.setStatus(&r, &s.lock, Failed)
+		m.setError(&r, &s.lock, runErr)
+		m.failFast(&r)
+
+		if r.IgnoreFailure {
+			logger.Warn("failure-ignored", "error", runErr.Error())
+		}
+	} else {
+		m.setStatus(&r, &s.lock, Success)
+	}
+
+	// A failed resource may well have changed something before it failed, so
+	// the outcome is recorded either way
+	outcome := logger.Outcome()
+	m.setOutcome(&r, &s.lock, outcome)
+
+	status := string(Success)
+	errMsg := ""
+	if runErr != nil {
+		status = string(Failed)
+		errMsg = runErr.Error()
+	}
+
+	m.record(ResourceResult{
+		ResourceID:   string(r.ResourceID),
+		ResourceKind: string(r.ResourceKind),
+		Description:  r.Attributes.Description(),
+		Operation:    r.Attributes.OperationName(),
+		Status:       status,
+		Outcome:      string(outcome),
+		Attempts:     len(attempts),
+		AttemptLog:   attempts,
+		Duration:     roundDuration(duration).String(),
+		Timing:       r.timing,
+		Error:        errMsg,
+		Ignored:      runErr != nil && r.IgnoreFailure,
+		Log:          logger.Entries(),
+	})
+
+	return true
+}
@@ -0,0 +1,329 @@
+package viaduct
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingResourceType records how many resources of its kind are running at
+// once
+type countingResourceType struct {
+	Value string
+
+	running *atomic.Int32
+	peak    *atomic.Int32
+}
+
+func (c *countingResourceType) Description() string           { return c.Value }
+func (c *countingResourceType) OperationName() string         { return "Count" }
+func (c *countingResourceType) Params() *ResourceParams       { return NewResourceParams() }
+func (c *countingResourceType) PreflightChecks(*Logger) error { return nil }
+
+func (c *countingResourceType) Run(log *Logger) error {
+	n := c.running.Add(1)
+	defer c.running.Add(-1)
+
+	for {
+		peak := c.peak.Load()
+		if n <= peak || c.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	return nil
+}
+
+func TestScheduler(t *testing.T) {
+	t.Run("starts a dependent only once its dependency has finished", func(t *testing.T) {
+		slow := newBlockingTestResource("a")
+		after := newTestResource("b")
+
+		m := New()
+		a := m.Add(slow)
+		b := m.Add(after, a)
+
+		done := make(chan struct{})
+		go func() {
+			newScheduler(m, 0).run()
+			close(done)
+		}()
+
+		assert.Eventually(t, slow.ran.Load, time.Second, time.Millisecond)
+		assert.False(t, after.ran.Load())
+
+		slow.release()
+		<-done
+
+		assert.True(t, after.ran.Load())
+		assert.Equal(t, Success, m.resources[a.ResourceID].Status)
+		assert.Equal(t, Success, m.resources[b.ResourceID].Status)
+	})
+
+	t.Run("fails everything downstream of a failure", func(t *testing.T) {
+		m := New()
+		chain := m.Chain(
+			newFailingTestResource("a"),
+			newTestResource("b"),
+			newTestResource("c"),
+		)
+		other := m.Add(newTestResource("d"))
+
+		newScheduler(m, 0).run()
+
+		assert.Equal(t, Failed, m.resources[chain[0].ResourceID].Status)
+		assert.Equal(t, DependencyFailed, m.resources[chain[1].ResourceID].Status)
+		assert.Equal(t, DependencyFailed, m.resources[chain[2].ResourceID].Status)
+
+		// Anything that does not depend on the failure runs as normal
+		assert.Equal(t, Success, m.resources[other.ResourceID].Status)
+	})
+
+	t.Run("waits for every dependency", func(t *testing.T) {
+		slow := newBlockingTestResource("a")
+		quick := newTestResource("b")
+		after := newTestResource("c")
+
+		m := New()
+		a := m.Add(slow)
+		b := m.Add(quick)
+		m.Add(after, a, b)
+
+		done := make(chan struct{})
+		go func() {
+			newScheduler(m, 0).run()
+			close(done)
+		}()
+
+		assert.Eventually(t, quick.ran.Load, time.Second, time.Millisecond)
+		assert.Eventually(t, slow.ran.Load, time.Second, time.Millisecond)
+		assert.False(t, after.ran.Load())
+
+		slow.release()
+		<-done
+
+		assert.True(t, after.ran.Load())
+	})
+
+	t.Run("ignores dependencies that are not in the manifest", func(t *testing.T) {
+		m := New()
+		r := m.Add(newTestResource("a"))
+		m.SetDep(r, "does-not-exist")
+
+		newScheduler(m, 0).run()
+
+		assert.Equal(t, Success, m.resources[r.ResourceID].Status)
+	})
+
+	t.Run("runs no more than the concurrency at once", func(t *testing.T) {
+		var running, peak atomic.Int32
+
+		m := New()
+		for _, v := range []string{"a", "b", "c", "d", "e", "f"} {
+			m.Add(&countingResourceType{Value: v, running: &running, peak: &peak})
+		}
+
+		newScheduler(m, 2).run()
+
+		assert.LessOrEqual(t, peak.Load(), int32(2))
+
+		for _, r := range m.resources {
+			assert.Equal(t, Success, r.Status)
+		}
+	})
+
+	t.Run("has no more goroutines than the concurrency", func(t *testing.T) {
+		var running, peak atomic.Int32
+
+		m := New()
+		for i := range 50 {
+			m.Add(&countingResourceType{Value: strconv.Itoa(i), running: &running, peak: &peak})
+		}
+
+		before := runtime.NumGoroutine()
+		most := 0
+
+		done := make(chan struct{})
+		go func() {
+			newScheduler(m, 2).run()
+			close(done)
+		}()
+
+		for finished := false; !finished; {
+			select {
+			case <-done:
+				finished = true
+			case <-time.After(time.Millisecond):
+				most = max(most, runtime.NumGoroutine()-before)
+			}
+		}
+
+		// Two resources at a time, each with its operation running alongside
+		// it, and the goroutine running the scheduler, rather than one for
+		// everything that is ready to go
+		assert.LessOrEqual(t, most, 5)
+	})
+
+	t.Run("a resource waiting for a lock does not hold a slot", func(t *testing.T) {
+		holder := newBlockingTestResource("a")
+		holder.LockKey = PackageLock
+		waiter := newBlockingTestResource("b")
+		waiter.LockKey = PackageLock
+		free := newTestResource("c")
+		other := newTestResource("d")
+
+		m := New()
+		m.Add(holder)
+		m.Add(waiter)
+		m.Add(free)
+		m.Add(other)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newScheduler(m, 2).run()
+		}()
+
+		// Whichever takes the lock first holds one slot, and the one
+		// queueing behind it must leave the other to the resources that are
+		// free to run, or they never get to
+		assert.Eventually(t, func() bool { return free.ran.Load() && other.ran.Load() }, time.Second, time.Millisecond)
+		assert.Eventually(t, func() bool { return holder.ran.Load() || waiter.ran.Load() }, time.Second, time.Millisecond)
+		assert.NotEqual(t, holder.ran.Load(), waiter.ran.Load(), "only one of them holds the lock")
+
+		holder.release()
+		waiter.release()
+		wg.Wait()
+
+		assert.True(t, holder.ran.Load())
+		assert.True(t, waiter.ran.Load())
+
+		for _, r := range m.resources {
+			assert.Equal(t, Success, r.Status)
+		}
+	})
+}
+
+func TestSchedulerHandlers(t *testing.T) {
+	t.Run("a handler runs once however many sources changed", func(t *testing.T) {
+		handler := newTestResource("restart")
+
+		m := New()
+		a := m.Add(newChangingTestResource("a"))
+		b := m.Add(newChangingTestResource("b"))
+		h := m.Add(handler)
+		m.Subscribe(h, a, b)
+
+		newScheduler(m, 0).run()
+
+		assert.Equal(t, int32(1), handler.runs.Load())
+		assert.Equal(t, Success, m.resources[h.ResourceID].Status)
+	})
+
+	t.Run("a handler is skipped when nothing changed", func(t *testing.T) {
+		handler := newTestResource("restart")
+
+		m := New()
+		a := m.Add(newTestResource("a"))
+		h := m.Add(handler)
+		m.Notify(a, h)
+
+		newScheduler(m, 0).run()
+
+		assert.False(t, handler.ran.Load())
+		assert.Equal(t, Success, m.resources[a.ResourceID].Status)
+		assert.Equal(t, Skipped, m.resources[h.ResourceID].Status)
+		assert.Empty(t, collectFailures(m.resources))
+	})
+
+	t.Run("a handler is skipped when its source failed", func(t *testing.T) {
+		failing := newFailingTestResource("a")
+		failing.changes = true
+		handler := newTestResource("restart")
+
+		m := New()
+		a := m.Add(failing)
+		h := m.Add(handler)
+		m.Notify(a, h)
+
+		newScheduler(m, 0).run()
+
+		assert.False(t, handler.ran.Load())
+		assert.Equal(t, Skipped, m.resources[h.ResourceID].Status)
+	})
+
+	t.Run("handlers run after everything else", func(t *testing.T) {
+		slow := newBlockingTestResource("slow")
+		handler := newTestResource("restart")
+		source := newChangingTestResource("a")
+
+		m := New()
+		a := m.Add(source)
+		m.Add(slow)
+		h := m.Add(handler)
+		m.Notify(a, h)
+
+		done := make(chan struct{})
+		go func() {
+			newScheduler(m, 0).run()
+			close(done)
+		}()
+
+		// The source has run, but the handler waits for the rest of the run
+		assert.Eventually(t, func() bool { return source.ran.Load() && slow.ran.Load() }, time.Second, time.Millisecond)
+		assert.False(t, handler.ran.Load())
+
+		slow.release()
+		<-done
+
+		assert.True(t, handler.ran.Load())
+	})
+
+	t.Run("a handler can notify another handler", func(t *testing.T) {
+		restart := newChangingTestResource("restart")
+		reload := newTestResource("reload")
+
+		m := New()
+		a := m.Add(newChangingTestResource("a"))
+		first := m.Add(restart)
+		second := m.Add(reload)
+		m.Notify(a, first)
+		m.Notify(first, second)
+
+		newScheduler(m, 0).run()
+
+		assert.True(t, restart.ran.Load())
+		assert.True(t, reload.ran.Load())
+	})
+}
+
+func TestConcurrencyFor(t *testing.T) {
+	orig := Cli.Concurrency
+	defer func() { Cli.Concurrency = orig }()
+
+	Cli.Concurrency = 0
+
+	m := New()
+
+	// The default applies when nothing has been set
+	assert.Equal(t, defaultConcurrency, m.concurrencyFor())
+
+	// The manifest overrides the default, and a negative number means no limit
+	m.SetConcurrency(4)
+	assert.Equal(t, 4, m.concurrencyFor())
+
+	m.SetConcurrency(-1)
+	assert.Equal(t, 0, m.concurrencyFor())
+
+	// The flag overrides everything
+	Cli.Concurrency = 8
+	assert.Equal(t, 8, m.concurrencyFor())
+}
@@ -0,0 +1,155 @@
+package viaduct
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Tag labels a resource, so that a run can be narrowed down to the resources
+// with the tag using --only, or leave them out using --skip.
+func (m *Manifest) Tag(r *Resource, tags ...string) {
+	if v, ok := m.resources[r.ResourceID]; ok {
+		for _, tag := range tags {
+			if !slices.Contains(v.Tags, tag) {
+				v.Tags = append(v.Tags, tag)
+			}
+		}
+
+		m.resources[r.ResourceID] = v
+	}
+}
+
+// selection says which resources a run applies, from the command line.
+type selection struct {
+	// only are the tags to narrow the run down to.
+	only []string
+
+	// onlyIDs are the resources to narrow the run down to.
+	onlyIDs []ResourceID
+
+	// skip are the tags to leave out of the run.
+	skip []string
+
+	// noDeps stops the dependencies of the resources picked with only and
+	// onlyIDs from being pulled in with them.
+	noDeps bool
+}
+
+// selection returns the selection made in the manifest's options.
+func (m *Manifest) selection() selection {
+	opts := m.rt.Options
+
+	s := selection{
+		only:   opts.Only,
+		skip:   opts.Skip,
+		noDeps: opts.NoDeps,
+	}
+
+	for _, id := range opts.OnlyIDs {
+		s.onlyIDs = append(s.onlyIDs, ResourceID(id))
+	}
+
+	return s
+}
+
+// narrowed reports whether the selection narrows the run down to some of the
+// resources, rather than starting from all of them.
+func (s selection) narrowed() bool {
+	return len(s.only) > 0 || len(s.onlyIDs) > 0
+}
+
+// active reports whether the selection leaves anything out at all.
+func (s selection) active() bool {
+	return s.narrowed() || len(s.skip) > 0
+}
+
+// deselect returns the resources the selection leaves out of the run.
+//
+// Everything a picked resource depends on is picked with it, unless noDeps is
+// set, since it would otherwise run without what it needs. So are its
+// handlers, so that picking a configuration file still restarts the service.
+// Skipping a tag wins over all of that, so a resource can be left out even when
+// something that is picked depends on it.
+func (m *Manifest) deselect(s selection) (map[ResourceID]bool, error) {
+	picked := make(map[ResourceID]bool, len(m.resources))
+
+	if !s.narrowed() {
+		for id := range m.resources {
+			picked[id] = true
+		}
+	}
+
+	for _, tag := range s.only {
+		var found bool
+
+		for id, r := range m.resources {
+			if slices.Contains(r.Tags, tag) {
+				picked[id] = true
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("no resources are tagged %s", tag)
+		}
+	}
+
+	for _, id := range s.onlyIDs {
+		if _, ok := m.resources[id]; !ok {
+			return nil, fmt.Errorf("no resource has the ID %s", id)
+		}
+
+		picked[id] = true
+	}
+
+	if s.narrowed() && !s.noDeps {
+		m.pickDependencies(picked)
+	}
+
+	for id, r := range m.resources {
+		for _, tag := range s.skip {
+			if slices.Contains(r.Tags, tag) {
+				delete(picked, id)
+			}
+		}
+	}
+
+	deselected := make(map[ResourceID]bool)
+	for id := range m.resources {
+		if !picked[id] {
+			deselected[id] = true
+		}
+	}
+
+	return deselected, nil
+}
+
+// pickDependencies adds everything the picked resources depend on, and the
+// handlers they notify, along with everything those depend on in turn.
+func (m *Manifest) pickDependencies(picked map[ResourceID]bool) {
+	handlers := make(map[ResourceID][]ResourceID)
+	for id, r := range m.resources {
+		for _, source := range r.Subscribes {
+			handlers[source] = append(handlers[source], id)
+		}
+	}
+
+	var queue []ResourceID
+	for id := range picked {
+		queue = append(queue, id)
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		for _, next := range append(slices.Clone(m.resources[id].DependsOn), handlers[id]...) {
+			if _, ok := m.resources[next]; !ok || picked[next] {
+				continue
+			}
+
+			picked[next] = true
+			queue = append(queue, next)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package viaduct
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTag(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	r := m.Add(newTestResource("a"))
+
+	m.Tag(r, "dotfiles", "shell")
+	m.Tag(r, "dotfiles")
+
+	assert.Equal(t, []string{"dotfiles", "shell"}, m.resources[r.ResourceID].Tags)
+}
+
+func TestDeselect(t *testing.T) {
+	t.Parallel()
+
+	// base <- dotfile -> handler, and an unrelated package
+	build := func() (*Manifest, map[string]ResourceID) {
+		m := New()
+		base := m.Add(newTestResource("base"))
+		dotfile := m.Add(newTestResource("dotfile"), base)
+		handler := m.Add(newTestResource("handler"))
+		m.Notify(dotfile, handler)
+		pkg := m.Add(newTestResource("package"))
+
+		m.Tag(dotfile, "dotfiles")
+		m.Tag(base, "base")
+		m.Tag(pkg, "packages")
+
+		return m, map[string]ResourceID{
+			"base":    base.ResourceID,
+			"dotfile": dotfile.ResourceID,
+			"handler": handler.ResourceID,
+			"package": pkg.ResourceID,
+		}
+	}
+
+	left := func(t *testing.T, s selection) []string {
+		m, ids := build()
+
+		deselected, err := m.deselect(s)
+		assert.NoError(t, err)
+
+		var names []string
+		for name, id := range ids {
+			if deselected[id] {
+				names = append(names, name)
+			}
+		}
+
+		return names
+	}
+
+	t.Run("nothing selected runs everything", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Empty(t, left(t, selection{}))
+	})
+
+	t.Run("only picks dependencies and handlers", func(t *testing.T) {
+		t.Parallel()
+
+		assert.ElementsMatch(t, []string{"package"}, left(t, selection{only: []string{"dotfiles"}}))
+	})
+
+	t.Run("only without dependencies", func(t *testing.T) {
+		t.Parallel()
+
+		assert.ElementsMatch(t, []string{"base", "handler", "package"}, left(t, selection{only: []string{"dotfiles"}, noDeps: true}))
+	})
+
+	t.Run("only by ID", func(t *testing.T) {
+		t.Parallel()
+
+		_, ids := build()
+		assert.ElementsMatch(t, []string{"dotfile", "handler"}, left(t, selection{onlyIDs: []ResourceID{ids["package"], ids["base"]}}))
+	})
+
+	t.Run("skip wins over dependencies", func(t *testing.T) {
+		t.Parallel()
+
+		assert.ElementsMatch(t, []string{"base", "package"}, left(t, selection{only: []string{"dotfiles"}, skip: []string{"base"}}))
+	})
+
+	t.Run("skip on its own", func(t *testing.T) {
+		t.Parallel()
+
+		assert.ElementsMatch(t, []string{"package"}, left(t, selection{skip: []string{"packages"}}))
+	})
+
+	t.Run("an unknown tag is an error", func(t *testing.T) {
+		t.Parallel()
+
+		m, _ := build()
+		_, err := m.deselect(selection{only: []string{"typo"}})
+		assert.ErrorContains(t, err, "typo")
+	})
+
+	t.Run("an unknown ID is an error", func(t *testing.T) {
+		t.Parallel()
+
+		m, _ := build()
+		_, err := m.deselect(selection{onlyIDs: []ResourceID{"typo"}})
+		assert.ErrorContains(t, err, "typo")
+	})
+}
+
+func TestDeselectedRun(t *testing.T) {
+	m := New()
+	left := newChangingTestResource("left")
+	kept := newTestResource("kept")
+	l := m.Add(left)
+	k := m.Add(kept, l)
+
+	m.deselected = map[ResourceID]bool{l.ResourceID: true}
+	newScheduler(m, 0).run()
+
+	assert.False(t, left.ran.Load())
+	assert.Equal(t, Skipped, m.resources[l.ResourceID].Status)
+	assert.Equal(t, OutcomeSkipped, m.resources[l.ResourceID].Outcome)
+
+	// Something left out of the run has not failed, so what depends on it
+	// still runs
+	assert.True(t, kept.ran.Load())
+	assert.Equal(t, Success, m.resources[k.ResourceID].Status)
+
+	t.Run("the last state is kept for what was left out", func(t *testing.T) {
+		m.lastState = State{Resources: map[ResourceID]ResourceState{
+			l.ResourceID: {Description: "left", Status: Success, Outcome: OutcomeChanged},
+		}}
+
+		s, err := newState(m.resources, m.resources[k.ResourceID].timing.Finished)
+		assert.NoError(t, err)
+
+		m.carryDeselected(s)
+		assert.Equal(t, m.lastState.Resources[l.ResourceID], s.Resources[l.ResourceID])
+		assert.Equal(t, Success, s.Resources[k.ResourceID].Status)
+	})
+}
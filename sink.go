@@ -0,0 +1,255 @@
+package viaduct
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Level is how a log event was logged: which of the Logger methods it came
+// from.
+type Level string
+
+const (
+	LevelOK    Level = "OK"
+	LevelNoop  Level = "NOOP"
+	LevelDiff  Level = "DIFF"
+	LevelWarn  Level = "WARN"
+	LevelError Level = "ERR"
+	LevelFatal Level = "FATAL"
+	// LevelOutput is the output of a command a resource ran. It isn't
+	// written to the console, which sees the output as the command runs.
+	LevelOutput Level = "OUTPUT"
+)
+
+// quiet says whether quiet mode leaves the level out.
+func (l Level) quiet() bool {
+	return l == LevelOK || l == LevelNoop || l == LevelDiff
+}
+
+// slogLevel is the log/slog level an event is handled at. A change is
+// information, and finding things as they should be is only of interest when
+// debugging.
+func (l Level) slogLevel() slog.Level {
+	switch l {
+	case LevelNoop, LevelDiff, LevelOutput:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError, LevelFatal:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Event is a single call to a Logger, as it is handed to each Sink.
+type Event struct {
+	Time  time.Time
+	Level Level
+	// ResourceID is the resource that logged the event, if it was logged
+	// while a resource ran.
+	ResourceID ResourceID
+	Resource   string
+	Action     string
+	Message    string
+	// Attrs are the fields the event was logged with, such as the path of a
+	// file.
+	Attrs []slog.Attr
+}
+
+// MarshalJSON encodes the event as a single object, with its fields in an
+// object of their own.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Time       time.Time      `json:"time"`
+		Level      Level          `json:"level"`
+		ResourceID ResourceID     `json:"resource_id,omitempty"`
+		Resource   string         `json:"resource"`
+		Action     string         `json:"action"`
+		Message    string         `json:"msg"`
+		Fields     map[string]any `json:"fields,omitempty"`
+	}{e.Time, e.Level, e.ResourceID, e.Resource, e.Action, e.Message, attrMap(e.Attrs)})
+}
+
+// attr returns the value of the field with the key, if the event has one.
+func (e Event) attr(key string) (slog.Value, bool) {
+	for _, a := range e.Attrs {
+		if a.Key == key {
+			return a.Value.Resolve(), true
+		}
+	}
+
+	return slog.Value{}, false
+}
+
+// attrsOf turns the fields passed to a Logger into attributes, the same way
+// log/slog does: alternating keys and values, or slog.Attr values.
+func attrsOf(args []any) []slog.Attr {
+	if len(args) == 0 {
+		return nil
+	}
+
+	var r slog.Record
+	r.Add(args...)
+
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	return attrs
+}
+
+// attrMap turns attributes into a map for JSON, keeping numbers and booleans
+// as they are. Durations and errors, which would otherwise encode as a number
+// of nanoseconds and an empty object, are written as text.
+func attrMap(attrs []slog.Attr) map[string]any {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	m := make(map[string]any, len(attrs))
+
+	for _, a := range attrs {
+		v := a.Value.Resolve()
+
+		switch v.Kind() {
+		case slog.KindGroup:
+			m[a.Key] = attrMap(v.Group())
+		case slog.KindDuration:
+			m[a.Key] = v.Duration().String()
+		default:
+			if err, ok := v.Any().(error); ok {
+				m[a.Key] = err.Error()
+			} else {
+				m[a.Key] = v.Any()
+			}
+		}
+	}
+
+	return m
+}
+
+// Sink is somewhere log events go. Every Logger of a runtime hands its events
+// to the runtime's sinks, which by default is just the console. Handle may be
+// called from many resources at once. An error is dropped, since there is
+// nowhere better to report it.
+type Sink interface {
+	Handle(e Event) error
+}
+
+// ConsoleSink writes events as coloured lines for a person to read, with
+// warnings and errors on their own writer.
+type ConsoleSink struct {
+	out, err io.Writer
+}
+
+// NewConsoleSink returns a sink that writes to out, apart from warnings and
+// errors, which it writes to err.
+func NewConsoleSink(out, err io.Writer) *ConsoleSink {
+	return &ConsoleSink{out: out, err: err}
+}
+
+func (s *ConsoleSink) Handle(e Event) error {
+	var err error
+
+	switch e.Level {
+	case LevelOK:
+		_, err = fmt.Fprintln(s.out, formatLine(okTag, e.Resource, e.Action, e.Message, e.Attrs))
+	case LevelNoop:
+		_, err = fmt.Fprintln(s.out, formatLine(noopTag, e.Resource, e.Action, e.Message, e.Attrs))
+	case LevelDiff:
+		if diff, ok := e.attr("diff"); ok {
+			_, err = fmt.Fprint(s.out, formatDiff(diff.String()))
+		}
+	case LevelOutput:
+	case LevelWarn:
+		_, err = fmt.Fprintln(s.err, formatLine(warnTag, e.Resource, e.Action, e.Message, e.Attrs))
+	default:
+		_, err = fmt.Fprintln(s.err, formatLine(failTag, e.Resource, e.Action, e.Message, e.Attrs))
+	}
+
+	return err
+}
+
+// JSONLinesSink writes each event as a line of JSON.
+type JSONLinesSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONLinesSink returns a sink that writes each event to w as a line of
+// JSON, such as:
+//
+//	{"time":"2024-05-01T12:00:00Z","level":"OK","resource":"File","action":"Create","msg":"created","fields":{"path":"/etc/motd"}}
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{enc: json.NewEncoder(w)}
+}
+
+func (s *JSONLinesSink) Handle(e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.enc.Encode(e)
+}
+
+// FileSink writes events to a file as lines of JSON.
+type FileSink struct {
+	*JSONLinesSink
+	f *os.File
+}
+
+// NewFileSink opens a file for events to be written to as lines of JSON,
+// creating it if needed and appending to it otherwise.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{JSONLinesSink: NewJSONLinesSink(f), f: f}, nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// SlogSink hands events to a log/slog handler, so they can go wherever the
+// rest of a program's logs do.
+type SlogSink struct {
+	h slog.Handler
+}
+
+// NewSlogSink returns a sink that hands each event to h, with its resource and
+// action as attributes, and the ID of the resource that logged it if there is
+// one. A change is logged at slog.LevelInfo, finding things as they should be
+// and diffs at slog.LevelDebug, and warnings and errors at their own levels.
+func NewSlogSink(h slog.Handler) *SlogSink {
+	return &SlogSink{h: h}
+}
+
+func (s *SlogSink) Handle(e Event) error {
+	ctx := context.Background()
+
+	level := e.Level.slogLevel()
+	if !s.h.Enabled(ctx, level) {
+		return nil
+	}
+
+	r := slog.NewRecord(e.Time, level, e.Message, 0)
+	r.AddAttrs(slog.String("resource", e.Resource), slog.String("action", e.Action))
+	if e.ResourceID != "" {
+		r.AddAttrs(slog.String("resource_id", string(e.ResourceID)))
+	}
+	r.AddAttrs(e.Attrs...)
+
+	return s.h.Handle(ctx, r)
+}
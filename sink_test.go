@@ -0,0 +1,232 @@
+package viaduct
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink is a sink that keeps what it is handed
+type recordingSink struct {
+	events []Event
+}
+
+func (s *recordingSink) Handle(e Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+// newSinkRuntime returns a runtime that logs to a recording sink in place of
+// the console
+func newSinkRuntime(opts Options) (*Runtime, *recordingSink) {
+	rt := &Runtime{Options: &opts, Attributes: &SystemAttributes{}}
+	sink := &recordingSink{}
+	rt.SetSinks(sink)
+
+	return rt, sink
+}
+
+func TestAttrs(t *testing.T) {
+	t.Parallel()
+
+	attrs := attrsOf([]any{
+		"path", "/etc/motd",
+		"uid", 1000,
+		"backoff", 2 * time.Second,
+		"error", errors.New("failed"),
+		slog.Group("owner", "user", "root"),
+	})
+
+	assert.Equal(t, map[string]any{
+		"path":    "/etc/motd",
+		"uid":     int64(1000),
+		"backoff": "2s",
+		"error":   "failed",
+		"owner":   map[string]any{"user": "root"},
+	}, attrMap(attrs))
+
+	assert.Equal(t, `  OK  File [Create]          created path=/etc/motd uid=1000 backoff=2s error=failed owner.user=root`,
+		formatLine("  OK", "File", "Create", "created", attrs))
+
+	assert.Nil(t, attrsOf(nil))
+}
+
+func TestConsoleSink(t *testing.T) {
+	t.Parallel()
+
+	var out, errOut bytes.Buffer
+	s := NewConsoleSink(&out, &errOut)
+
+	assert.NoError(t, s.Handle(Event{Level: LevelOK, Resource: "File", Action: "Create", Message: "created", Attrs: attrsOf([]any{"path", "/etc/motd"})}))
+	assert.NoError(t, s.Handle(Event{Level: LevelDiff, Attrs: attrsOf([]any{"path", "/etc/motd", "diff", "-a\n+b\n"})}))
+	assert.NoError(t, s.Handle(Event{Level: LevelWarn, Resource: "File", Action: "Create", Message: "careful"}))
+
+	assert.Contains(t, out.String(), "created path=/etc/motd\n")
+	assert.Contains(t, out.String(), "      +b\n")
+	assert.Contains(t, errOut.String(), "careful")
+	assert.NotContains(t, out.String(), "careful")
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	s := NewJSONLinesSink(&b)
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, s.Handle(Event{Time: at, Level: LevelOK, Resource: "File", Action: "Create", Message: "created", Attrs: attrsOf([]any{"mode", 0o644})}))
+	assert.NoError(t, s.Handle(Event{Time: at, Level: LevelNoop, Resource: "File", Action: "Create", Message: "up-to-date"}))
+
+	assert.Equal(t, `{"time":"2024-05-01T12:00:00Z","level":"OK","resource":"File","action":"Create","msg":"created","fields":{"mode":420}}
+{"time":"2024-05-01T12:00:00Z","level":"NOOP","resource":"File","action":"Create","msg":"up-to-date"}
+`, b.String())
+}
+
+func TestFileSink(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "viaduct.log")
+
+	for _, msg := range []string{"first", "second"} {
+		s, err := NewFileSink(path)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.NoError(t, s.Handle(Event{Level: LevelOK, Message: msg}))
+		assert.NoError(t, s.Close())
+	}
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 2, "the file is appended to") {
+		var e map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &e))
+		assert.Equal(t, "second", e["msg"])
+	}
+
+	_, err = NewFileSink(filepath.Join(t.TempDir(), "missing", "viaduct.log"))
+	assert.Error(t, err)
+}
+
+func TestSlogSink(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	s := NewSlogSink(slog.NewJSONHandler(&b, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	assert.NoError(t, s.Handle(Event{Level: LevelOK, Resource: "File", Action: "Create", Message: "created", Attrs: attrsOf([]any{"uid", 1000})}))
+	assert.NoError(t, s.Handle(Event{Level: LevelNoop, Resource: "File", Action: "Create", Message: "up-to-date"}))
+	assert.NoError(t, s.Handle(Event{Level: LevelError, Resource: "File", Action: "Create", Message: "failed"}))
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if assert.Len(t, lines, 2, "finding things as they should be is only logged when debugging") {
+		var e map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &e))
+		assert.Equal(t, "INFO", e["level"])
+		assert.Equal(t, "created", e["msg"])
+		assert.Equal(t, "File", e["resource"])
+		assert.Equal(t, "Create", e["action"])
+		assert.Equal(t, float64(1000), e["uid"])
+
+		assert.Contains(t, lines[1], `"level":"ERROR"`)
+	}
+}
+
+func TestRuntimeSinks(t *testing.T) {
+	t.Parallel()
+
+	t.Run("set in place of the console", func(t *testing.T) {
+		t.Parallel()
+
+		rt, sink := newSinkRuntime(Options{})
+		l := rt.NewLogger("File", "Create")
+		l.Info("created", "path", "/etc/motd")
+
+		if assert.Len(t, sink.events, 1) {
+			e := sink.events[0]
+			assert.Equal(t, LevelOK, e.Level)
+			assert.Equal(t, "File", e.Resource)
+			assert.Equal(t, "Create", e.Action)
+			assert.Equal(t, "created", e.Message)
+			assert.False(t, e.Time.IsZero())
+		}
+	})
+
+	t.Run("added alongside the console", func(t *testing.T) {
+		t.Parallel()
+
+		rt := &Runtime{Options: &Options{}}
+		sink := &recordingSink{}
+		rt.AddSink(sink)
+
+		sinks := rt.sinks(false)
+		if assert.Len(t, sinks, 2) {
+			assert.IsType(t, &ConsoleSink{}, sinks[0])
+			assert.Equal(t, sink, sinks[1])
+		}
+
+		assert.Equal(t, []Sink{sink}, rt.sinks(true), "the console is left out in JSON mode")
+	})
+
+	t.Run("quiet", func(t *testing.T) {
+		t.Parallel()
+
+		rt, sink := newSinkRuntime(Options{Quiet: true})
+		l := rt.NewLogger("File", "Create")
+		l.Info("created")
+		l.Noop("up-to-date")
+		l.Warn("careful")
+
+		if assert.Len(t, sink.events, 1) {
+			assert.Equal(t, LevelWarn, sink.events[0].Level)
+		}
+	})
+
+	t.Run("JSON mode", func(t *testing.T) {
+		t.Parallel()
+
+		rt, sink := newSinkRuntime(Options{JSON: true})
+		l := rt.NewLogger("File", "Create")
+		l.Info("created")
+
+		assert.Len(t, sink.events, 1)
+		assert.Len(t, l.Entries(), 1, "entries are kept for the report as well")
+	})
+
+	t.Run("log file", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "viaduct.log")
+		rt, _ := newSinkRuntime(Options{LogFile: path})
+		rt.NewLogger("File", "Create").Info("created")
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), `"msg":"created"`)
+	})
+
+	t.Run("unwritable log file stops the run", func(t *testing.T) {
+		t.Parallel()
+
+		rt, _ := newSinkRuntime(Options{LogFile: filepath.Join(t.TempDir(), "missing", "viaduct.log")})
+		m := NewWithRuntime(rt)
+		m.DisableState()
+		m.Add(newTestResource("a"))
+
+		report, err := m.Apply(context.Background())
+		assert.Nil(t, report)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -0,0 +1,262 @@
+package viaduct
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateDir is where state files are kept, relative to the home directory of
+// the user running Viaduct.
+const stateDir = ".viaduct/state"
+
+// State is what a run recorded about each resource, so that a later run can
+// tell what has changed since.
+type State struct {
+	// Updated is when the run that saved the state finished.
+	Updated time.Time `json:"updated"`
+	// Resources holds the state of each resource by ID.
+	Resources map[ResourceID]ResourceState `json:"resources"`
+}
+
+// ResourceState is what a run recorded about a single resource.
+type ResourceState struct {
+	ResourceKind ResourceKind `json:"resource_kind"`
+	Description  string       `json:"description"`
+	Operation    string       `json:"operation"`
+	// AttributesHash is a hash of the resource attributes once preflight
+	// checks have filled in their defaults.
+	AttributesHash string  `json:"attributes_hash"`
+	Status         Status  `json:"status"`
+	Outcome        Outcome `json:"outcome"`
+	// Timestamp is when the resource finished.
+	Timestamp time.Time `json:"timestamp"`
+	// Pruned is the resource that removes this one again, for resources that
+	// implement Prunable. It is what pruning rebuilds once the resource is
+	// taken out of the manifest.
+	Pruned json.RawMessage `json:"pruned,omitempty"`
+}
+
+// DriftReason says why a resource is listed in a drift report.
+type DriftReason string
+
+const (
+	// DriftDrifted means the last run applied the resource, but it would
+	// change now, so something else has changed the machine since.
+	DriftDrifted DriftReason = "drifted"
+	// DriftNotApplied means the resource would change and the last run did
+	// not apply it, either because it is new or because it failed.
+	DriftNotApplied DriftReason = "not-applied"
+	// DriftRemoved means the last run applied the resource, but it is no
+	// longer in the manifest. Changing the attributes of a resource without
+	// an identity gives it a new ID, so the old one shows up as removed.
+	DriftRemoved DriftReason = "removed"
+)
+
+// Drift is a resource listed in a drift report.
+type Drift struct {
+	ResourceID   string      `json:"resource_id"`
+	ResourceKind string      `json:"resource_kind"`
+	Description  string      `json:"description"`
+	Operation    string      `json:"operation"`
+	Reason       DriftReason `json:"reason"`
+}
+
+// SetStatePath sets where the state of each run is saved. The default is a
+// file named after the binary under ~/.viaduct/state, for the user running it.
+func (m *Manifest) SetStatePath(path string) {
+	m.statePath = path
+}
+
+// DisableState stops the run from saving its state, which also leaves drift
+// mode nothing to compare against.
+func (m *Manifest) DisableState() {
+	m.stateDisabled = true
+}
+
+// stateFile returns where the state is saved.
+func (m *Manifest) stateFile() string {
+	if m.statePath != "" {
+		return m.rt.ExpandPath(m.statePath)
+	}
+
+	return filepath.Join(m.rt.Attributes.runuser.HomeDir, stateDir, filepath.Base(os.Args[0])+".json")
+}
+
+// saveState records the outcome of the run, replacing the last state.
+func (m *Manifest) saveState() error {
+	s, err := newState(m.resources, time.Now())
+	if err != nil {
+		return err
+	}
+
+	m.carryPruned(s)
+	m.carryDeselected(s)
+
+	return s.save(m.stateFile())
+}
+
+// carryDeselected keeps what the last run recorded about the resources left
+// out of this one, since they were not applied either way.
+func (m *Manifest) carryDeselected(s State) {
+	for id := range m.deselected {
+		if rs, ok := m.lastState.Resources[id]; ok {
+			s.Resources[id] = rs
+		}
+	}
+}
+
+// loadState reads the state saved at path. A missing file is an empty state,
+// since that is what the first run on a machine finds.
+func loadState(path string) (State, error) {
+	out, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return State{}, nil
+	}
+
+	if err != nil {
+		return State{}, err
+	}
+
+	var s State
+	if err := json.Unmarshal(out, &s); err != nil {
+		return State{}, fmt.Errorf("could not read state from %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// newState records the resources in the manifest once the run has finished.
+func newState(resources map[ResourceID]Resource, updated time.Time) (State, error) {
+	s := State{
+		Updated:   updated,
+		Resources: make(map[ResourceID]ResourceState, len(resources)),
+	}
+
+	for id, r := range resources {
+		hash, err := hashJSON(r.Attributes)
+		if err != nil {
+			return State{}, err
+		}
+
+		pruned, err := prunedJSON(r.Attributes)
+		if err != nil {
+			return State{}, err
+		}
+
+		s.Resources[id] = ResourceState{
+			ResourceKind:   r.ResourceKind,
+			Description:    r.Attributes.Description(),
+			Operation:      r.Attributes.OperationName(),
+			AttributesHash: hash,
+			Status:         r.Status,
+			Outcome:        r.Outcome,
+			Timestamp:      r.timing.Finished,
+			Pruned:         pruned,
+		}
+	}
+
+	return s, nil
+}
+
+// save writes the state to path. It goes to a temporary file that is moved
+// into place, so a run that is killed part way through leaves the last state
+// as it was.
+func (s State) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".viaduct-tmp"
+	if err := os.WriteFile(tmp, out, 0o644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// drift compares a dry run of the manifest against the state saved by the
+// last run, listing the resources that would change and the ones the last run
+// applied that are no longer in the manifest.
+func (s State) drift(resources map[ResourceID]Resource) []Drift {
+	var ids []ResourceID
+	for id := range resources {
+		ids = append(ids, id)
+	}
+
+	for id := range s.Resources {
+		if _, ok := resources[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+
+	var drift []Drift
+
+	for _, id := range sortedIDs(ids) {
+		last, applied := s.Resources[id]
+		applied = applied && last.Status == Success
+
+		r, ok := resources[id]
+		if !ok {
+			if applied {
+				drift = append(drift, Drift{
+					ResourceID:   string(id),
+					ResourceKind: string(last.ResourceKind),
+					Description:  last.Description,
+					Operation:    last.Operation,
+					Reason:       DriftRemoved,
+				})
+			}
+
+			continue
+		}
+
+		if r.Outcome != OutcomeChanged {
+			continue
+		}
+
+		reason := DriftNotApplied
+		if applied {
+			reason = DriftDrifted
+		}
+
+		drift = append(drift, Drift{
+			ResourceID:   string(id),
+			ResourceKind: string(r.ResourceKind),
+			Description:  r.Attributes.Description(),
+			Operation:    r.Attributes.OperationName(),
+			Reason:       reason,
+		})
+	}
+
+	return drift
+}
+
+// printDrift lists the resources in a drift report.
+func printDrift(drift []Drift, l *Logger) {
+	if len(drift) == 0 {
+		l.Noop("no-drift")
+		return
+	}
+
+	for _, d := range drift {
+		l.Warn(string(d.Reason),
+			"resource_id", d.ResourceID,
+			"resource_kind", d.ResourceKind,
+			"description", d.Description,
+		)
+	}
+}
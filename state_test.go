@@ -0,0 +1,124 @@
+package viaduct
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("named after the binary under the home directory", func(t *testing.T) {
+		t.Parallel()
+
+		path := New().stateFile()
+		assert.True(t, strings.HasPrefix(path, Attribute.runuser.HomeDir), path)
+		assert.Equal(t, filepath.Base(os.Args[0])+".json", filepath.Base(path))
+		assert.Contains(t, path, stateDir)
+	})
+
+	t.Run("overridden", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		m.SetStatePath("/var/lib/viaduct/state.json")
+		assert.Equal(t, "/var/lib/viaduct/state.json", m.stateFile())
+	})
+}
+
+func TestState(t *testing.T) {
+	t.Parallel()
+
+	t.Run("missing state is empty", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := loadState(filepath.Join(t.TempDir(), "missing.json"))
+		assert.NoError(t, err)
+		assert.Empty(t, s.Resources)
+	})
+
+	t.Run("unreadable state is an error", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "state.json")
+		if err := os.WriteFile(path, []byte("nonsense"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := loadState(path)
+		assert.ErrorContains(t, err, path)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		t.Parallel()
+
+		m := New()
+		a := m.Add(newChangingTestResource("a"))
+		newScheduler(m, 0).run()
+
+		path := filepath.Join(t.TempDir(), "nested", "state.json")
+		m.SetStatePath(path)
+		assert.NoError(t, m.saveState())
+
+		s, err := loadState(path)
+		assert.NoError(t, err)
+
+		rs, ok := s.Resources[a.ResourceID]
+		if assert.True(t, ok) {
+			assert.Equal(t, ResourceKind("testResourceType"), rs.ResourceKind)
+			assert.Equal(t, "a", rs.Description)
+			assert.Equal(t, Success, rs.Status)
+			assert.Equal(t, OutcomeChanged, rs.Outcome)
+			assert.NotEmpty(t, rs.AttributesHash)
+			assert.False(t, rs.Timestamp.IsZero())
+		}
+	})
+
+	t.Run("the same attributes hash the same way", func(t *testing.T) {
+		t.Parallel()
+
+		first, err := newState(map[ResourceID]Resource{"a": {Attributes: newTestResource("a")}}, time.Now())
+		assert.NoError(t, err)
+
+		second, err := newState(map[ResourceID]Resource{"a": {Attributes: newTestResource("a")}}, time.Now())
+		assert.NoError(t, err)
+
+		assert.Equal(t, first.Resources["a"].AttributesHash, second.Resources["a"].AttributesHash)
+	})
+}
+
+func TestDrift(t *testing.T) {
+	t.Parallel()
+
+	last := State{Resources: map[ResourceID]ResourceState{
+		"applied":   {Status: Success},
+		"unchanged": {Status: Success},
+		"failed":    {Status: Failed},
+		"removed":   {ResourceKind: "File", Description: "/etc/motd", Status: Success},
+		"gone":      {Status: Failed},
+	}}
+
+	resources := map[ResourceID]Resource{
+		"applied":   {ResourceID: "applied", Attributes: testResource, Outcome: OutcomeChanged},
+		"unchanged": {ResourceID: "unchanged", Attributes: testResource, Outcome: OutcomeUnchanged},
+		"failed":    {ResourceID: "failed", Attributes: testResource, Outcome: OutcomeChanged},
+		"new":       {ResourceID: "new", Attributes: testResource, Outcome: OutcomeChanged},
+	}
+
+	reasons := make(map[string]DriftReason)
+	for _, d := range last.drift(resources) {
+		reasons[d.ResourceID] = d.Reason
+	}
+
+	assert.Equal(t, map[string]DriftReason{
+		"applied": DriftDrifted,
+		"failed":  DriftNotApplied,
+		"new":     DriftNotApplied,
+		"removed": DriftRemoved,
+	}, reasons)
+}
@@ -0,0 +1,307 @@
+package viaduct
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report formats, for --report and SetReports.
+const (
+	ReportJUnit = "junit"
+	ReportTAP   = "tap"
+)
+
+// parseReport splits a report given as format:path, such as
+// junit:/tmp/viaduct.xml.
+func parseReport(report string) (format, path string, err error) {
+	format, path, ok := strings.Cut(report, ":")
+	if !ok || path == "" {
+		return "", "", fmt.Errorf("report %q is not format:path, such as %s:viaduct.xml", report, ReportJUnit)
+	}
+
+	switch format {
+	case ReportJUnit, ReportTAP:
+		return format, path, nil
+	default:
+		return "", "", fmt.Errorf("unknown report format %q: use %s or %s", format, ReportJUnit, ReportTAP)
+	}
+}
+
+// writeReports writes the report to each file given with the Reports option.
+func (m *Manifest) writeReports(report *RunReport) error {
+	for _, r := range m.rt.Options.Reports {
+		format, path, err := parseReport(r)
+		if err != nil {
+			return err
+		}
+
+		if err := writeReportFile(m.rt.ExpandPath(path), format, report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeReportFile(path, format string, report *RunReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case ReportJUnit:
+		err = report.WriteJUnit(f)
+	case ReportTAP:
+		err = report.WriteTAP(f)
+	}
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// testCase is a resource as a CI system sees it: a test that passed, failed or
+// was skipped.
+type testCase struct {
+	name     string
+	kind     string
+	duration time.Duration
+	// failure is the error of a resource that failed, and skipped why a
+	// resource did not run.
+	failure string
+	skipped string
+	// output is what the resource logged.
+	output string
+}
+
+// testCases turns the resources in the report into test cases, in the order
+// the report has them, which is by resource ID. A resource that was not
+// applied because a dependency failed is skipped, naming the resource whose
+// failure caused it.
+func (r *RunReport) testCases() []testCase {
+	failures := make(map[string]FailureSummary)
+	roots := make(map[string]FailureSummary)
+	for _, f := range r.Failures {
+		failures[f.ResourceID] = f
+		for _, d := range f.Dependents {
+			roots[d.ResourceID] = f
+		}
+	}
+
+	cases := make([]testCase, 0, len(r.Resources))
+
+	for _, res := range r.Resources {
+		c := testCase{
+			name:     fmt.Sprintf("%s [%s] %s", res.ResourceKind, res.Operation, res.Description),
+			kind:     res.ResourceKind,
+			duration: res.Timing.Duration(),
+			output:   formatEntries(res.Log),
+		}
+
+		switch Status(res.Status) {
+		case Failed:
+			if res.Ignored {
+				c.output = "failure ignored: " + res.Error + "\n" + c.output
+			} else {
+				c.failure = res.Error
+			}
+
+			// Without a log, what the command that failed wrote is the next
+			// best thing
+			if c.output == "" {
+				c.output = commandOutput(failures[res.ResourceID])
+			}
+		case DependencyFailed:
+			if root, ok := roots[res.ResourceID]; ok {
+				c.skipped = fmt.Sprintf("dependency failed: %s [%s] %s (%s)", root.ResourceKind, root.Operation, root.Description, root.ResourceID)
+			} else {
+				c.skipped = res.Error
+			}
+		case Skipped:
+			c.skipped = "skipped"
+		}
+
+		cases = append(cases, c)
+	}
+
+	return cases
+}
+
+// commandOutput writes what the command a resource failed on wrote, as
+// formatEntries writes a field that spans lines.
+func commandOutput(f FailureSummary) string {
+	var b strings.Builder
+
+	for _, out := range []struct{ name, text string }{{"stdout", f.Stdout}, {"stderr", f.Stderr}} {
+		if out.text != "" {
+			b.WriteString(out.name + ":\n" + strings.TrimRight(out.text, "\n") + "\n")
+		}
+	}
+
+	return b.String()
+}
+
+// formatEntries writes log entries as plain lines, with any field that spans
+// lines, such as the output of a command, underneath.
+func formatEntries(entries []LogEntry) string {
+	var b strings.Builder
+
+	for _, e := range entries {
+		var blocks []string
+
+		fmt.Fprintf(&b, "%s %s", e.Level, e.Message)
+
+		for _, k := range slices.Sorted(maps.Keys(e.Fields)) {
+			v := fmt.Sprint(e.Fields[k])
+
+			if strings.Contains(strings.TrimRight(v, "\n"), "\n") {
+				blocks = append(blocks, k+":\n"+strings.TrimRight(v, "\n"))
+				continue
+			}
+
+			fmt.Fprintf(&b, " %s=%s", k, strings.TrimRight(v, "\n"))
+		}
+
+		b.WriteString("\n")
+
+		for _, block := range blocks {
+			b.WriteString(block + "\n")
+		}
+	}
+
+	return b.String()
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr,omitempty"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML, with each resource as a test case
+// named by its kind, operation and description. A failed resource carries its
+// error and what it logged, and a resource that did not run is skipped.
+func (r *RunReport) WriteJUnit(w io.Writer) error {
+	suite := junitSuite{Name: "viaduct", Time: seconds(r.Finished.Sub(r.Started))}
+	if !r.Started.IsZero() {
+		suite.Timestamp = r.Started.Format(time.RFC3339)
+	}
+
+	for _, c := range r.testCases() {
+		jc := junitCase{Name: c.name, ClassName: c.kind, Time: seconds(c.duration)}
+
+		switch {
+		case c.failure != "":
+			jc.Failure = &junitMessage{Message: c.failure, Body: c.output}
+			suite.Failures++
+		case c.skipped != "":
+			jc.Skipped = &junitMessage{Message: c.skipped}
+			jc.SystemOut = c.output
+			suite.Skipped++
+		default:
+			jc.SystemOut = c.output
+		}
+
+		suite.Cases = append(suite.Cases, jc)
+	}
+
+	suite.Tests = len(suite.Cases)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
+
+// WriteTAP writes the report as TAP version 13, with each resource as a test
+// named by its kind, operation and description. A failed resource has its
+// error and what it logged in a YAML block under it, and a resource that did
+// not run is marked SKIP.
+func (r *RunReport) WriteTAP(w io.Writer) error {
+	cases := r.testCases()
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "TAP version 13\n1..%d\n", len(cases))
+
+	for i, c := range cases {
+		// A # would start a directive, and a new line a line of its own
+		name := strings.NewReplacer("#", `\#`, "\n", " ").Replace(c.name)
+
+		switch {
+		case c.failure != "":
+			fmt.Fprintf(&b, "not ok %d - %s\n", i+1, name)
+			b.WriteString("  ---\n")
+			fmt.Fprintf(&b, "  message: %s\n", strconv.Quote(c.failure))
+			b.WriteString("  severity: fail\n")
+			fmt.Fprintf(&b, "  duration_ms: %d\n", c.duration.Milliseconds())
+
+			if c.output != "" {
+				b.WriteString("  log: |\n")
+				for _, line := range strings.Split(strings.TrimRight(c.output, "\n"), "\n") {
+					fmt.Fprintf(&b, "    %s\n", line)
+				}
+			}
+
+			b.WriteString("  ...\n")
+		case c.skipped != "":
+			fmt.Fprintf(&b, "ok %d - %s # SKIP %s\n", i+1, name, strings.ReplaceAll(c.skipped, "\n", " "))
+		default:
+			fmt.Fprintf(&b, "ok %d - %s\n", i+1, name)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// seconds formats a duration as JUnit has it, in seconds.
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,208 @@
+package viaduct
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestReport returns the report of a run where a command failed, taking a
+// resource that depends on it down with it
+func newTestReport() *RunReport {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ran := func(ms int) Timing {
+		return Timing{Started: at, Finished: at.Add(time.Duration(ms) * time.Millisecond)}
+	}
+
+	return &RunReport{
+		Status:   "failed",
+		Started:  at,
+		Finished: at.Add(2 * time.Second),
+		Resources: []ResourceResult{
+			{
+				ResourceID: "File.Create:/etc/motd", ResourceKind: "File", Operation: "Create", Description: "/etc/motd",
+				Status: string(Success), Outcome: string(OutcomeChanged), Timing: ran(3),
+				Log: []LogEntry{{Level: string(LevelOK), Message: "created", Fields: map[string]any{"path": "/etc/motd"}}},
+			},
+			{
+				ResourceID: "build", ResourceKind: "Execute", Operation: "Run", Description: "make",
+				Status: string(Failed), Outcome: string(OutcomeUnchanged), Timing: ran(1500),
+				Error: "command failed: make: exit status 2: no rule",
+				Log: []LogEntry{
+					{Level: string(LevelOK), Message: "started", Fields: map[string]any{"command": "make"}},
+					{Level: string(LevelOutput), Message: "command-output", Fields: map[string]any{"command": "make", "stderr": "make: *** \"install\"\nno rule\n"}},
+				},
+			},
+			{
+				ResourceID: "install", ResourceKind: "Execute", Operation: "Run", Description: "make install",
+				Status: string(DependencyFailed), Outcome: string(OutcomeSkipped), Timing: Timing{Finished: at},
+				Error: "dependency build failed",
+			},
+			{
+				ResourceID: "handler", ResourceKind: "Service", Operation: "Restart", Description: "nginx",
+				Status: string(Skipped), Outcome: string(OutcomeSkipped), Timing: Timing{Finished: at},
+			},
+		},
+		Failures: []FailureSummary{{
+			ResourceID: "build", ResourceKind: "Execute", Operation: "Run", Description: "make",
+			Status: string(Failed), Error: "command failed: make: exit status 2: no rule",
+			Dependents: []FailureDependent{{ResourceID: "install", ResourceKind: "Execute", Operation: "Run", Description: "make install"}},
+		}},
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	assert.NoError(t, newTestReport().WriteJUnit(&b))
+
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="viaduct" tests="4" failures="1" errors="0" skipped="2" time="2.000" timestamp="2024-05-01T12:00:00Z">
+    <testcase name="File [Create] /etc/motd" classname="File" time="0.003">
+      <system-out>OK created path=/etc/motd&#xA;</system-out>
+    </testcase>
+    <testcase name="Execute [Run] make" classname="Execute" time="1.500">
+      <failure message="command failed: make: exit status 2: no rule">OK started command=make&#xA;OUTPUT command-output command=make&#xA;stderr:&#xA;make: *** &#34;install&#34;&#xA;no rule&#xA;</failure>
+    </testcase>
+    <testcase name="Execute [Run] make install" classname="Execute" time="0.000">
+      <skipped message="dependency failed: Execute [Run] make (build)"></skipped>
+    </testcase>
+    <testcase name="Service [Restart] nginx" classname="Service" time="0.000">
+      <skipped message="skipped"></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>
+`, b.String())
+}
+
+func TestWriteTAP(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	assert.NoError(t, newTestReport().WriteTAP(&b))
+
+	assert.Equal(t, `TAP version 13
+1..4
+ok 1 - File [Create] /etc/motd
+not ok 2 - Execute [Run] make
+  ---
+  message: "command failed: make: exit status 2: no rule"
+  severity: fail
+  duration_ms: 1500
+  log: |
+    OK started command=make
+    OUTPUT command-output command=make
+    stderr:
+    make: *** "install"
+    no rule
+  ...
+ok 3 - Execute [Run] make install # SKIP dependency failed: Execute [Run] make (build)
+ok 4 - Service [Restart] nginx # SKIP skipped
+`, b.String())
+}
+
+func TestTestCases(t *testing.T) {
+	t.Parallel()
+
+	t.Run("the output of a failed command without a log", func(t *testing.T) {
+		t.Parallel()
+
+		report := &RunReport{
+			Resources: []ResourceResult{{ResourceID: "build", ResourceKind: "Execute", Operation: "Run", Description: "make",
+				Status: string(Failed), Error: "command failed: make: exit status 2: no rule"}},
+			Failures: []FailureSummary{{ResourceID: "build", ResourceKind: "Execute", Operation: "Run", Description: "make",
+				Status: string(Failed), Error: "command failed: make: exit status 2: no rule", Stdout: "building\n", Stderr: "no rule\n"}},
+		}
+
+		cases := report.testCases()
+		if assert.Len(t, cases, 1) {
+			assert.Equal(t, "stdout:\nbuilding\nstderr:\nno rule\n", cases[0].output)
+		}
+	})
+}
+
+func TestParseReport(t *testing.T) {
+	t.Parallel()
+
+	format, path, err := parseReport("junit:out/viaduct.xml")
+	assert.NoError(t, err)
+	assert.Equal(t, ReportJUnit, format)
+	assert.Equal(t, "out/viaduct.xml", path)
+
+	_, _, err = parseReport("viaduct.xml")
+	assert.EqualError(t, err, `report "viaduct.xml" is not format:path, such as junit:viaduct.xml`)
+
+	_, _, err = parseReport("xunit:viaduct.xml")
+	assert.EqualError(t, err, `unknown report format "xunit": use junit or tap`)
+}
+
+func TestReports(t *testing.T) {
+	t.Parallel()
+
+	t.Run("written once the run has finished", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		junit := filepath.Join(dir, "viaduct.xml")
+		tap := filepath.Join(dir, "viaduct.tap")
+
+		rt, _ := newSinkRuntime(Options{Reports: []string{"junit:" + junit, "tap:" + tap}})
+		m := NewWithRuntime(rt)
+		m.DisableState()
+		addNamed(m, "a", newTestResource("a"))
+		addNamed(m, "b", newFailingTestResource("b"))
+
+		_, err := m.Apply(context.Background())
+		assert.ErrorIs(t, err, ErrResourcesFailed)
+
+		content, err := os.ReadFile(junit)
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), `<testsuite name="viaduct" tests="2" failures="1"`)
+		assert.Contains(t, string(content), `<failure message="failed">`)
+
+		content, err = os.ReadFile(tap)
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), "1..2\n")
+		assert.Contains(t, string(content), "not ok")
+	})
+
+	t.Run("with what was logged, however quiet the console is", func(t *testing.T) {
+		t.Parallel()
+
+		junit := filepath.Join(t.TempDir(), "viaduct.xml")
+
+		rt, _ := newSinkRuntime(Options{Reports: []string{"junit:" + junit}, Quiet: true})
+		m := NewWithRuntime(rt)
+		m.DisableState()
+		addNamed(m, "a", newChangingTestResource("a"))
+
+		_, err := m.Apply(context.Background())
+		assert.NoError(t, err)
+
+		content, err := os.ReadFile(junit)
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), "<system-out>OK changed&#xA;</system-out>")
+	})
+
+	t.Run("an unknown format stops the run", func(t *testing.T) {
+		t.Parallel()
+
+		rt, _ := newSinkRuntime(Options{Reports: []string{"xunit:viaduct.xml"}})
+		m := NewWithRuntime(rt)
+		m.DisableState()
+		a := newTestResource("a")
+		m.Add(a)
+
+		report, err := m.Apply(context.Background())
+		assert.Nil(t, report)
+		assert.Error(t, err)
+		assert.False(t, a.ran.Load())
+	})
+}